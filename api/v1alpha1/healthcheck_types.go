@@ -0,0 +1,65 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+// HealthChecksSpec tunes the readiness and liveness probes the operator sets on the container of a deployment.
+// It is shared by the Memcached and Webserver APIs.
+type HealthChecksSpec struct {
+	// +optional
+	// Readiness tunes the readiness probe, which opens a TCP connection to the container. A pod only receives
+	// traffic from its Service while it is ready
+	Readiness *HealthCheckSpec `json:"readiness,omitempty"`
+
+	// +optional
+	// Liveness tunes the liveness probe, which speaks the protocol of the container. The kubelet restarts a
+	// container that fails it
+	Liveness *HealthCheckSpec `json:"liveness,omitempty"`
+}
+
+// HealthCheckSpec tunes a probe of a container
+type HealthCheckSpec struct {
+	// +optional
+	// Disabled removes the probe
+	Disabled bool `json:"disabled,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	// InitialDelaySeconds is how long after the container starts the probe starts. Defaults to 0 for readiness
+	// and 10 for liveness
+	InitialDelaySeconds *int32 `json:"initialDelaySeconds,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	// PeriodSeconds is how often the probe runs. Defaults to 10 seconds
+	PeriodSeconds int32 `json:"periodSeconds,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	// TimeoutSeconds is how long a single probe may take. Defaults to 1 second for readiness and 5 for liveness
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	// FailureThreshold is how many probes in a row must fail for the container to be unready, or restarted. Defaults to 3
+	FailureThreshold int32 `json:"failureThreshold,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	// SuccessThreshold is how many probes in a row must succeed for the container to be ready again. Defaults to 1.
+	// Liveness only supports 1
+	SuccessThreshold int32 `json:"successThreshold,omitempty"`
+}
@@ -0,0 +1,45 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	autoscalingv2beta2 "k8s.io/api/autoscaling/v2beta2"
+)
+
+// HorizontalPodAutoscalerSpec configures the HorizontalPodAutoscaler the operator creates for a deployment.
+// It is shared by the Memcached and Webserver APIs. The HorizontalPodAutoscaler is created with the
+// autoscaling/v2beta2 API, which has the same metrics and behavior as autoscaling/v2 and is served by
+// every cluster this operator supports.
+type HorizontalPodAutoscalerSpec struct {
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	// MinReplicas is the lower limit of the number of replicas. Defaults to spec.size, and at least 1
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// MaxReplicas is the upper limit of the number of replicas
+	MaxReplicas int32 `json:"maxReplicas"`
+
+	// +kubebuilder:validation:MinItems=1
+	// Metrics are the metrics the desired number of replicas is computed from. Utilization targets need resource
+	// requests, and only the memcached pods request memory, so use averageValue targets or pod and external metrics otherwise
+	Metrics []autoscalingv2beta2.MetricSpec `json:"metrics"`
+
+	// +optional
+	// Behavior configures the scaling behavior in the up and down directions
+	Behavior *autoscalingv2beta2.HorizontalPodAutoscalerBehavior `json:"behavior,omitempty"`
+}
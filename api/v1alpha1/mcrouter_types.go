@@ -0,0 +1,113 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// McRouterSpec defines the desired state of McRouter
+type McRouterSpec struct {
+	// +kubebuilder:validation:Minimum=1
+	// Size is the number of mcrouter pods
+	Size int32 `json:"size"`
+
+	// +kubebuilder:validation:MinItems=1
+	// Pools are the Memcacheds mcrouter routes to, in the namespace of the McRouter. Keys are spread over the
+	// ready pods of each pool with consistent hashing. A pool that sets allowedClients must allow the mcrouter
+	// pods, labelled app: mcrouter and mcrouter_cr: <name>
+	Pools []McRouterPool `json:"pools"`
+
+	// +optional
+	// Mode is either Failover (default), which sends every request to the first pool with ready pods, or
+	// Replicate, which sends sets and deletes to every pool, and gets to the first pool that answers
+	Mode McRouterMode `json:"mode,omitempty"`
+
+	// +optional
+	// Security overrides the restricted security context the mcrouter pods run with
+	Security *PodSecuritySpec `json:"security,omitempty"`
+}
+
+// McRouterPool is a Memcached mcrouter routes to
+type McRouterPool struct {
+	// Name is the name of the Memcached
+	Name string `json:"name"`
+}
+
+// McRouterMode selects how mcrouter routes the requests over its pools
+// +kubebuilder:validation:Enum=Failover;Replicate
+type McRouterMode string
+
+const (
+	// FailoverMcRouterMode sends every request to the first pool with ready pods
+	FailoverMcRouterMode McRouterMode = "Failover"
+	// ReplicateMcRouterMode sends sets and deletes to every pool, and gets to the first pool that answers
+	ReplicateMcRouterMode McRouterMode = "Replicate"
+)
+
+// McRouterStatus defines the observed state of McRouter
+type McRouterStatus struct {
+	// +optional
+	// Nodes are the names of the mcrouter pods
+	Nodes []string `json:"nodes,omitempty"`
+
+	// +optional
+	// Pools are the servers of every pool in the current route configuration
+	Pools []McRouterPoolStatus `json:"pools,omitempty"`
+
+	// +optional
+	// Reason explains why a pool is left out of the route configuration
+	Reason string `json:"reason,omitempty"`
+}
+
+// McRouterPoolStatus is a pool in the route configuration of a McRouter
+type McRouterPoolStatus struct {
+	// Name is the name of the Memcached
+	Name string `json:"name"`
+
+	// +optional
+	// Servers are the addresses of the ready pods of the Memcached
+	Servers []string `json:"servers,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+// McRouter is the Schema for the mcrouters API
+// +kubebuilder:printcolumn:name="Size",type=integer,JSONPath=".spec.size"
+// +kubebuilder:printcolumn:name="Mode",type=string,JSONPath=".spec.mode"
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=".metadata.creationTimestamp"
+type McRouter struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   McRouterSpec   `json:"spec,omitempty"`
+	Status McRouterStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// McRouterList contains a list of McRouter
+type McRouterList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []McRouter `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&McRouter{}, &McRouterList{})
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package v1alpha1
 
 import (
+	autoscalingv2beta2 "k8s.io/api/autoscaling/v2beta2"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -26,14 +27,316 @@ import (
 // MemcachedSpec defines the desired state of Memcached
 type MemcachedSpec struct {
 	// +kubebuilder:validation:Minimum=0
-	// Size is the size of the memcached deployment
+	// Size is the size of the memcached deployment. It is the initial size when autoscaling is enabled
 	Size int32 `json:"size"`
+
+	// +kubebuilder:validation:Minimum=16
+	// +optional
+	// MemoryMB is the memory each memcached pod caches items in. Defaults to 64
+	MemoryMB int32 `json:"memoryMB,omitempty"`
+
+	// +optional
+	// Autoscaling grows and shrinks the memcached deployment on its evictions, memory utilization and hit ratio.
+	// The deployment is kept at spec.size when unset
+	Autoscaling *MemcachedAutoscalingSpec `json:"autoscaling,omitempty"`
+
+	// +optional
+	// Schedules are recurring time windows that bound the size of the memcached deployment
+	Schedules []ScalingSchedule `json:"schedules,omitempty"`
+
+	// +optional
+	// HorizontalPodAutoscaler leaves the scaling to a HorizontalPodAutoscaler the operator creates. The operator
+	// stops changing the replicas of the deployment, and ignores autoscaling, the schedules and the sizing replicas
+	HorizontalPodAutoscaler *HorizontalPodAutoscalerSpec `json:"horizontalPodAutoscaler,omitempty"`
+
+	// +optional
+	// Sizing analyzes the stats of the memcached pods, and recommends the memory per pod and number of replicas
+	Sizing *MemcachedSizingSpec `json:"sizing,omitempty"`
+
+	// +optional
+	// Auth requires clients to authenticate with SASL. Memcached is open to every client when unset
+	Auth *MemcachedAuthSpec `json:"auth,omitempty"`
+
+	// +optional
+	// TLS encrypts the connections to memcached. Memcached speaks plaintext when unset
+	TLS *MemcachedTLSSpec `json:"tls,omitempty"`
+
+	// +optional
+	// AllowedClients are the only pods that may connect to memcached, besides the operator. Every pod may connect
+	// when unset, while an empty list only lets the operator in
+	AllowedClients []MemcachedClientSelector `json:"allowedClients,omitempty"`
+
+	// +optional
+	// Security overrides the restricted security context the memcached pods run with
+	Security *PodSecuritySpec `json:"security,omitempty"`
+
+	// +optional
+	// HealthChecks tunes the probes of the memcached container. The liveness probe sends the version command,
+	// unless SASL or TLS is enabled, in which case it only opens a TCP connection
+	HealthChecks HealthChecksSpec `json:"healthChecks,omitempty"`
+
+	// +optional
+	// ServerList configures the ConfigMap <name>-servers, which lists the ready memcached pods for clients
+	// without a proxy
+	ServerList MemcachedServerListSpec `json:"serverList,omitempty"`
+}
+
+// MemcachedServerListSpec configures the server list of a Memcached. The ConfigMap holds the addresses of the
+// ready pods under the key servers, one per line, in the order of their first point on a ketama ring, so a new
+// server never reorders the others. The pods are addressed by their hostname in the headless Service of the
+// Memcached, e.g. 10-0-0-1.<name>.<namespace>.svc:11211, which the TLS certificate covers. The key generation is
+// only incremented when the servers change.
+type MemcachedServerListSpec struct {
+	// +optional
+	// ConsumerNamespaces are other namespaces the ConfigMap is copied to, for the clients in them
+	ConsumerNamespaces []string `json:"consumerNamespaces,omitempty"`
+}
+
+// MemcachedClientSelector selects pods allowed to connect to memcached, like the peer of a NetworkPolicy
+type MemcachedClientSelector struct {
+	// +optional
+	// PodSelector selects the pods, in the namespace of the Memcached unless NamespaceSelector is set
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+
+	// +optional
+	// NamespaceSelector selects the namespaces of the pods. Every pod in them is selected unless PodSelector is set
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+}
+
+// MemcachedTLSSpec configures the certificates memcached serves TLS with. The certificate is stored in the
+// Secret <name>-tls, and is valid for <name>.<namespace>.svc and *.<name>.<namespace>.svc
+type MemcachedTLSSpec struct {
+	// +optional
+	// IssuerRef selects a cert-manager Issuer or ClusterIssuer that issues the certificate. When unset, the operator
+	// issues the certificate itself from a CA of the Memcached, stored in the Secret <name>-ca
+	IssuerRef *CertificateIssuerReference `json:"issuerRef,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	// DurationDays is how long a certificate is valid. Defaults to 90 days
+	DurationDays int32 `json:"durationDays,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	// RenewBeforeDays is how long before it expires a certificate is renewed, and the pods roll to it. Defaults to 30 days
+	RenewBeforeDays int32 `json:"renewBeforeDays,omitempty"`
+}
+
+// CertificateIssuerReference selects a cert-manager issuer
+type CertificateIssuerReference struct {
+	// Name is the name of the issuer
+	Name string `json:"name"`
+
+	// +kubebuilder:validation:Enum=Issuer;ClusterIssuer
+	// +optional
+	// Kind is either Issuer (default), in the namespace of the Memcached, or ClusterIssuer
+	Kind string `json:"kind,omitempty"`
+
+	// +optional
+	// Group is the API group of the issuer. Defaults to cert-manager.io
+	Group string `json:"group,omitempty"`
+}
+
+// MemcachedAuthSpec configures the SASL authentication of a Memcached
+type MemcachedAuthSpec struct {
+	// SecretName is the name of a Secret in the namespace of the Memcached holding the users allowed to connect.
+	// Every key is a username, and its value the password. The pods roll when the Secret changes
+	SecretName string `json:"secretName"`
+
+	// +optional
+	// ClientUsername is the user whose credentials are published in the client Secret <name>-client for the
+	// consumers of the cache. Defaults to the first username in alphabetical order
+	ClientUsername string `json:"clientUsername,omitempty"`
+}
+
+// MemcachedSizingSpec configures the vertical sizing recommendations of a Memcached
+type MemcachedSizingSpec struct {
+	// +optional
+	// Mode is either Recommend (default), which only reports the recommendation in the status, or Apply,
+	// which also rolls the recommended memory out to the pods. In Apply mode the recommended number of
+	// replicas replaces spec.size, unless autoscaling is enabled
+	Mode SizingMode `json:"mode,omitempty"`
+
+	// +kubebuilder:validation:Minimum=16
+	// +optional
+	// MinMemoryMB is the smallest memory per pod that is recommended. Defaults to 64
+	MinMemoryMB int32 `json:"minMemoryMB,omitempty"`
+
+	// +kubebuilder:validation:Minimum=16
+	// +optional
+	// MaxMemoryMB is the largest memory per pod that is recommended. More replicas are recommended beyond it.
+	// Defaults to 4096
+	MaxMemoryMB int32 `json:"maxMemoryMB,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	// TargetMemoryUtilizationPercent is the share of the memory of the pool the working set should take. Defaults to 80
+	TargetMemoryUtilizationPercent int32 `json:"targetMemoryUtilizationPercent,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	// MinApplyIntervalMinutes is the minimum time between two rollouts in Apply mode. Every rollout restarts the
+	// pods or moves keys between them, which empties their cache. Defaults to 60 minutes
+	MinApplyIntervalMinutes int32 `json:"minApplyIntervalMinutes,omitempty"`
+}
+
+// SizingMode selects what is done with a sizing recommendation
+// +kubebuilder:validation:Enum=Recommend;Apply
+type SizingMode string
+
+const (
+	// RecommendSizingMode only reports the recommendation in the status
+	RecommendSizingMode SizingMode = "Recommend"
+	// ApplySizingMode rolls the recommendation out to the memcached deployment
+	ApplySizingMode SizingMode = "Apply"
+)
+
+// MemcachedAutoscalingSpec configures the autoscaling of a Memcached from the stats of its pods
+type MemcachedAutoscalingSpec struct {
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	// MinReplicas is the smallest size the deployment is scaled down to. Defaults to spec.size
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// MaxReplicas is the largest size the deployment is scaled up to
+	MaxReplicas int32 `json:"maxReplicas"`
+
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	// TargetEvictionsPerSecond is the eviction rate of the whole pool above which it is scaled up. Defaults to 1
+	TargetEvictionsPerSecond *int32 `json:"targetEvictionsPerSecond,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	// TargetMemoryUtilizationPercent is the share of the memory limit of the pool in use above which it is
+	// scaled up. Defaults to 90
+	TargetMemoryUtilizationPercent int32 `json:"targetMemoryUtilizationPercent,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	// MinHitRatioPercent is the hit ratio the pool must keep for it to be scaled down. Defaults to 90
+	MinHitRatioPercent *int32 `json:"minHitRatioPercent,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	// ScaleDownDelaySeconds is how long to wait after the last scaling before scaling down, so hot data is not
+	// flushed by shrinking the pool too eagerly. The pool shrinks by one pod at a time. Defaults to 600 seconds
+	ScaleDownDelaySeconds *int32 `json:"scaleDownDelaySeconds,omitempty"`
+}
+
+// MemcachedAutoscalingStatus is the latest state of the autoscaler of a Memcached
+type MemcachedAutoscalingStatus struct {
+	// +optional
+	// EvictionsPerSecond is the eviction rate of the whole pool
+	EvictionsPerSecond string `json:"evictionsPerSecond,omitempty"`
+
+	// +optional
+	// MemoryUtilizationPercent is the share of the memory limit of the pool in use
+	MemoryUtilizationPercent int32 `json:"memoryUtilizationPercent,omitempty"`
+
+	// +optional
+	// HitRatioPercent is the share of gets that were hits. Unset when there were no gets
+	HitRatioPercent *int32 `json:"hitRatioPercent,omitempty"`
+
+	// +optional
+	// DesiredReplicas is the number of replicas the autoscaler wants the deployment to have
+	DesiredReplicas int32 `json:"desiredReplicas,omitempty"`
+
+	// +optional
+	// Reason explains the number of desired replicas
+	Reason string `json:"reason,omitempty"`
+
+	// +optional
+	// LastScaleTime is when the autoscaler last changed the size of the deployment
+	LastScaleTime *metav1.Time `json:"lastScaleTime,omitempty"`
+}
+
+// MemcachedSizingStatus is the latest sizing recommendation of a Memcached
+type MemcachedSizingStatus struct {
+	// +optional
+	// WorkingSetMB is the memory taken by the items in the pool, including the overhead of the slab allocator
+	WorkingSetMB int64 `json:"workingSetMB,omitempty"`
+
+	// +optional
+	// CurrentMemoryMB is the memory per pod the pool is running with
+	CurrentMemoryMB int32 `json:"currentMemoryMB,omitempty"`
+
+	// +optional
+	// RecommendedMemoryMB is the recommended memory per pod
+	RecommendedMemoryMB int32 `json:"recommendedMemoryMB,omitempty"`
+
+	// +optional
+	// RecommendedReplicas is the recommended number of replicas
+	RecommendedReplicas int32 `json:"recommendedReplicas,omitempty"`
+
+	// +optional
+	// Reason explains the recommendation
+	Reason string `json:"reason,omitempty"`
+
+	// +optional
+	// AppliedMemoryMB is the memory per pod last rolled out in Apply mode
+	AppliedMemoryMB int32 `json:"appliedMemoryMB,omitempty"`
+
+	// +optional
+	// AppliedReplicas is the number of replicas last rolled out in Apply mode
+	AppliedReplicas int32 `json:"appliedReplicas,omitempty"`
+
+	// +optional
+	// LastAppliedTime is when a recommendation was last rolled out in Apply mode
+	LastAppliedTime *metav1.Time `json:"lastAppliedTime,omitempty"`
 }
 
 // MemcachedStatus defines the observed state of Memcached
 type MemcachedStatus struct {
 	// Nodes are the names of the memcached pods
 	Nodes []string `json:"nodes"`
+
+	// +optional
+	// ActiveSchedules are the names of the schedules whose window is currently active
+	ActiveSchedules []string `json:"activeSchedules,omitempty"`
+
+	// +optional
+	// Autoscaling is the latest state of the autoscaler, when autoscaling is enabled
+	Autoscaling *MemcachedAutoscalingStatus `json:"autoscaling,omitempty"`
+
+	// +optional
+	// HorizontalPodAutoscaler is the status of the HorizontalPodAutoscaler, when one is configured
+	HorizontalPodAutoscaler *autoscalingv2beta2.HorizontalPodAutoscalerStatus `json:"horizontalPodAutoscaler,omitempty"`
+
+	// +optional
+	// Sizing is the latest sizing recommendation, when sizing is enabled
+	Sizing *MemcachedSizingStatus `json:"sizing,omitempty"`
+
+	// +optional
+	// TLS is the state of the certificate memcached serves, when TLS is enabled
+	TLS *MemcachedTLSStatus `json:"tls,omitempty"`
+
+	// +optional
+	// Conditions are the latest observations of the state of the Memcached
+	Conditions []Condition `json:"conditions,omitempty"`
+
+	// +optional
+	// ServerListGeneration is the generation of the server list in the ConfigMap <name>-servers
+	ServerListGeneration int64 `json:"serverListGeneration,omitempty"`
+}
+
+// MemcachedTLSStatus is the state of the certificate of a Memcached
+type MemcachedTLSStatus struct {
+	// +optional
+	// Issuer is who issued the certificate, either the operator or the cert-manager issuer
+	Issuer string `json:"issuer,omitempty"`
+
+	// +optional
+	// NotAfter is when the certificate expires
+	NotAfter *metav1.Time `json:"notAfter,omitempty"`
+
+	// +optional
+	// Reason explains why memcached does not serve TLS yet, e.g. while cert-manager issues the certificate
+	Reason string `json:"reason,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -0,0 +1,51 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+// ScalingSchedule is a recurring time window with its own bounds on the number of replicas.
+// It is shared by the Memcached and Webserver APIs.
+type ScalingSchedule struct {
+	// Name identifies the schedule in the status
+	Name string `json:"name"`
+
+	// Cron is a standard five field cron expression for the start of the window, e.g. "0 8 * * 1-5"
+	Cron string `json:"cron"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=10080
+	// DurationMinutes is how long the window lasts after it starts, at most one week
+	DurationMinutes int32 `json:"durationMinutes"`
+
+	// +optional
+	// TimeZone is the IANA time zone the cron expression is evaluated in, e.g. Europe/Oslo. Defaults to UTC
+	TimeZone string `json:"timeZone,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	// MinReplicas is the minimum number of replicas during the window
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	// MaxReplicas is the maximum number of replicas during the window
+	MaxReplicas *int32 `json:"maxReplicas,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	// Size is a fixed number of replicas during the window. It takes precedence over minReplicas and maxReplicas
+	Size *int32 `json:"size,omitempty"`
+}
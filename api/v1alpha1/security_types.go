@@ -0,0 +1,93 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PodSecuritySpec overrides the security context the operator runs the pods of a deployment with. It is shared
+// by the Memcached and Webserver APIs. By default the pods meet the restricted Pod Security Standard: they run
+// as a non-root user, with a read-only root filesystem, without privilege escalation or any capability, and with
+// the RuntimeDefault seccomp profile.
+type PodSecuritySpec struct {
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	// RunAsUser is the UID the containers run as. Defaults to 11211, the memcache user of the memcached image,
+	// for Memcached and to 65534, nobody, for Webserver
+	RunAsUser *int64 `json:"runAsUser,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	// RunAsGroup is the GID the containers run as. Defaults to RunAsUser
+	RunAsGroup *int64 `json:"runAsGroup,omitempty"`
+
+	// +optional
+	// RunAsNonRoot makes the kubelet refuse to start a container running as root. Defaults to true
+	RunAsNonRoot *bool `json:"runAsNonRoot,omitempty"`
+
+	// +optional
+	// ReadOnlyRootFilesystem mounts the root filesystem of the containers read-only, with an emptyDir on /tmp.
+	// Defaults to true
+	ReadOnlyRootFilesystem *bool `json:"readOnlyRootFilesystem,omitempty"`
+
+	// +optional
+	// AllowPrivilegeEscalation lets a process gain more privileges than its parent, e.g. through setuid binaries.
+	// Defaults to false
+	AllowPrivilegeEscalation *bool `json:"allowPrivilegeEscalation,omitempty"`
+
+	// +optional
+	// AddCapabilities are added back to the containers after dropping all capabilities
+	AddCapabilities []corev1.Capability `json:"addCapabilities,omitempty"`
+
+	// +kubebuilder:validation:Enum=RuntimeDefault;Unconfined
+	// +optional
+	// SeccompProfile is the seccomp profile of the pods. Defaults to RuntimeDefault
+	SeccompProfile string `json:"seccompProfile,omitempty"`
+}
+
+const (
+	// RuntimeDefaultSeccompProfile is the default seccomp profile of the container runtime
+	RuntimeDefaultSeccompProfile = "RuntimeDefault"
+	// UnconfinedSeccompProfile runs the pods without seccomp
+	UnconfinedSeccompProfile = "Unconfined"
+)
+
+// Condition is an observation of an aspect of the state of a Memcached or Webserver
+type Condition struct {
+	// Type is the aspect the condition is about, e.g. PodSecurityRestricted
+	Type string `json:"type"`
+
+	// Status is True, False or Unknown
+	Status corev1.ConditionStatus `json:"status"`
+
+	// +optional
+	// Reason is a CamelCase word explaining the status
+	Reason string `json:"reason,omitempty"`
+
+	// +optional
+	// Message explains the status to a human
+	Message string `json:"message,omitempty"`
+
+	// LastTransitionTime is when the status last changed
+	LastTransitionTime metav1.Time `json:"lastTransitionTime"`
+}
+
+// PodSecurityRestrictedCondition is True when the pods meet the restricted Pod Security Standard, and False
+// when the overrides of spec.security weaken them below it
+const PodSecurityRestrictedCondition = "PodSecurityRestricted"
@@ -19,15 +19,370 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"k8s.io/api/autoscaling/v2beta2"
+	"k8s.io/api/core/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ActivitySource) DeepCopyInto(out *ActivitySource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ActivitySource.
+func (in *ActivitySource) DeepCopy() *ActivitySource {
+	if in == nil {
+		return nil
+	}
+	out := new(ActivitySource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GRPCProbe) DeepCopyInto(out *GRPCProbe) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GRPCProbe.
+func (in *GRPCProbe) DeepCopy() *GRPCProbe {
+	if in == nil {
+		return nil
+	}
+	out := new(GRPCProbe)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HTTPHeader) DeepCopyInto(out *HTTPHeader) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HTTPHeader.
+func (in *HTTPHeader) DeepCopy() *HTTPHeader {
+	if in == nil {
+		return nil
+	}
+	out := new(HTTPHeader)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HTTPProbe) DeepCopyInto(out *HTTPProbe) {
+	*out = *in
+	if in.Headers != nil {
+		in, out := &in.Headers, &out.Headers
+		*out = make([]HTTPHeader, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExpectedStatusCodes != nil {
+		in, out := &in.ExpectedStatusCodes, &out.ExpectedStatusCodes
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
+	if in.TLS != nil {
+		in, out := &in.TLS, &out.TLS
+		*out = new(ProbeTLS)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HTTPProbe.
+func (in *HTTPProbe) DeepCopy() *HTTPProbe {
+	if in == nil {
+		return nil
+	}
+	out := new(HTTPProbe)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LatencyBreakdown) DeepCopyInto(out *LatencyBreakdown) {
+	*out = *in
+	in.ProbeTime.DeepCopyInto(&out.ProbeTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LatencyBreakdown.
+func (in *LatencyBreakdown) DeepCopy() *LatencyBreakdown {
+	if in == nil {
+		return nil
+	}
+	out := new(LatencyBreakdown)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LatencyForecast) DeepCopyInto(out *LatencyForecast) {
+	*out = *in
+	in.ForecastTime.DeepCopyInto(&out.ForecastTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LatencyForecast.
+func (in *LatencyForecast) DeepCopy() *LatencyForecast {
+	if in == nil {
+		return nil
+	}
+	out := new(LatencyForecast)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MetricSourceSpec) DeepCopyInto(out *MetricSourceSpec) {
+	*out = *in
+	if in.Prometheus != nil {
+		in, out := &in.Prometheus, &out.Prometheus
+		*out = new(PrometheusMetricSource)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetricSourceSpec.
+func (in *MetricSourceSpec) DeepCopy() *MetricSourceSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MetricSourceSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodLatency) DeepCopyInto(out *PodLatency) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodLatency.
+func (in *PodLatency) DeepCopy() *PodLatency {
+	if in == nil {
+		return nil
+	}
+	out := new(PodLatency)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodReplacementSpec) DeepCopyInto(out *PodReplacementSpec) {
+	*out = *in
+	if in.MinDeletionIntervalSeconds != nil {
+		in, out := &in.MinDeletionIntervalSeconds, &out.MinDeletionIntervalSeconds
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodReplacementSpec.
+func (in *PodReplacementSpec) DeepCopy() *PodReplacementSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PodReplacementSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PredictiveAutoscalingSpec) DeepCopyInto(out *PredictiveAutoscalingSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PredictiveAutoscalingSpec.
+func (in *PredictiveAutoscalingSpec) DeepCopy() *PredictiveAutoscalingSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PredictiveAutoscalingSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ProbeSpec) DeepCopyInto(out *ProbeSpec) {
+	*out = *in
+	if in.PodReplacement != nil {
+		in, out := &in.PodReplacement, &out.PodReplacement
+		*out = new(PodReplacementSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.HTTP != nil {
+		in, out := &in.HTTP, &out.HTTP
+		*out = new(HTTPProbe)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.TCP != nil {
+		in, out := &in.TCP, &out.TCP
+		*out = new(TCPProbe)
+		**out = **in
+	}
+	if in.GRPC != nil {
+		in, out := &in.GRPC, &out.GRPC
+		*out = new(GRPCProbe)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProbeSpec.
+func (in *ProbeSpec) DeepCopy() *ProbeSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ProbeSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ProbeTLS) DeepCopyInto(out *ProbeTLS) {
+	*out = *in
+	if in.CASecretRef != nil {
+		in, out := &in.CASecretRef, &out.CASecretRef
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProbeTLS.
+func (in *ProbeTLS) DeepCopy() *ProbeTLS {
+	if in == nil {
+		return nil
+	}
+	out := new(ProbeTLS)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PrometheusMetricSource) DeepCopyInto(out *PrometheusMetricSource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PrometheusMetricSource.
+func (in *PrometheusMetricSource) DeepCopy() *PrometheusMetricSource {
+	if in == nil {
+		return nil
+	}
+	out := new(PrometheusMetricSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScaleToZeroSpec) DeepCopyInto(out *ScaleToZeroSpec) {
+	*out = *in
+	if in.Activity != nil {
+		in, out := &in.Activity, &out.Activity
+		*out = new(ActivitySource)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScaleToZeroSpec.
+func (in *ScaleToZeroSpec) DeepCopy() *ScaleToZeroSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ScaleToZeroSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScaleToZeroStatus) DeepCopyInto(out *ScaleToZeroStatus) {
+	*out = *in
+	if in.LastActivityTime != nil {
+		in, out := &in.LastActivityTime, &out.LastActivityTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScaleToZeroStatus.
+func (in *ScaleToZeroStatus) DeepCopy() *ScaleToZeroStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ScaleToZeroStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScalingDecision) DeepCopyInto(out *ScalingDecision) {
+	*out = *in
+	in.Time.DeepCopyInto(&out.Time)
+	if in.LatencyMs != nil {
+		in, out := &in.LatencyMs, &out.LatencyMs
+		*out = new(int64)
+		**out = **in
+	}
+	if in.ForecastLatencyMs != nil {
+		in, out := &in.ForecastLatencyMs, &out.ForecastLatencyMs
+		*out = new(int64)
+		**out = **in
+	}
+	if in.ActiveSchedules != nil {
+		in, out := &in.ActiveSchedules, &out.ActiveSchedules
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScalingDecision.
+func (in *ScalingDecision) DeepCopy() *ScalingDecision {
+	if in == nil {
+		return nil
+	}
+	out := new(ScalingDecision)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScalingRecommendation) DeepCopyInto(out *ScalingRecommendation) {
+	*out = *in
+	if in.LatencyMs != nil {
+		in, out := &in.LatencyMs, &out.LatencyMs
+		*out = new(int64)
+		**out = **in
+	}
+	in.Time.DeepCopyInto(&out.Time)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScalingRecommendation.
+func (in *ScalingRecommendation) DeepCopy() *ScalingRecommendation {
+	if in == nil {
+		return nil
+	}
+	out := new(ScalingRecommendation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TCPProbe) DeepCopyInto(out *TCPProbe) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TCPProbe.
+func (in *TCPProbe) DeepCopy() *TCPProbe {
+	if in == nil {
+		return nil
+	}
+	out := new(TCPProbe)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Webserver) DeepCopyInto(out *Webserver) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 }
 
@@ -49,6 +404,79 @@ func (in *Webserver) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WebserverAutoscalingSpec) DeepCopyInto(out *WebserverAutoscalingSpec) {
+	*out = *in
+	if in.MinReplicas != nil {
+		in, out := &in.MinReplicas, &out.MinReplicas
+		*out = new(int32)
+		**out = **in
+	}
+	in.ScaleToZero.DeepCopyInto(&out.ScaleToZero)
+	if in.HorizontalPodAutoscaler != nil {
+		in, out := &in.HorizontalPodAutoscaler, &out.HorizontalPodAutoscaler
+		*out = new(HorizontalPodAutoscalerSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Predictive != nil {
+		in, out := &in.Predictive, &out.Predictive
+		*out = new(PredictiveAutoscalingSpec)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WebserverAutoscalingSpec.
+func (in *WebserverAutoscalingSpec) DeepCopy() *WebserverAutoscalingSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WebserverAutoscalingSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WebserverCacheRef) DeepCopyInto(out *WebserverCacheRef) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WebserverCacheRef.
+func (in *WebserverCacheRef) DeepCopy() *WebserverCacheRef {
+	if in == nil {
+		return nil
+	}
+	out := new(WebserverCacheRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WebserverIngressSpec) DeepCopyInto(out *WebserverIngressSpec) {
+	*out = *in
+	if in.IngressClassName != nil {
+		in, out := &in.IngressClassName, &out.IngressClassName
+		*out = new(string)
+		**out = **in
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WebserverIngressSpec.
+func (in *WebserverIngressSpec) DeepCopy() *WebserverIngressSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WebserverIngressSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *WebserverList) DeepCopyInto(out *WebserverList) {
 	*out = *in
@@ -81,9 +509,51 @@ func (in *WebserverList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WebserverServiceSpec) DeepCopyInto(out *WebserverServiceSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WebserverServiceSpec.
+func (in *WebserverServiceSpec) DeepCopy() *WebserverServiceSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WebserverServiceSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *WebserverSpec) DeepCopyInto(out *WebserverSpec) {
 	*out = *in
+	in.Autoscaling.DeepCopyInto(&out.Autoscaling)
+	if in.Schedules != nil {
+		in, out := &in.Schedules, &out.Schedules
+		*out = make([]ScalingSchedule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	in.Probe.DeepCopyInto(&out.Probe)
+	in.Metric.DeepCopyInto(&out.Metric)
+	out.Service = in.Service
+	if in.Ingress != nil {
+		in, out := &in.Ingress, &out.Ingress
+		*out = new(WebserverIngressSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Security != nil {
+		in, out := &in.Security, &out.Security
+		*out = new(PodSecuritySpec)
+		(*in).DeepCopyInto(*out)
+	}
+	in.HealthChecks.DeepCopyInto(&out.HealthChecks)
+	if in.CacheRef != nil {
+		in, out := &in.CacheRef, &out.CacheRef
+		*out = new(WebserverCacheRef)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WebserverSpec.
@@ -99,6 +569,59 @@ func (in *WebserverSpec) DeepCopy() *WebserverSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *WebserverStatus) DeepCopyInto(out *WebserverStatus) {
 	*out = *in
+	if in.ActiveSchedules != nil {
+		in, out := &in.ActiveSchedules, &out.ActiveSchedules
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.HorizontalPodAutoscaler != nil {
+		in, out := &in.HorizontalPodAutoscaler, &out.HorizontalPodAutoscaler
+		*out = new(v2beta2.HorizontalPodAutoscalerStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ScalingHistory != nil {
+		in, out := &in.ScalingHistory, &out.ScalingHistory
+		*out = make([]ScalingDecision, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Recommendation != nil {
+		in, out := &in.Recommendation, &out.Recommendation
+		*out = new(ScalingRecommendation)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ScaleToZero != nil {
+		in, out := &in.ScaleToZero, &out.ScaleToZero
+		*out = new(ScaleToZeroStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Forecast != nil {
+		in, out := &in.Forecast, &out.Forecast
+		*out = new(LatencyForecast)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.LatencyBreakdown != nil {
+		in, out := &in.LatencyBreakdown, &out.LatencyBreakdown
+		*out = new(LatencyBreakdown)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PodLatencies != nil {
+		in, out := &in.PodLatencies, &out.PodLatencies
+		*out = make([]PodLatency, len(*in))
+		copy(*out, *in)
+	}
+	if in.LastPodReplacementTime != nil {
+		in, out := &in.LastPodReplacementTime, &out.LastPodReplacementTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WebserverStatus.
@@ -17,6 +17,8 @@ limitations under the License.
 package v1alpha1
 
 import (
+	autoscalingv2beta2 "k8s.io/api/autoscaling/v2beta2"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -26,14 +28,597 @@ import (
 // WebserverSpec defines the desired state of Webserver
 type WebserverSpec struct {
 	// +kubebuilder:validation:Minimum=0
-	// Size is the size of the webserver deployment
+	// Size is the size of the webserver deployment. It is enforced when autoscaling is disabled,
+	// and is the initial size when autoscaling is enabled. It is also the minimum size, unless
+	// autoscaling.minReplicas is set
 	Size int32 `json:"size"`
+
+	// +optional
+	// Autoscaling configures how the webserver deployment is scaled after it is created
+	Autoscaling WebserverAutoscalingSpec `json:"autoscaling,omitempty"`
+
+	// +optional
+	// Schedules are recurring time windows that bound the size of the webserver deployment.
+	// When autoscaling, the stricter of the schedule and autoscaling bounds applies
+	Schedules []ScalingSchedule `json:"schedules,omitempty"`
+
+	// +optional
+	// Probe configures how the operator measures the latency of the webserver
+	Probe ProbeSpec `json:"probe,omitempty"`
+
+	// +optional
+	// Metric selects where the latency the webserver is autoscaled on comes from. Defaults to the
+	// operator's own probe. The probe's intervalSeconds and windowSize apply to every source
+	Metric MetricSourceSpec `json:"metric,omitempty"`
+
+	// +optional
+	// Service configures the Service the operator creates in front of the webserver pods
+	Service WebserverServiceSpec `json:"service,omitempty"`
+
+	// +optional
+	// Ingress exposes the Service of the webserver outside of the cluster. No Ingress is created when unset
+	Ingress *WebserverIngressSpec `json:"ingress,omitempty"`
+
+	// +optional
+	// Security overrides the restricted security context the webserver pods run with
+	Security *PodSecuritySpec `json:"security,omitempty"`
+
+	// +optional
+	// HealthChecks tunes the probes of the webserver container on its ping port. The liveness probe sends an HTTP GET of /
+	HealthChecks HealthChecksSpec `json:"healthChecks,omitempty"`
+
+	// +optional
+	// CacheRef links the webserver to a Memcached in its namespace, whose connection settings are passed to the
+	// webserver container as environment variables
+	CacheRef *WebserverCacheRef `json:"cacheRef,omitempty"`
+}
+
+// WebserverCacheRef is the Memcached a Webserver uses. MEMCACHED_SERVERS holds the server list of the Memcached,
+// one address per line in ketama order, as the pod started. When SASL is enabled, MEMCACHED_USERNAME and
+// MEMCACHED_PASSWORD hold the credentials of the client Secret <name>-client
+type WebserverCacheRef struct {
+	// Name is the name of the Memcached
+	Name string `json:"name"`
+
+	// +optional
+	// RollOnServerChange rolls the webserver pods when the memcached pods of the Memcached change, once its
+	// Deployment has settled. A memcached pod that is not ready for a moment does not roll them. Otherwise the
+	// running pods keep the servers they started with, and are expected to watch the ConfigMap <name>-servers
+	RollOnServerChange bool `json:"rollOnServerChange,omitempty"`
+}
+
+// CacheReadyCondition is True when the Memcached of spec.cacheRef has ready pods, and False when it is missing or
+// has none
+const CacheReadyCondition = "CacheReady"
+
+// WebserverAutoscalingSpec configures the autoscaling of a Webserver
+type WebserverAutoscalingSpec struct {
+	// +optional
+	// Mode is either Latency (default), which scales the webserver on the latency measured by the prober,
+	// Recommend, which only reports what Latency mode would do in the status and as events, HorizontalPodAutoscaler,
+	// which leaves the scaling to a HorizontalPodAutoscaler the operator creates, or Disabled, which keeps the
+	// webserver at spec.size
+	Mode AutoscalingMode `json:"mode,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	// MinReplicas is the minimum size of the webserver deployment in Latency mode. Defaults to spec.size.
+	// When 0 and scaleToZero.activity is set, the webserver is scaled to zero after scaleToZero.idleSeconds
+	// without traffic, and the activator of the operator scales it up again on the next connection
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+
+	// +optional
+	// ScaleToZero configures when a webserver with minReplicas 0 is scaled to zero, and how it wakes up
+	ScaleToZero ScaleToZeroSpec `json:"scaleToZero,omitempty"`
+
+	// +optional
+	// HorizontalPodAutoscaler configures the HorizontalPodAutoscaler. Required in HorizontalPodAutoscaler mode
+	HorizontalPodAutoscaler *HorizontalPodAutoscalerSpec `json:"horizontalPodAutoscaler,omitempty"`
+
+	// +optional
+	// Predictive scales the webserver up ahead of expected latency spikes, forecast from its recorded
+	// latency history. Only used in Latency mode
+	Predictive *PredictiveAutoscalingSpec `json:"predictive,omitempty"`
+}
+
+// PredictiveAutoscalingSpec configures the forecaster of a Webserver
+type PredictiveAutoscalingSpec struct {
+	// +optional
+	// DryRun only reports the recommended replicas in the status, without scaling the webserver
+	DryRun bool `json:"dryRun,omitempty"`
+
+	// +kubebuilder:validation:Minimum=5
+	// +optional
+	// HorizonMinutes is how far ahead the latency is forecast. Defaults to 15 minutes
+	HorizonMinutes int32 `json:"horizonMinutes,omitempty"`
+
+	// +kubebuilder:validation:Minimum=60
+	// +optional
+	// SeasonMinutes is the period of the recurring traffic pattern. Defaults to 1440 minutes, one day
+	SeasonMinutes int32 `json:"seasonMinutes,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=14
+	// +optional
+	// HistoryDays is how long the latency history is kept. Defaults to 7 days
+	HistoryDays int32 `json:"historyDays,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	// MaxReplicas is the most replicas the forecast may scale the webserver up to. Without it, the forecast
+	// recommends at most twice the current replicas, so a single latency spike in the history can't blow the
+	// webserver up a season later
+	MaxReplicas int32 `json:"maxReplicas,omitempty"`
+}
+
+// ScaleToZeroSpec configures the scale to zero of a Webserver
+type ScaleToZeroSpec struct {
+	// +kubebuilder:validation:Minimum=30
+	// +optional
+	// IdleSeconds is how long the webserver must go without traffic before it is scaled to zero. Defaults to 900 seconds
+	IdleSeconds int32 `json:"idleSeconds,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	// WakeTimeoutSeconds is how long the activator holds a connection while the webserver scales up,
+	// before giving up on it. Defaults to 120 seconds
+	WakeTimeoutSeconds int32 `json:"wakeTimeoutSeconds,omitempty"`
+
+	// +optional
+	// Activity tells when the webserver has traffic while it has pods. The traffic only goes through the activator
+	// while the webserver has no ready pod, so the webserver is never scaled to zero when unset
+	Activity *ActivitySource `json:"activity,omitempty"`
+}
+
+// ActivitySource is a PromQL query returning the request rate of a Webserver. The webserver has traffic while the
+// query returns more than 0, or fails
+type ActivitySource struct {
+	// Address is the base URL of the Prometheus HTTP API, e.g. http://prometheus.monitoring.svc:9090
+	Address string `json:"address"`
+
+	// Query is a PromQL expression evaluating to a scalar or an instant vector, e.g.
+	// sum(rate(http_requests_total{job="web"}[1m])). The highest value is used when the vector has several series
+	Query string `json:"query"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	// TimeoutSeconds is how long to wait for the query. Defaults to 3 seconds
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+}
+
+// AutoscalingMode selects how a Webserver is scaled
+// +kubebuilder:validation:Enum=Latency;Recommend;HorizontalPodAutoscaler;Disabled
+type AutoscalingMode string
+
+const (
+	// LatencyAutoscalingMode scales the webserver up and down on the latency measured by the prober
+	LatencyAutoscalingMode AutoscalingMode = "Latency"
+	// RecommendAutoscalingMode computes what Latency mode would do, without changing the webserver deployment
+	RecommendAutoscalingMode AutoscalingMode = "Recommend"
+	// HorizontalPodAutoscalerAutoscalingMode leaves the scaling to a HorizontalPodAutoscaler, and ignores the schedules
+	HorizontalPodAutoscalerAutoscalingMode AutoscalingMode = "HorizontalPodAutoscaler"
+	// DisabledAutoscalingMode keeps the webserver at spec.size
+	DisabledAutoscalingMode AutoscalingMode = "Disabled"
+)
+
+// WebserverServiceSpec configures the Service of a Webserver
+type WebserverServiceSpec struct {
+	// +kubebuilder:validation:Enum=ClusterIP;NodePort;LoadBalancer
+	// +optional
+	// Type is the type of the Service. Defaults to ClusterIP
+	Type corev1.ServiceType `json:"type,omitempty"`
+}
+
+// WebserverIngressSpec configures the Ingress of a Webserver
+type WebserverIngressSpec struct {
+	// +optional
+	// Host is the host name routed to the webserver. Every host is routed to the webserver when empty
+	Host string `json:"host,omitempty"`
+
+	// +optional
+	// Path is the path prefix routed to the webserver. Defaults to /
+	Path string `json:"path,omitempty"`
+
+	// +optional
+	// IngressClassName selects the ingress controller that implements the Ingress
+	IngressClassName *string `json:"ingressClassName,omitempty"`
+
+	// +optional
+	// TLSSecretName is the name of a Secret holding the certificate of Host. TLS is enabled when it is set
+	TLSSecretName string `json:"tlsSecretName,omitempty"`
+
+	// +optional
+	// Annotations are added to the Ingress, e.g. to configure the ingress controller
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ProbeSpec configures the background latency prober for a Webserver
+type ProbeSpec struct {
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	// IntervalSeconds is how often the webserver is probed. Defaults to 5 seconds
+	IntervalSeconds int32 `json:"intervalSeconds,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	// WindowSize is the number of most recent samples the aggregated latency is computed from. Defaults to 12
+	WindowSize int32 `json:"windowSize,omitempty"`
+
+	// +optional
+	// Mode is either Service (default), which probes the webserver through its Service, or Pods,
+	// which probes the IP of every webserver pod individually
+	Mode ProbeMode `json:"mode,omitempty"`
+
+	// +optional
+	// PodReplacement deletes a pod whose latency is an outlier compared to its peers. Only used in Pods mode
+	PodReplacement *PodReplacementSpec `json:"podReplacement,omitempty"`
+
+	// +optional
+	// HTTP probes the webserver with an HTTP(S) request. This is the default, with a plain GET of /,
+	// when none of http, tcp and grpc is set
+	HTTP *HTTPProbe `json:"http,omitempty"`
+
+	// +optional
+	// TCP measures the time it takes to open a TCP connection to the webserver
+	TCP *TCPProbe `json:"tcp,omitempty"`
+
+	// +optional
+	// GRPC probes the webserver with the gRPC health checking protocol
+	GRPC *GRPCProbe `json:"grpc,omitempty"`
+}
+
+// HTTPProbe configures an HTTP(S) latency probe
+type HTTPProbe struct {
+	// +kubebuilder:validation:Enum=HTTP;HTTPS
+	// +optional
+	// Scheme is either HTTP (default) or HTTPS
+	Scheme string `json:"scheme,omitempty"`
+
+	// +optional
+	// Port overrides the port that is probed
+	Port int32 `json:"port,omitempty"`
+
+	// +optional
+	// Path is the path of the request. Defaults to /
+	Path string `json:"path,omitempty"`
+
+	// +optional
+	// Method is the method of the request. Defaults to GET
+	Method string `json:"method,omitempty"`
+
+	// +optional
+	// Headers are added to the request
+	Headers []HTTPHeader `json:"headers,omitempty"`
+
+	// +optional
+	// Body is the body of the request
+	Body string `json:"body,omitempty"`
+
+	// +optional
+	// ExpectedStatusCodes are the status codes of a successful probe. Defaults to any 2xx or 3xx status code
+	ExpectedStatusCodes []int32 `json:"expectedStatusCodes,omitempty"`
+
+	// +optional
+	// TLS configures how the certificate of the webserver is verified when the scheme is HTTPS
+	TLS *ProbeTLS `json:"tls,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	// TimeoutSeconds is how long to wait for the response. Defaults to 3 seconds
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+}
+
+// HTTPHeader is a header of an HTTP probe request
+type HTTPHeader struct {
+	// Name is the name of the header
+	Name string `json:"name"`
+
+	// Value is the value of the header
+	Value string `json:"value"`
+}
+
+// ProbeTLS configures how the certificate of a webserver is verified
+type ProbeTLS struct {
+	// +optional
+	// CASecretRef selects the key of a Secret in the namespace of the Webserver holding the PEM encoded
+	// CA certificates to verify the webserver with, instead of the system roots
+	CASecretRef *corev1.SecretKeySelector `json:"caSecretRef,omitempty"`
+
+	// +optional
+	// ServerName overrides the host name the certificate is verified against
+	ServerName string `json:"serverName,omitempty"`
+
+	// +optional
+	// InsecureSkipVerify disables the verification of the certificate
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+}
+
+// TCPProbe configures a latency probe that only opens a TCP connection
+type TCPProbe struct {
+	// +optional
+	// Port overrides the port that is probed
+	Port int32 `json:"port,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	// TimeoutSeconds is how long to wait for the connection. Defaults to 3 seconds
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+}
+
+// GRPCProbe configures a latency probe using the gRPC health checking protocol
+type GRPCProbe struct {
+	// +optional
+	// Port overrides the port that is probed
+	Port int32 `json:"port,omitempty"`
+
+	// +optional
+	// Service is the name of the service to check the health of. Defaults to the health of the whole server
+	Service string `json:"service,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	// TimeoutSeconds is how long to wait for the health check. Defaults to 3 seconds
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+}
+
+// MetricSourceSpec selects where the latency of a Webserver comes from
+type MetricSourceSpec struct {
+	// +optional
+	// Type is either Probe (default), which measures the latency with the operator's own probe,
+	// or Prometheus, which reads it from the result of a PromQL query
+	Type MetricSourceType `json:"type,omitempty"`
+
+	// +optional
+	// Prometheus configures the query. Required when type is Prometheus
+	Prometheus *PrometheusMetricSource `json:"prometheus,omitempty"`
+}
+
+// MetricSourceType is the kind of source the latency of a Webserver comes from
+// +kubebuilder:validation:Enum=Probe;Prometheus
+type MetricSourceType string
+
+const (
+	// ProbeMetricSourceType measures the latency with the operator's own probe
+	ProbeMetricSourceType MetricSourceType = "Probe"
+	// PrometheusMetricSourceType reads the latency from a PromQL query
+	PrometheusMetricSourceType MetricSourceType = "Prometheus"
+)
+
+// PrometheusMetricSource configures a PromQL query that returns the latency of a Webserver
+type PrometheusMetricSource struct {
+	// Address is the base URL of the Prometheus HTTP API, e.g. http://prometheus.monitoring.svc:9090
+	Address string `json:"address"`
+
+	// Query is a PromQL expression evaluating to a scalar or an instant vector, e.g.
+	// histogram_quantile(0.95, sum(rate(http_request_duration_seconds_bucket{job="web"}[1m])) by (le)).
+	// The highest value is used when the vector has several series
+	Query string `json:"query"`
+
+	// +kubebuilder:validation:Enum=Seconds;Milliseconds
+	// +optional
+	// Unit is the unit of the query result. Defaults to Seconds, the Prometheus convention
+	Unit string `json:"unit,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	// TimeoutSeconds is how long to wait for the query. Defaults to 3 seconds
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+}
+
+// ProbeMode selects what the prober measures the latency of
+// +kubebuilder:validation:Enum=Service;Pods
+type ProbeMode string
+
+const (
+	// ServiceProbeMode probes the webserver through its Service
+	ServiceProbeMode ProbeMode = "Service"
+	// PodsProbeMode probes every webserver pod individually
+	PodsProbeMode ProbeMode = "Pods"
+)
+
+// PodReplacementSpec configures when a webserver pod with outlier latency is deleted
+type PodReplacementSpec struct {
+	// +kubebuilder:validation:Minimum=101
+	// +optional
+	// OutlierPercent is how large the latency of a pod must be, in percent of the median latency of its peers,
+	// for the pod to be an outlier. Defaults to 300
+	OutlierPercent int32 `json:"outlierPercent,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	// ConsecutiveIntervals is the number of probe intervals in a row a pod must be an outlier before it is deleted. Defaults to 3
+	ConsecutiveIntervals int32 `json:"consecutiveIntervals,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	// MinDeletionIntervalSeconds is the minimum time between two pod deletions. Defaults to 300 seconds
+	MinDeletionIntervalSeconds *int32 `json:"minDeletionIntervalSeconds,omitempty"`
 }
 
 // WebserverStatus defines the observed state of Webserver
 type WebserverStatus struct {
 	// type: json.Number just dont seem to work, just use string for now
 	Latency string `json:"latency"`
+
+	// +optional
+	// DesiredReplicas is the number of replicas the operator wants the webserver deployment to have
+	DesiredReplicas int32 `json:"desiredReplicas,omitempty"`
+
+	// +optional
+	// CurrentReplicas is the number of replicas the webserver deployment currently has
+	CurrentReplicas int32 `json:"currentReplicas,omitempty"`
+
+	// +optional
+	// ScalingReason explains the number of desired replicas, and why it differs from the current replicas
+	ScalingReason string `json:"scalingReason,omitempty"`
+
+	// +optional
+	// ActiveSchedules are the names of the schedules whose window is currently active
+	ActiveSchedules []string `json:"activeSchedules,omitempty"`
+
+	// +optional
+	// HorizontalPodAutoscaler is the status of the HorizontalPodAutoscaler, in HorizontalPodAutoscaler mode
+	HorizontalPodAutoscaler *autoscalingv2beta2.HorizontalPodAutoscalerStatus `json:"horizontalPodAutoscaler,omitempty"`
+
+	// +optional
+	// ScalingHistory are the most recent scaling decisions, newest first
+	ScalingHistory []ScalingDecision `json:"scalingHistory,omitempty"`
+
+	// +optional
+	// Recommendation is the latest scaling decision of the autoscaler in Recommend mode
+	Recommendation *ScalingRecommendation `json:"recommendation,omitempty"`
+
+	// +optional
+	// ScaleToZero is the state of the activator of a webserver with minReplicas 0
+	ScaleToZero *ScaleToZeroStatus `json:"scaleToZero,omitempty"`
+
+	// +optional
+	// Forecast is the latest forecast of the predictive autoscaler
+	Forecast *LatencyForecast `json:"forecast,omitempty"`
+
+	// +optional
+	// LatencyBreakdown is the phase breakdown of the most recent probe, to tell network latency from application latency
+	LatencyBreakdown *LatencyBreakdown `json:"latencyBreakdown,omitempty"`
+
+	// +optional
+	// PodLatencies is the latest latency of every webserver pod, when probing in Pods mode
+	PodLatencies []PodLatency `json:"podLatencies,omitempty"`
+
+	// +optional
+	// LastPodReplacementTime is when the operator last deleted a pod because of its latency
+	LastPodReplacementTime *metav1.Time `json:"lastPodReplacementTime,omitempty"`
+
+	// +optional
+	// Conditions are the latest observations of the state of the Webserver
+	Conditions []Condition `json:"conditions,omitempty"`
+}
+
+// ScaleToZeroStatus is the state of the activator of a Webserver
+type ScaleToZeroStatus struct {
+	// +optional
+	// Active is set when the activator is ready to hold the traffic of the webserver. Scale to zero is only possible then
+	Active bool `json:"active,omitempty"`
+
+	// +optional
+	// ActivatorAddress is the address of the activator. The Service of the webserver only routes to it while the
+	// webserver has no ready pod. The activator keeps listening on the same port when the operator restarts
+	ActivatorAddress string `json:"activatorAddress,omitempty"`
+
+	// +optional
+	// LastActivityTime is when the webserver last had traffic, as seen by the activator or the activity query
+	LastActivityTime *metav1.Time `json:"lastActivityTime,omitempty"`
+}
+
+// ScalingDecision records why the number of replicas of a webserver was changed
+type ScalingDecision struct {
+	// Time is when the decision was made
+	Time metav1.Time `json:"time"`
+
+	// FromReplicas is the number of replicas before the decision
+	FromReplicas int32 `json:"fromReplicas"`
+
+	// ToReplicas is the number of replicas after the decision
+	ToReplicas int32 `json:"toReplicas"`
+
+	// Policy is what decided the number of replicas: Size, Latency, Forecast, Schedule, Idle or Activation
+	Policy string `json:"policy"`
+
+	// Reason explains the decision
+	Reason string `json:"reason"`
+
+	// +optional
+	// LatencyMs is the aggregated latency at the time of the decision. Unset when there were no samples yet
+	LatencyMs *int64 `json:"latencyMs,omitempty"`
+
+	// ScaleUpThresholdMs is the latency at or above which the webserver is scaled up
+	ScaleUpThresholdMs int64 `json:"scaleUpThresholdMs"`
+
+	// ScaleDownThresholdMs is the latency at or below which the webserver is scaled down
+	ScaleDownThresholdMs int64 `json:"scaleDownThresholdMs"`
+
+	// +optional
+	// ForecastLatencyMs is the latency forecast by the predictive autoscaler, if any
+	ForecastLatencyMs *int64 `json:"forecastLatencyMs,omitempty"`
+
+	// +optional
+	// ActiveSchedules are the names of the schedules whose window was active
+	ActiveSchedules []string `json:"activeSchedules,omitempty"`
+
+	// +optional
+	// DryRun is set for the recommendations of the Recommend autoscaling mode, which did not scale the webserver
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+// ScalingRecommendation is what the autoscaler would have done, had it been allowed to scale the webserver
+type ScalingRecommendation struct {
+	// Replicas is the number of replicas the autoscaler would scale the webserver deployment to
+	Replicas int32 `json:"replicas"`
+
+	// Reason explains the recommended number of replicas
+	Reason string `json:"reason"`
+
+	// +optional
+	// LatencyMs is the aggregated latency the recommendation is based on. Unset when there were no samples yet
+	LatencyMs *int64 `json:"latencyMs,omitempty"`
+
+	// Time is when the recommendation was made
+	Time metav1.Time `json:"time"`
+}
+
+// LatencyBreakdown is the duration of each phase of a probe in milliseconds.
+// Phases that did not happen during the probe, e.g. DNS when probing an IP, are zero.
+type LatencyBreakdown struct {
+	// DNSMs is the time spent resolving the host name
+	DNSMs int64 `json:"dnsMs"`
+
+	// ConnectMs is the time spent opening the TCP connection
+	ConnectMs int64 `json:"connectMs"`
+
+	// TLSHandshakeMs is the time spent on the TLS handshake
+	TLSHandshakeMs int64 `json:"tlsHandshakeMs"`
+
+	// ServerProcessingMs is the time from the request being sent until the first response byte
+	ServerProcessingMs int64 `json:"serverProcessingMs"`
+
+	// FirstByteMs is the time from the start of the probe until the first response byte
+	FirstByteMs int64 `json:"firstByteMs"`
+
+	// TotalMs is the duration of the whole probe
+	TotalMs int64 `json:"totalMs"`
+
+	// ProbeTime is when the probe finished
+	ProbeTime metav1.Time `json:"probeTime"`
+}
+
+// LatencyForecast is a forecast of the predictive autoscaler
+type LatencyForecast struct {
+	// PredictedLatencyMs is the latency forecast at the horizon, with the current number of replicas
+	PredictedLatencyMs int64 `json:"predictedLatencyMs"`
+
+	// RecommendedReplicas is the number of replicas that keeps the forecast latency between the scaling thresholds
+	RecommendedReplicas int32 `json:"recommendedReplicas"`
+
+	// Model is the model the forecast comes from, either Seasonal or Linear
+	Model string `json:"model"`
+
+	// HistoryPoints is the number of recorded points in the latency history
+	HistoryPoints int32 `json:"historyPoints"`
+
+	// ForecastTime is the time the forecast is for
+	ForecastTime metav1.Time `json:"forecastTime"`
+}
+
+// PodLatency is the latency measured for a single webserver pod
+type PodLatency struct {
+	// Name is the name of the pod
+	Name string `json:"name"`
+
+	// LatencyMs is the aggregated latency of the pod in milliseconds
+	LatencyMs int64 `json:"latencyMs"`
+
+	// +optional
+	// OutlierIntervals is the number of probe intervals in a row the pod has been a latency outlier
+	OutlierIntervals int32 `json:"outlierIntervals,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -41,6 +626,14 @@ type WebserverStatus struct {
 
 // Webserver is the Schema for the webservers API
 // +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Size",type=integer,JSONPath=".spec.size"
+// +kubebuilder:printcolumn:name="Desired",type=integer,JSONPath=".status.desiredReplicas"
+// +kubebuilder:printcolumn:name="Current",type=integer,JSONPath=".status.currentReplicas"
+// +kubebuilder:printcolumn:name="Latency",type=string,JSONPath=".status.latency"
+// +kubebuilder:printcolumn:name="Last Policy",type=string,JSONPath=".status.scalingHistory[0].policy"
+// +kubebuilder:printcolumn:name="Last Scaled",type=date,JSONPath=".status.scalingHistory[0].time"
+// +kubebuilder:printcolumn:name="Last Reason",type=string,priority=1,JSONPath=".status.scalingHistory[0].reason"
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=".metadata.creationTimestamp"
 type Webserver struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
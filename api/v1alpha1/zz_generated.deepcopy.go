@@ -21,15 +21,272 @@ limitations under the License.
 package v1alpha1
 
 import (
-	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/api/autoscaling/v2beta2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CertificateIssuerReference) DeepCopyInto(out *CertificateIssuerReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CertificateIssuerReference.
+func (in *CertificateIssuerReference) DeepCopy() *CertificateIssuerReference {
+	if in == nil {
+		return nil
+	}
+	out := new(CertificateIssuerReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Condition) DeepCopyInto(out *Condition) {
+	*out = *in
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Condition.
+func (in *Condition) DeepCopy() *Condition {
+	if in == nil {
+		return nil
+	}
+	out := new(Condition)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HealthCheckSpec) DeepCopyInto(out *HealthCheckSpec) {
+	*out = *in
+	if in.InitialDelaySeconds != nil {
+		in, out := &in.InitialDelaySeconds, &out.InitialDelaySeconds
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HealthCheckSpec.
+func (in *HealthCheckSpec) DeepCopy() *HealthCheckSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(HealthCheckSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HealthChecksSpec) DeepCopyInto(out *HealthChecksSpec) {
+	*out = *in
+	if in.Readiness != nil {
+		in, out := &in.Readiness, &out.Readiness
+		*out = new(HealthCheckSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Liveness != nil {
+		in, out := &in.Liveness, &out.Liveness
+		*out = new(HealthCheckSpec)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HealthChecksSpec.
+func (in *HealthChecksSpec) DeepCopy() *HealthChecksSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(HealthChecksSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HorizontalPodAutoscalerSpec) DeepCopyInto(out *HorizontalPodAutoscalerSpec) {
+	*out = *in
+	if in.MinReplicas != nil {
+		in, out := &in.MinReplicas, &out.MinReplicas
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Metrics != nil {
+		in, out := &in.Metrics, &out.Metrics
+		*out = make([]v2beta2.MetricSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Behavior != nil {
+		in, out := &in.Behavior, &out.Behavior
+		*out = new(v2beta2.HorizontalPodAutoscalerBehavior)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HorizontalPodAutoscalerSpec.
+func (in *HorizontalPodAutoscalerSpec) DeepCopy() *HorizontalPodAutoscalerSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(HorizontalPodAutoscalerSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *McRouter) DeepCopyInto(out *McRouter) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new McRouter.
+func (in *McRouter) DeepCopy() *McRouter {
+	if in == nil {
+		return nil
+	}
+	out := new(McRouter)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *McRouter) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *McRouterList) DeepCopyInto(out *McRouterList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]McRouter, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new McRouterList.
+func (in *McRouterList) DeepCopy() *McRouterList {
+	if in == nil {
+		return nil
+	}
+	out := new(McRouterList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *McRouterList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *McRouterPool) DeepCopyInto(out *McRouterPool) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new McRouterPool.
+func (in *McRouterPool) DeepCopy() *McRouterPool {
+	if in == nil {
+		return nil
+	}
+	out := new(McRouterPool)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *McRouterPoolStatus) DeepCopyInto(out *McRouterPoolStatus) {
+	*out = *in
+	if in.Servers != nil {
+		in, out := &in.Servers, &out.Servers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new McRouterPoolStatus.
+func (in *McRouterPoolStatus) DeepCopy() *McRouterPoolStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(McRouterPoolStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *McRouterSpec) DeepCopyInto(out *McRouterSpec) {
+	*out = *in
+	if in.Pools != nil {
+		in, out := &in.Pools, &out.Pools
+		*out = make([]McRouterPool, len(*in))
+		copy(*out, *in)
+	}
+	if in.Security != nil {
+		in, out := &in.Security, &out.Security
+		*out = new(PodSecuritySpec)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new McRouterSpec.
+func (in *McRouterSpec) DeepCopy() *McRouterSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(McRouterSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *McRouterStatus) DeepCopyInto(out *McRouterStatus) {
+	*out = *in
+	if in.Nodes != nil {
+		in, out := &in.Nodes, &out.Nodes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Pools != nil {
+		in, out := &in.Pools, &out.Pools
+		*out = make([]McRouterPoolStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new McRouterStatus.
+func (in *McRouterStatus) DeepCopy() *McRouterStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(McRouterStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Memcached) DeepCopyInto(out *Memcached) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 }
 
@@ -51,6 +308,105 @@ func (in *Memcached) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MemcachedAuthSpec) DeepCopyInto(out *MemcachedAuthSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MemcachedAuthSpec.
+func (in *MemcachedAuthSpec) DeepCopy() *MemcachedAuthSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MemcachedAuthSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MemcachedAutoscalingSpec) DeepCopyInto(out *MemcachedAutoscalingSpec) {
+	*out = *in
+	if in.MinReplicas != nil {
+		in, out := &in.MinReplicas, &out.MinReplicas
+		*out = new(int32)
+		**out = **in
+	}
+	if in.TargetEvictionsPerSecond != nil {
+		in, out := &in.TargetEvictionsPerSecond, &out.TargetEvictionsPerSecond
+		*out = new(int32)
+		**out = **in
+	}
+	if in.MinHitRatioPercent != nil {
+		in, out := &in.MinHitRatioPercent, &out.MinHitRatioPercent
+		*out = new(int32)
+		**out = **in
+	}
+	if in.ScaleDownDelaySeconds != nil {
+		in, out := &in.ScaleDownDelaySeconds, &out.ScaleDownDelaySeconds
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MemcachedAutoscalingSpec.
+func (in *MemcachedAutoscalingSpec) DeepCopy() *MemcachedAutoscalingSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MemcachedAutoscalingSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MemcachedAutoscalingStatus) DeepCopyInto(out *MemcachedAutoscalingStatus) {
+	*out = *in
+	if in.HitRatioPercent != nil {
+		in, out := &in.HitRatioPercent, &out.HitRatioPercent
+		*out = new(int32)
+		**out = **in
+	}
+	if in.LastScaleTime != nil {
+		in, out := &in.LastScaleTime, &out.LastScaleTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MemcachedAutoscalingStatus.
+func (in *MemcachedAutoscalingStatus) DeepCopy() *MemcachedAutoscalingStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(MemcachedAutoscalingStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MemcachedClientSelector) DeepCopyInto(out *MemcachedClientSelector) {
+	*out = *in
+	if in.PodSelector != nil {
+		in, out := &in.PodSelector, &out.PodSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.NamespaceSelector != nil {
+		in, out := &in.NamespaceSelector, &out.NamespaceSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MemcachedClientSelector.
+func (in *MemcachedClientSelector) DeepCopy() *MemcachedClientSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(MemcachedClientSelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MemcachedList) DeepCopyInto(out *MemcachedList) {
 	*out = *in
@@ -83,9 +439,109 @@ func (in *MemcachedList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MemcachedServerListSpec) DeepCopyInto(out *MemcachedServerListSpec) {
+	*out = *in
+	if in.ConsumerNamespaces != nil {
+		in, out := &in.ConsumerNamespaces, &out.ConsumerNamespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MemcachedServerListSpec.
+func (in *MemcachedServerListSpec) DeepCopy() *MemcachedServerListSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MemcachedServerListSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MemcachedSizingSpec) DeepCopyInto(out *MemcachedSizingSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MemcachedSizingSpec.
+func (in *MemcachedSizingSpec) DeepCopy() *MemcachedSizingSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MemcachedSizingSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MemcachedSizingStatus) DeepCopyInto(out *MemcachedSizingStatus) {
+	*out = *in
+	if in.LastAppliedTime != nil {
+		in, out := &in.LastAppliedTime, &out.LastAppliedTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MemcachedSizingStatus.
+func (in *MemcachedSizingStatus) DeepCopy() *MemcachedSizingStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(MemcachedSizingStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MemcachedSpec) DeepCopyInto(out *MemcachedSpec) {
 	*out = *in
+	if in.Autoscaling != nil {
+		in, out := &in.Autoscaling, &out.Autoscaling
+		*out = new(MemcachedAutoscalingSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Schedules != nil {
+		in, out := &in.Schedules, &out.Schedules
+		*out = make([]ScalingSchedule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.HorizontalPodAutoscaler != nil {
+		in, out := &in.HorizontalPodAutoscaler, &out.HorizontalPodAutoscaler
+		*out = new(HorizontalPodAutoscalerSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Sizing != nil {
+		in, out := &in.Sizing, &out.Sizing
+		*out = new(MemcachedSizingSpec)
+		**out = **in
+	}
+	if in.Auth != nil {
+		in, out := &in.Auth, &out.Auth
+		*out = new(MemcachedAuthSpec)
+		**out = **in
+	}
+	if in.TLS != nil {
+		in, out := &in.TLS, &out.TLS
+		*out = new(MemcachedTLSSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AllowedClients != nil {
+		in, out := &in.AllowedClients, &out.AllowedClients
+		*out = make([]MemcachedClientSelector, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Security != nil {
+		in, out := &in.Security, &out.Security
+		*out = new(PodSecuritySpec)
+		(*in).DeepCopyInto(*out)
+	}
+	in.HealthChecks.DeepCopyInto(&out.HealthChecks)
+	in.ServerList.DeepCopyInto(&out.ServerList)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MemcachedSpec.
@@ -106,6 +562,38 @@ func (in *MemcachedStatus) DeepCopyInto(out *MemcachedStatus) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.ActiveSchedules != nil {
+		in, out := &in.ActiveSchedules, &out.ActiveSchedules
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Autoscaling != nil {
+		in, out := &in.Autoscaling, &out.Autoscaling
+		*out = new(MemcachedAutoscalingStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.HorizontalPodAutoscaler != nil {
+		in, out := &in.HorizontalPodAutoscaler, &out.HorizontalPodAutoscaler
+		*out = new(v2beta2.HorizontalPodAutoscalerStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Sizing != nil {
+		in, out := &in.Sizing, &out.Sizing
+		*out = new(MemcachedSizingStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.TLS != nil {
+		in, out := &in.TLS, &out.TLS
+		*out = new(MemcachedTLSStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MemcachedStatus.
@@ -117,3 +605,117 @@ func (in *MemcachedStatus) DeepCopy() *MemcachedStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MemcachedTLSSpec) DeepCopyInto(out *MemcachedTLSSpec) {
+	*out = *in
+	if in.IssuerRef != nil {
+		in, out := &in.IssuerRef, &out.IssuerRef
+		*out = new(CertificateIssuerReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MemcachedTLSSpec.
+func (in *MemcachedTLSSpec) DeepCopy() *MemcachedTLSSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MemcachedTLSSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MemcachedTLSStatus) DeepCopyInto(out *MemcachedTLSStatus) {
+	*out = *in
+	if in.NotAfter != nil {
+		in, out := &in.NotAfter, &out.NotAfter
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MemcachedTLSStatus.
+func (in *MemcachedTLSStatus) DeepCopy() *MemcachedTLSStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(MemcachedTLSStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodSecuritySpec) DeepCopyInto(out *PodSecuritySpec) {
+	*out = *in
+	if in.RunAsUser != nil {
+		in, out := &in.RunAsUser, &out.RunAsUser
+		*out = new(int64)
+		**out = **in
+	}
+	if in.RunAsGroup != nil {
+		in, out := &in.RunAsGroup, &out.RunAsGroup
+		*out = new(int64)
+		**out = **in
+	}
+	if in.RunAsNonRoot != nil {
+		in, out := &in.RunAsNonRoot, &out.RunAsNonRoot
+		*out = new(bool)
+		**out = **in
+	}
+	if in.ReadOnlyRootFilesystem != nil {
+		in, out := &in.ReadOnlyRootFilesystem, &out.ReadOnlyRootFilesystem
+		*out = new(bool)
+		**out = **in
+	}
+	if in.AllowPrivilegeEscalation != nil {
+		in, out := &in.AllowPrivilegeEscalation, &out.AllowPrivilegeEscalation
+		*out = new(bool)
+		**out = **in
+	}
+	if in.AddCapabilities != nil {
+		in, out := &in.AddCapabilities, &out.AddCapabilities
+		*out = make([]corev1.Capability, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodSecuritySpec.
+func (in *PodSecuritySpec) DeepCopy() *PodSecuritySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PodSecuritySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScalingSchedule) DeepCopyInto(out *ScalingSchedule) {
+	*out = *in
+	if in.MinReplicas != nil {
+		in, out := &in.MinReplicas, &out.MinReplicas
+		*out = new(int32)
+		**out = **in
+	}
+	if in.MaxReplicas != nil {
+		in, out := &in.MaxReplicas, &out.MaxReplicas
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Size != nil {
+		in, out := &in.Size, &out.Size
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScalingSchedule.
+func (in *ScalingSchedule) DeepCopy() *ScalingSchedule {
+	if in == nil {
+		return nil
+	}
+	out := new(ScalingSchedule)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,57 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cachev1alpha1 "github.com/example-inc/memcached-operator/api/v1alpha1"
+)
+
+// setCondition adds or updates the condition of the given type. The transition time only changes with the status.
+func setCondition(conditions *[]cachev1alpha1.Condition, conditionType string, status corev1.ConditionStatus, reason, message string, now time.Time) {
+	for i := range *conditions {
+		c := &(*conditions)[i]
+		if c.Type != conditionType {
+			continue
+		}
+		if c.Status != status {
+			c.LastTransitionTime = metav1.NewTime(now)
+		}
+		c.Status, c.Reason, c.Message = status, reason, message
+		return
+	}
+	*conditions = append(*conditions, cachev1alpha1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		LastTransitionTime: metav1.NewTime(now),
+	})
+}
+
+// removeCondition removes the condition of the given type
+func removeCondition(conditions *[]cachev1alpha1.Condition, conditionType string) {
+	var kept []cachev1alpha1.Condition
+	for _, c := range *conditions {
+		if c.Type != conditionType {
+			kept = append(kept, c)
+		}
+	}
+	*conditions = kept
+}
@@ -0,0 +1,92 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	cachev1alpha1 "github.com/example-inc/memcached-operator/api/v1alpha1"
+)
+
+// Defaults of the health checks, see HealthCheckSpec. Every field of the probes is set, as the API server defaults
+// the unset ones, and the found pod template would never match the desired one otherwise
+const (
+	defaultReadinessInitialDelaySeconds = int32(0)
+	defaultReadinessTimeoutSeconds      = int32(1)
+	defaultLivenessInitialDelaySeconds  = int32(10)
+	defaultLivenessTimeoutSeconds       = int32(5)
+	defaultHealthCheckPeriodSeconds     = int32(10)
+	defaultHealthCheckFailureThreshold  = int32(3)
+	defaultHealthCheckSuccessThreshold  = int32(1)
+)
+
+// applyHealthChecks sets the readiness probe, a TCP connection to the port, and the given liveness check on the
+// first container of a pod template, as tuned by the CR
+func applyHealthChecks(template *corev1.PodTemplateSpec, spec cachev1alpha1.HealthChecksSpec, port string, liveness corev1.Handler) {
+	container := &template.Spec.Containers[0]
+	readiness := corev1.Handler{TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromString(port)}}
+	container.ReadinessProbe = healthCheckProbe(readiness, spec.Readiness, defaultReadinessInitialDelaySeconds, defaultReadinessTimeoutSeconds, false)
+	container.LivenessProbe = healthCheckProbe(liveness, spec.Liveness, defaultLivenessInitialDelaySeconds, defaultLivenessTimeoutSeconds, true)
+}
+
+// healthCheckProbe returns a probe running the handler with the thresholds of the spec, or nil when it is disabled
+func healthCheckProbe(handler corev1.Handler, spec *cachev1alpha1.HealthCheckSpec, initialDelaySeconds, timeoutSeconds int32, liveness bool) *corev1.Probe {
+	if spec == nil {
+		spec = &cachev1alpha1.HealthCheckSpec{}
+	}
+	if spec.Disabled {
+		return nil
+	}
+	probe := &corev1.Probe{
+		Handler:             handler,
+		InitialDelaySeconds: initialDelaySeconds,
+		TimeoutSeconds:      timeoutSeconds,
+		PeriodSeconds:       defaultHealthCheckPeriodSeconds,
+		SuccessThreshold:    defaultHealthCheckSuccessThreshold,
+		FailureThreshold:    defaultHealthCheckFailureThreshold,
+	}
+	if spec.InitialDelaySeconds != nil {
+		probe.InitialDelaySeconds = *spec.InitialDelaySeconds
+	}
+	if spec.TimeoutSeconds > 0 {
+		probe.TimeoutSeconds = spec.TimeoutSeconds
+	}
+	if spec.PeriodSeconds > 0 {
+		probe.PeriodSeconds = spec.PeriodSeconds
+	}
+	if spec.FailureThreshold > 0 {
+		probe.FailureThreshold = spec.FailureThreshold
+	}
+	// The API server rejects a liveness probe with another success threshold
+	if spec.SuccessThreshold > 0 && !liveness {
+		probe.SuccessThreshold = spec.SuccessThreshold
+	}
+	return probe
+}
+
+// memcachedLivenessCheck sends the version command to memcached. With SASL, memcached only speaks the binary
+// protocol, and with TLS it only speaks TLS, so the check only opens a TCP connection then.
+func memcachedLivenessCheck(m *cachev1alpha1.Memcached, tls bool) corev1.Handler {
+	if m.Spec.Auth != nil || tls {
+		return corev1.Handler{TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromString("memcached")}}
+	}
+	port := strconv.FormatInt(int64(memcachedPort), 10)
+	return corev1.Handler{Exec: &corev1.ExecAction{Command: []string{
+		"sh", "-c", "printf 'version\\r\\n' | nc -w 2 127.0.0.1 " + port + " | grep -q '^VERSION '",
+	}}}
+}
@@ -0,0 +1,112 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2beta2 "k8s.io/api/autoscaling/v2beta2"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cachev1alpha1 "github.com/example-inc/memcached-operator/api/v1alpha1"
+)
+
+// reconcileHPA creates or updates the HorizontalPodAutoscaler of a deployment, or deletes it when spec is nil.
+// The HorizontalPodAutoscaler has the same name as the deployment, and is owned by owner. It returns the
+// status of the HorizontalPodAutoscaler, or nil if there is none.
+func reconcileHPA(ctx context.Context, c client.Client, scheme *runtime.Scheme, log logr.Logger, owner metav1.Object, dep *appsv1.Deployment, spec *cachev1alpha1.HorizontalPodAutoscalerSpec, size int32) (*autoscalingv2beta2.HorizontalPodAutoscalerStatus, error) {
+	found := &autoscalingv2beta2.HorizontalPodAutoscaler{}
+	err := c.Get(ctx, types.NamespacedName{Name: dep.Name, Namespace: dep.Namespace}, found)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to get HorizontalPodAutoscaler")
+		return nil, err
+	}
+	exists := err == nil
+
+	if spec == nil {
+		if exists && metav1.IsControlledBy(found, owner) {
+			log.Info("Deleting the HorizontalPodAutoscaler", "HorizontalPodAutoscaler.Namespace", found.Namespace, "HorizontalPodAutoscaler.Name", found.Name)
+			if err := c.Delete(ctx, found); err != nil && !errors.IsNotFound(err) {
+				log.Error(err, "Failed to delete HorizontalPodAutoscaler", "HorizontalPodAutoscaler.Namespace", found.Namespace, "HorizontalPodAutoscaler.Name", found.Name)
+				return nil, err
+			}
+		}
+		return nil, nil
+	}
+
+	hpa := hpaForDeployment(dep, spec, size)
+	// Set the owner as the owner and controller
+	ctrl.SetControllerReference(owner, hpa, scheme)
+	if !exists {
+		log.Info("Creating a new HorizontalPodAutoscaler", "HorizontalPodAutoscaler.Namespace", hpa.Namespace, "HorizontalPodAutoscaler.Name", hpa.Name)
+		if err := c.Create(ctx, hpa); err != nil {
+			log.Error(err, "Failed to create new HorizontalPodAutoscaler", "HorizontalPodAutoscaler.Namespace", hpa.Namespace, "HorizontalPodAutoscaler.Name", hpa.Name)
+			return nil, err
+		}
+		return &hpa.Status, nil
+	}
+
+	// The API server defaults parts of the behavior, so only compare what the operator sets
+	if !equality.Semantic.DeepDerivative(hpa.Spec, found.Spec) {
+		found.Spec = hpa.Spec
+		log.Info("Updating the HorizontalPodAutoscaler", "HorizontalPodAutoscaler.Namespace", found.Namespace, "HorizontalPodAutoscaler.Name", found.Name)
+		if err := c.Update(ctx, found); err != nil {
+			log.Error(err, "Failed to update HorizontalPodAutoscaler", "HorizontalPodAutoscaler.Namespace", found.Namespace, "HorizontalPodAutoscaler.Name", found.Name)
+			return nil, err
+		}
+	}
+	return &found.Status, nil
+}
+
+// hpaForDeployment returns a HorizontalPodAutoscaler object that scales the given deployment
+func hpaForDeployment(dep *appsv1.Deployment, spec *cachev1alpha1.HorizontalPodAutoscalerSpec, size int32) *autoscalingv2beta2.HorizontalPodAutoscaler {
+	minReplicas := size
+	if spec.MinReplicas != nil {
+		minReplicas = *spec.MinReplicas
+	}
+	if minReplicas < 1 {
+		minReplicas = 1
+	}
+	maxReplicas := spec.MaxReplicas
+	if maxReplicas < minReplicas {
+		maxReplicas = minReplicas
+	}
+	return &autoscalingv2beta2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      dep.Name,
+			Namespace: dep.Namespace,
+			Labels:    dep.Spec.Template.Labels,
+		},
+		Spec: autoscalingv2beta2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv2beta2.CrossVersionObjectReference{
+				APIVersion: "apps/v1",
+				Kind:       "Deployment",
+				Name:       dep.Name,
+			},
+			MinReplicas: &minReplicas,
+			MaxReplicas: maxReplicas,
+			Metrics:     spec.Metrics,
+			Behavior:    spec.Behavior,
+		},
+	}
+}
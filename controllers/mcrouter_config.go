@@ -0,0 +1,136 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cachev1alpha1 "github.com/example-inc/memcached-operator/api/v1alpha1"
+)
+
+// mcrouterConfigKey is the key of the route configuration in the ConfigMap of a McRouter
+const mcrouterConfigKey = "config.json"
+
+// mcrouterConfig is the JSON route configuration of mcrouter, see https://github.com/facebook/mcrouter/wiki/Config-Files
+type mcrouterConfig struct {
+	Pools map[string]mcrouterPool `json:"pools"`
+	Route interface{}             `json:"route"`
+}
+
+// mcrouterPool is a pool of memcached servers mcrouter spreads keys over with consistent hashing
+type mcrouterPool struct {
+	Servers []string `json:"servers"`
+}
+
+// mcrouterRoute is a route handle with children, e.g. a FailoverRoute
+type mcrouterRoute struct {
+	Type     string        `json:"type"`
+	Children []interface{} `json:"children,omitempty"`
+	// DefaultPolicy and OperationPolicies are only set on an OperationSelectorRoute
+	DefaultPolicy     interface{}            `json:"default_policy,omitempty"`
+	OperationPolicies map[string]interface{} `json:"operation_policies,omitempty"`
+}
+
+// poolServers returns the addresses of the ready pods of every pool of a McRouter, in the order of the spec.
+// Pools that are missing, have no ready pods, or that mcrouter can't connect to are left out, and explained
+// in the returned reason.
+func (r *McRouterReconciler) poolServers(ctx context.Context, log logr.Logger, mr *cachev1alpha1.McRouter) ([]cachev1alpha1.McRouterPoolStatus, string, error) {
+	var pools []cachev1alpha1.McRouterPoolStatus
+	var reasons []string
+	for _, pool := range mr.Spec.Pools {
+		m := &cachev1alpha1.Memcached{}
+		err := r.Get(ctx, types.NamespacedName{Name: pool.Name, Namespace: mr.Namespace}, m)
+		if err != nil && errors.IsNotFound(err) {
+			reasons = append(reasons, "Memcached "+pool.Name+" does not exist")
+			continue
+		} else if err != nil {
+			log.Error(err, "Failed to get Memcached", "Memcached.Name", pool.Name)
+			return nil, "", err
+		}
+		// mcrouter speaks the text protocol without TLS to its servers
+		if m.Spec.Auth != nil || m.Spec.TLS != nil {
+			reasons = append(reasons, "Memcached "+pool.Name+" requires SASL or TLS, which mcrouter is not configured for")
+			continue
+		}
+
+		servers, err := r.readyServers(ctx, m)
+		if err != nil {
+			log.Error(err, "Failed to list pods", "Memcached.Namespace", m.Namespace, "Memcached.Name", m.Name)
+			return nil, "", err
+		}
+		if len(servers) == 0 {
+			reasons = append(reasons, "Memcached "+pool.Name+" has no ready pods")
+			continue
+		}
+		pools = append(pools, cachev1alpha1.McRouterPoolStatus{Name: pool.Name, Servers: servers})
+	}
+	return pools, strings.Join(reasons, ", "), nil
+}
+
+// readyServers returns the addresses of the ready pods of a Memcached in the order of their server list, so a new
+// pod does not move the others in the pool and remap their keys
+func (r *McRouterReconciler) readyServers(ctx context.Context, m *cachev1alpha1.Memcached) ([]string, error) {
+	podList := &corev1.PodList{}
+	listOpts := []client.ListOption{
+		client.InNamespace(m.Namespace),
+		client.MatchingLabels(labelsForMemcached(m.Name)),
+	}
+	if err := r.List(ctx, podList, listOpts...); err != nil {
+		return nil, err
+	}
+	return ketamaOrder(readyMemcachedServers(podList.Items)), nil
+}
+
+// routeConfig renders the route configuration of mcrouter for the pools. Without any pool, every request misses.
+func routeConfig(mode cachev1alpha1.McRouterMode, pools []cachev1alpha1.McRouterPoolStatus) (string, error) {
+	config := mcrouterConfig{Pools: map[string]mcrouterPool{}, Route: "NullRoute"}
+	var children []interface{}
+	for _, pool := range pools {
+		config.Pools[pool.Name] = mcrouterPool{Servers: pool.Servers}
+		children = append(children, "PoolRoute|"+pool.Name)
+	}
+
+	switch {
+	case len(children) == 0:
+	case len(children) == 1:
+		config.Route = children[0]
+	case mode == cachev1alpha1.ReplicateMcRouterMode:
+		config.Route = mcrouterRoute{
+			Type:          "OperationSelectorRoute",
+			DefaultPolicy: mcrouterRoute{Type: "FailoverRoute", Children: children},
+			OperationPolicies: map[string]interface{}{
+				"set":    mcrouterRoute{Type: "AllSyncRoute", Children: children},
+				"delete": mcrouterRoute{Type: "AllSyncRoute", Children: children},
+			},
+		}
+	default:
+		config.Route = mcrouterRoute{Type: "FailoverRoute", Children: children}
+	}
+
+	// Maps are marshalled with sorted keys, so the configuration only changes with the pools
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,356 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	cachev1alpha1 "github.com/example-inc/memcached-operator/api/v1alpha1"
+)
+
+// The mcrouter container listens on this named port, and reads its route configuration from the mounted ConfigMap.
+// mcrouter reloads the configuration file when the kubelet updates the ConfigMap volume, without a restart
+const (
+	mcrouterPortName   = "mcrouter"
+	mcrouterPort       = int32(5000)
+	mcrouterConfigPath = "/etc/mcrouter"
+	mcrouterVolumeName = "config"
+)
+
+// McRouterReconciler reconciles a McRouter object
+type McRouterReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=cache.example.com,resources=mcrouters,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=cache.example.com,resources=mcrouters/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=cache.example.com,resources=memcacheds,verbs=get;list;watch
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+
+func (r *McRouterReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("mcrouter", req.NamespacedName)
+
+	// Fetch the CR instance
+	mcrouter := &cachev1alpha1.McRouter{}
+	err := r.Get(ctx, req.NamespacedName, mcrouter)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// Owned objects are automatically garbage collected
+			log.Info("McRouter resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get McRouter")
+		return ctrl.Result{}, err
+	}
+
+	// Render the route configuration from the ready pods of the pools, see mcrouter_config.go
+	pools, reason, err := r.poolServers(ctx, log, mcrouter)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	config, err := routeConfig(mcrouter.Spec.Mode, pools)
+	if err != nil {
+		log.Error(err, "Failed to render the mcrouter configuration")
+		return ctrl.Result{}, err
+	}
+	if err = r.reconcileConfigMap(ctx, log, mcrouter, config); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Check if the deployment already exists, if not create a new one
+	found := &appsv1.Deployment{}
+	err = r.Get(ctx, types.NamespacedName{Name: mcrouter.Name, Namespace: mcrouter.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		dep := r.deploymentForMcRouter(mcrouter)
+		log.Info("Creating a new Deployment", "Deployment.Namespace", dep.Namespace, "Deployment.Name", dep.Name)
+		if err = r.Create(ctx, dep); err != nil {
+			log.Error(err, "Failed to create new Deployment", "Deployment.Namespace", dep.Namespace, "Deployment.Name", dep.Name)
+			return ctrl.Result{}, err
+		}
+		// Deployment created successfully - return and requeue
+		return ctrl.Result{Requeue: true}, nil
+	} else if err != nil {
+		log.Error(err, "Failed to get Deployment")
+		return ctrl.Result{}, err
+	}
+
+	// Ensure the deployment size and pods are the same as the spec in the CR
+	size := mcrouter.Spec.Size
+	changed := *found.Spec.Replicas != size
+	found.Spec.Replicas = &size
+	if syncPodTemplate(&found.Spec.Template, &r.deploymentForMcRouter(mcrouter).Spec.Template, mcrouterPodAnnotations) {
+		changed = true
+	}
+	if changed {
+		log.Info("Updating Deployment", "Deployment.Namespace", found.Namespace, "Deployment.Name", found.Name)
+		if err = r.Update(ctx, found); err != nil {
+			log.Error(err, "Failed to update Deployment", "Deployment.Namespace", found.Namespace, "Deployment.Name", found.Name)
+			return ctrl.Result{}, err
+		}
+		// Spec updated - return and requeue
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	// The Service is the single endpoint of the clients
+	if err = r.reconcileService(ctx, log, r.serviceForMcRouter(mcrouter)); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Update the status if needed
+	podList := &corev1.PodList{}
+	listOpts := []client.ListOption{
+		client.InNamespace(mcrouter.Namespace),
+		client.MatchingLabels(labelsForMcRouter(mcrouter.Name)),
+	}
+	if err = r.List(ctx, podList, listOpts...); err != nil {
+		log.Error(err, "Failed to list pods", "McRouter.Namespace", mcrouter.Namespace, "McRouter.Name", mcrouter.Name)
+		return ctrl.Result{}, err
+	}
+	status := cachev1alpha1.McRouterStatus{Nodes: getPodNames(podList.Items), Pools: pools, Reason: reason}
+	if !reflect.DeepEqual(status, mcrouter.Status) {
+		mcrouter.Status = status
+		if err := r.Status().Update(ctx, mcrouter); err != nil {
+			log.Error(err, "Failed to update McRouter status")
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{}, nil
+}
+
+// reconcileConfigMap creates the ConfigMap holding the route configuration of a McRouter, or updates it
+func (r *McRouterReconciler) reconcileConfigMap(ctx context.Context, log logr.Logger, mr *cachev1alpha1.McRouter, config string) error {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: mcrouterConfigMapName(mr.Name), Namespace: mr.Namespace, Labels: labelsForMcRouter(mr.Name)},
+		Data:       map[string]string{mcrouterConfigKey: config},
+	}
+	// Set McRouter instance as the owner and controller
+	ctrl.SetControllerReference(mr, cm, r.Scheme)
+
+	found := &corev1.ConfigMap{}
+	err := r.Get(ctx, types.NamespacedName{Name: cm.Name, Namespace: cm.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		log.Info("Creating a new ConfigMap", "ConfigMap.Namespace", cm.Namespace, "ConfigMap.Name", cm.Name)
+		if err = r.Create(ctx, cm); err != nil {
+			log.Error(err, "Failed to create new ConfigMap", "ConfigMap.Namespace", cm.Namespace, "ConfigMap.Name", cm.Name)
+			return err
+		}
+		return nil
+	} else if err != nil {
+		log.Error(err, "Failed to get ConfigMap")
+		return err
+	}
+
+	if !reflect.DeepEqual(found.Data, cm.Data) {
+		found.Data = cm.Data
+		log.Info("Updating the mcrouter configuration", "ConfigMap.Namespace", found.Namespace, "ConfigMap.Name", found.Name)
+		if err = r.Update(ctx, found); err != nil {
+			log.Error(err, "Failed to update ConfigMap", "ConfigMap.Namespace", found.Namespace, "ConfigMap.Name", found.Name)
+			return err
+		}
+	}
+	return nil
+}
+
+// reconcileService creates the Service of a McRouter, and keeps its ports and selector in line with the CR
+func (r *McRouterReconciler) reconcileService(ctx context.Context, log logr.Logger, svc *corev1.Service) error {
+	found := &corev1.Service{}
+	err := r.Get(ctx, types.NamespacedName{Name: svc.Name, Namespace: svc.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		log.Info("Creating a new Service", "Service.Namespace", svc.Namespace, "Service.Name", svc.Name)
+		if err = r.Create(ctx, svc); err != nil {
+			log.Error(err, "Failed to create new Service", "Service.Namespace", svc.Namespace, "Service.Name", svc.Name)
+			return err
+		}
+		return nil
+	} else if err != nil {
+		log.Error(err, "Failed to get Service")
+		return err
+	}
+
+	if !servicePortsMatch(found.Spec.Ports, svc.Spec.Ports) || !reflect.DeepEqual(found.Spec.Selector, svc.Spec.Selector) {
+		found.Spec.Ports = svc.Spec.Ports
+		found.Spec.Selector = svc.Spec.Selector
+		log.Info("Updating Service", "Service.Namespace", found.Namespace, "Service.Name", found.Name)
+		if err = r.Update(ctx, found); err != nil {
+			log.Error(err, "Failed to update Service", "Service.Namespace", found.Namespace, "Service.Name", found.Name)
+			return err
+		}
+	}
+	return nil
+}
+
+// deploymentForMcRouter returns a mcrouter Deployment object, reading its route configuration from the ConfigMap
+func (r *McRouterReconciler) deploymentForMcRouter(mr *cachev1alpha1.McRouter) *appsv1.Deployment {
+	ls := labelsForMcRouter(mr.Name)
+	replicas := mr.Spec.Size
+
+	dep := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      mr.Name,
+			Namespace: mr.Namespace,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: ls,
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: ls,
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Image: "jphalip/mcrouter:0.36.0",
+						Name:  "mcrouter",
+						// The spool and stats directories default to the root filesystem, which is read-only
+						Command: []string{
+							"mcrouter",
+							"--port=" + strconv.FormatInt(int64(mcrouterPort), 10),
+							"--config=file:" + mcrouterConfigPath + "/" + mcrouterConfigKey,
+							"--async-dir=/tmp/mcrouter/spool",
+							"--stats-root=/tmp/mcrouter/stats",
+						},
+						Ports: []corev1.ContainerPort{{
+							ContainerPort: mcrouterPort,
+							Name:          mcrouterPortName,
+						}},
+						VolumeMounts: []corev1.VolumeMount{{
+							Name:      mcrouterVolumeName,
+							MountPath: mcrouterConfigPath,
+							ReadOnly:  true,
+						}},
+					}},
+					Volumes: []corev1.Volume{{
+						Name: mcrouterVolumeName,
+						VolumeSource: corev1.VolumeSource{
+							ConfigMap: &corev1.ConfigMapVolumeSource{
+								LocalObjectReference: corev1.LocalObjectReference{Name: mcrouterConfigMapName(mr.Name)},
+							},
+						},
+					}},
+				},
+			},
+		},
+	}
+	// Run as nobody, with the restricted security context and the overrides of the CR, see podsecurity.go
+	applyPodSecurity(&dep.Spec.Template, mr.Spec.Security, webserverUser)
+	// mcrouter speaks the memcached protocol, but the image has no client to send the version command with
+	applyHealthChecks(&dep.Spec.Template, cachev1alpha1.HealthChecksSpec{}, mcrouterPortName,
+		corev1.Handler{TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromString(mcrouterPortName)}})
+	// Set McRouter instance as the owner and controller
+	ctrl.SetControllerReference(mr, dep, r.Scheme)
+	return dep
+}
+
+// mcrouterPodAnnotations are the pod template annotations the operator manages
+var mcrouterPodAnnotations = []string{corev1.SeccompPodAnnotationKey}
+
+// serviceForMcRouter returns a mcrouter Service object
+func (r *McRouterReconciler) serviceForMcRouter(mr *cachev1alpha1.McRouter) *corev1.Service {
+	ls := labelsForMcRouter(mr.Name)
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      mr.Name,
+			Namespace: mr.Namespace,
+			Labels:    ls,
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: ls,
+			Ports: []corev1.ServicePort{{
+				Name:       mcrouterPortName,
+				Port:       mcrouterPort,
+				TargetPort: intstr.FromString(mcrouterPortName),
+				Protocol:   corev1.ProtocolTCP,
+			}},
+		},
+	}
+	// Set McRouter instance as the owner and controller
+	ctrl.SetControllerReference(mr, svc, r.Scheme)
+	return svc
+}
+
+// mcrouterConfigMapName is the name of the ConfigMap holding the route configuration of a McRouter
+func mcrouterConfigMapName(name string) string {
+	return name + "-config"
+}
+
+// labelsForMcRouter returns the labels for selecting the resources
+// belonging to the given mcrouter CR name.
+func labelsForMcRouter(name string) map[string]string {
+	return map[string]string{"app": "mcrouter", "mcrouter_cr": name}
+}
+
+// mcroutersForMemcached maps a Memcached, or one of its pods, to the McRouters in its namespace routing to it
+func (r *McRouterReconciler) mcroutersForMemcached(obj handler.MapObject) []reconcile.Request {
+	name := obj.Meta.GetName()
+	if _, ok := obj.Object.(*corev1.Pod); ok {
+		// Only the pods of a Memcached, see labelsForMemcached
+		if obj.Meta.GetLabels()["app"] != "memcached" {
+			return nil
+		}
+		name = obj.Meta.GetLabels()["memcached_cr"]
+	}
+
+	mcrouterList := &cachev1alpha1.McRouterList{}
+	if err := r.List(context.Background(), mcrouterList, client.InNamespace(obj.Meta.GetNamespace())); err != nil {
+		r.Log.Error(err, "Failed to list McRouters", "Memcached.Namespace", obj.Meta.GetNamespace(), "Memcached.Name", name)
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, mr := range mcrouterList.Items {
+		for _, pool := range mr.Spec.Pools {
+			if pool.Name == name {
+				requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: mr.Name, Namespace: mr.Namespace}})
+				break
+			}
+		}
+	}
+	return requests
+}
+
+func (r *McRouterReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	mapToMcRouters := &handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.mcroutersForMemcached)}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&cachev1alpha1.McRouter{}).
+		Owns(&appsv1.Deployment{}).
+		Owns(&corev1.ConfigMap{}).
+		Owns(&corev1.Service{}).
+		// Render the route configuration again when a pool changes, or one of its pods becomes ready or goes away
+		Watches(&source.Kind{Type: &cachev1alpha1.Memcached{}}, mapToMcRouters).
+		Watches(&source.Kind{Type: &corev1.Pod{}}, mapToMcRouters).
+		Complete(r)
+}
@@ -0,0 +1,238 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	cachev1alpha1 "github.com/example-inc/memcached-operator/api/v1alpha1"
+)
+
+// The SASL configuration is rendered into a Secret owned by the Memcached, and mounted into the memcached pods.
+// Memcached reads the users from its own password database, a file of username:password lines.
+const (
+	saslConfigVolumeName = "sasl-config"
+	saslConfigPath       = "/etc/memcached/sasl"
+	saslConfKey          = "memcached.conf"
+	saslPasswordDBKey    = "memcached-sasl-pwdb"
+	// saslConfigHashAnnotation is a hash of the users on the pod template, so the pods roll when they change
+	saslConfigHashAnnotation = "cache.example.com/sasl-config-hash"
+)
+
+// Keys of the client Secret published for the consumers of a Memcached
+const (
+	clientSecretUsernameKey = "username"
+	clientSecretPasswordKey = "password"
+)
+
+// saslCredentials are the credentials the operator authenticates with to collect the stats of a Memcached
+type saslCredentials struct {
+	Username string
+	Password string
+}
+
+// saslSecretName is the name of the Secret holding the rendered SASL configuration of a Memcached
+func saslSecretName(name string) string {
+	return name + "-sasl"
+}
+
+// clientSecretName is the name of the Secret holding the credentials for the consumers of a Memcached
+func clientSecretName(name string) string {
+	return name + "-client"
+}
+
+// reconcileAuth renders the SASL configuration and the client Secret of a Memcached from the Secret of its users,
+// or deletes them when auth is disabled. It returns a hash of the users, and the credentials the operator uses
+// itself, or nil when auth is disabled.
+func (r *MemcachedReconciler) reconcileAuth(ctx context.Context, log logr.Logger, m *cachev1alpha1.Memcached) (string, *saslCredentials, error) {
+	if m.Spec.Auth == nil {
+		for _, name := range []string{saslSecretName(m.Name), clientSecretName(m.Name)} {
+			if err := r.deleteOwnedSecret(ctx, log, m, name); err != nil {
+				return "", nil, err
+			}
+		}
+		return "", nil, nil
+	}
+
+	users := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Name: m.Spec.Auth.SecretName, Namespace: m.Namespace}, users); err != nil {
+		log.Error(err, "Failed to get the Secret of the memcached users", "Secret.Name", m.Spec.Auth.SecretName)
+		return "", nil, err
+	}
+	var usernames []string
+	for username, password := range users.Data {
+		// Neither may contain the separators of the password database
+		if username == "" || len(password) == 0 || strings.ContainsAny(username, ":\n") || strings.Contains(string(password), "\n") {
+			log.Info("Skipping invalid memcached user", "Secret.Name", users.Name, "username", username)
+			continue
+		}
+		usernames = append(usernames, username)
+	}
+	if len(usernames) == 0 {
+		err := fmt.Errorf("Secret %s has no username/password pairs", users.Name)
+		log.Error(err, "Failed to render the SASL configuration")
+		return "", nil, err
+	}
+	sort.Strings(usernames)
+
+	var passwordDB strings.Builder
+	for _, username := range usernames {
+		passwordDB.WriteString(username + ":" + string(users.Data[username]) + "\n")
+	}
+	sum := sha256.Sum256([]byte(passwordDB.String()))
+	hash := hex.EncodeToString(sum[:])
+
+	sasl := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: saslSecretName(m.Name), Namespace: m.Namespace, Labels: labelsForMemcached(m.Name)},
+		Data: map[string][]byte{
+			saslConfKey:       []byte("mech_list: plain\n"),
+			saslPasswordDBKey: []byte(passwordDB.String()),
+		},
+	}
+	if err := r.reconcileOwnedSecret(ctx, log, m, sasl); err != nil {
+		return "", nil, err
+	}
+
+	clientUsername := usernames[0]
+	if name := m.Spec.Auth.ClientUsername; name != "" {
+		// Only a user in the password database can authenticate
+		if i := sort.SearchStrings(usernames, name); i == len(usernames) || usernames[i] != name {
+			err := fmt.Errorf("Secret %s has no user %s", users.Name, name)
+			log.Error(err, "Failed to publish the client credentials")
+			return "", nil, err
+		}
+		clientUsername = name
+	}
+	clientSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: clientSecretName(m.Name), Namespace: m.Namespace, Labels: labelsForMemcached(m.Name)},
+		Data: map[string][]byte{
+			clientSecretUsernameKey: []byte(clientUsername),
+			clientSecretPasswordKey: users.Data[clientUsername],
+		},
+	}
+	if err := r.reconcileOwnedSecret(ctx, log, m, clientSecret); err != nil {
+		return "", nil, err
+	}
+	return hash, &saslCredentials{Username: clientUsername, Password: string(users.Data[clientUsername])}, nil
+}
+
+// reconcileOwnedSecret creates a Secret owned by a Memcached, or updates its data
+func (r *MemcachedReconciler) reconcileOwnedSecret(ctx context.Context, log logr.Logger, m *cachev1alpha1.Memcached, secret *corev1.Secret) error {
+	// Set Memcached instance as the owner and controller
+	ctrl.SetControllerReference(m, secret, r.Scheme)
+	found := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: secret.Name, Namespace: secret.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		log.Info("Creating a new Secret", "Secret.Namespace", secret.Namespace, "Secret.Name", secret.Name)
+		if err = r.Create(ctx, secret); err != nil {
+			log.Error(err, "Failed to create new Secret", "Secret.Namespace", secret.Namespace, "Secret.Name", secret.Name)
+			return err
+		}
+		return nil
+	} else if err != nil {
+		log.Error(err, "Failed to get Secret")
+		return err
+	}
+
+	if !reflect.DeepEqual(found.Data, secret.Data) {
+		found.Data = secret.Data
+		log.Info("Updating Secret", "Secret.Namespace", found.Namespace, "Secret.Name", found.Name)
+		if err = r.Update(ctx, found); err != nil {
+			log.Error(err, "Failed to update Secret", "Secret.Namespace", found.Namespace, "Secret.Name", found.Name)
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteOwnedSecret deletes a Secret, if it is owned by the Memcached
+func (r *MemcachedReconciler) deleteOwnedSecret(ctx context.Context, log logr.Logger, m *cachev1alpha1.Memcached, name string) error {
+	found := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: m.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		log.Error(err, "Failed to get Secret")
+		return err
+	}
+	// Only delete a secret we created ourselves
+	if !metav1.IsControlledBy(found, m) {
+		return nil
+	}
+	log.Info("Deleting Secret", "Secret.Namespace", found.Namespace, "Secret.Name", found.Name)
+	if err := r.Delete(ctx, found); err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to delete Secret", "Secret.Namespace", found.Namespace, "Secret.Name", found.Name)
+		return err
+	}
+	return nil
+}
+
+// applySASL enables SASL on the memcached container of a pod template, with the configuration mounted from
+// the Secret rendered by reconcileAuth
+func applySASL(m *cachev1alpha1.Memcached, template *corev1.PodTemplateSpec) {
+	// The password database is only readable by the group of the pod, see applyPodSecurity
+	mode := int32(0440)
+	template.Spec.Volumes = append(template.Spec.Volumes, corev1.Volume{
+		Name: saslConfigVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: saslSecretName(m.Name), DefaultMode: &mode},
+		},
+	})
+	container := &template.Spec.Containers[0]
+	container.Command = append(container.Command, "-S")
+	container.Env = append(container.Env,
+		corev1.EnvVar{Name: "SASL_CONF_PATH", Value: saslConfigPath},
+		corev1.EnvVar{Name: "MEMCACHED_SASL_PWDB", Value: saslConfigPath + "/" + saslPasswordDBKey},
+	)
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      saslConfigVolumeName,
+		MountPath: saslConfigPath,
+		ReadOnly:  true,
+	})
+}
+
+// memcachedsForSecret maps a Secret to the Memcacheds in its namespace whose users or certificate it holds.
+// The certificate Secret is not owned when cert-manager issues it.
+func (r *MemcachedReconciler) memcachedsForSecret(obj handler.MapObject) []reconcile.Request {
+	memcachedList := &cachev1alpha1.MemcachedList{}
+	if err := r.List(context.Background(), memcachedList, client.InNamespace(obj.Meta.GetNamespace())); err != nil {
+		r.Log.Error(err, "Failed to list Memcacheds", "Secret.Namespace", obj.Meta.GetNamespace(), "Secret.Name", obj.Meta.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, m := range memcachedList.Items {
+		if (m.Spec.Auth != nil && m.Spec.Auth.SecretName == obj.Meta.GetName()) ||
+			(m.Spec.TLS != nil && tlsSecretName(m.Name) == obj.Meta.GetName()) {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: m.Name, Namespace: m.Namespace}})
+		}
+	}
+	return requests
+}
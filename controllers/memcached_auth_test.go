@@ -0,0 +1,220 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cachev1alpha1 "github.com/example-inc/memcached-operator/api/v1alpha1"
+)
+
+// writeBinaryResponse writes a binary protocol response without extras
+func writeBinaryResponse(conn net.Conn, opcode byte, status uint16, key, value string) {
+	packet := make([]byte, binaryHeaderLength)
+	packet[0] = binaryResponseMagic
+	packet[1] = opcode
+	binary.BigEndian.PutUint16(packet[2:4], uint16(len(key)))
+	binary.BigEndian.PutUint16(packet[6:8], status)
+	binary.BigEndian.PutUint32(packet[8:12], uint32(len(key)+len(value)))
+	conn.Write(append(packet, key+value...))
+}
+
+// serveBinaryStats accepts a single connection and answers it like memcached with SASL enabled and a
+// single user
+func serveBinaryStats(listener net.Listener, username, password string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	readRequest := func() (byte, string, string, bool) {
+		header := make([]byte, binaryHeaderLength)
+		if _, err := io.ReadFull(reader, header); err != nil || header[0] != binaryRequestMagic {
+			return 0, "", "", false
+		}
+		keyLength := int(binary.BigEndian.Uint16(header[2:4]))
+		body := make([]byte, binary.BigEndian.Uint32(header[8:12]))
+		if _, err := io.ReadFull(reader, body); err != nil {
+			return 0, "", "", false
+		}
+		return header[1], string(body[:keyLength]), string(body[keyLength:]), true
+	}
+
+	if opcode, mechanism, auth, ok := readRequest(); !ok || opcode != binaryAuthOpcode {
+		return
+	} else if mechanism != "PLAIN" || auth != "\x00"+username+"\x00"+password {
+		writeBinaryResponse(conn, binaryAuthOpcode, 0x20, "", "Auth failure")
+		return
+	}
+	writeBinaryResponse(conn, binaryAuthOpcode, binaryStatusOK, "", "Authenticated")
+
+	opcode, group, _, ok := readRequest()
+	if !ok || opcode != binaryStatOpcode || group != "" {
+		return
+	}
+	writeBinaryResponse(conn, binaryStatOpcode, binaryStatusOK, "evictions", "12")
+	writeBinaryResponse(conn, binaryStatOpcode, binaryStatusOK, "limit_maxbytes", "67108864")
+	writeBinaryResponse(conn, binaryStatOpcode, binaryStatusOK, "", "")
+}
+
+func TestMemcachedStatsSASL(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		want     map[string]string
+		wantErr  bool
+	}{
+		{
+			name:     "authenticated",
+			password: "secret",
+			want:     map[string]string{"evictions": "12", "limit_maxbytes": "67108864"},
+		},
+		{
+			name:     "wrong password",
+			password: "wrong",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("failed to listen: %v", err)
+			}
+			defer listener.Close()
+			go serveBinaryStats(listener, "operator", "secret")
+
+			access := memcachedAccess{Credentials: &saslCredentials{Username: "operator", Password: tt.password}}
+			got, err := memcachedStats(listener.Addr().String(), "stats", access)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("memcachedStats() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("memcachedStats() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcileAuth(t *testing.T) {
+	tests := []struct {
+		name           string
+		users          map[string][]byte
+		clientUsername string
+		wantPasswordDB string
+		wantClient     string
+		wantErr        bool
+	}{
+		{
+			name:           "users sorted by name",
+			users:          map[string][]byte{"web": []byte("web-secret"), "operator": []byte("secret")},
+			wantPasswordDB: "operator:secret\nweb:web-secret\n",
+			wantClient:     "operator",
+		},
+		{
+			name:           "client user",
+			users:          map[string][]byte{"web": []byte("web-secret"), "operator": []byte("secret")},
+			clientUsername: "web",
+			wantPasswordDB: "operator:secret\nweb:web-secret\n",
+			wantClient:     "web",
+		},
+		{
+			name: "invalid users are skipped",
+			users: map[string][]byte{
+				"web":         []byte("web-secret"),
+				"with:colon":  []byte("secret"),
+				"with\nline":  []byte("secret"),
+				"no-password": []byte(""),
+				"multiline":   []byte("sec\nret"),
+			},
+			wantPasswordDB: "web:web-secret\n",
+			wantClient:     "web",
+		},
+		{
+			name:    "no valid user",
+			users:   map[string][]byte{"with:colon": []byte("secret"), "no-password": []byte("")},
+			wantErr: true,
+		},
+		{
+			name:           "invalid client user",
+			users:          map[string][]byte{"web": []byte("web-secret"), "no-password": []byte("")},
+			clientUsername: "no-password",
+			wantErr:        true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			_ = clientgoscheme.AddToScheme(scheme)
+			_ = cachev1alpha1.AddToScheme(scheme)
+			users := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "users", Namespace: "default"}, Data: tt.users}
+			r := &MemcachedReconciler{Client: fake.NewFakeClientWithScheme(scheme, users), Log: ctrl.Log.WithName("test"), Scheme: scheme}
+			m := &cachev1alpha1.Memcached{
+				ObjectMeta: metav1.ObjectMeta{Name: "cache", Namespace: "default", UID: "uid"},
+				Spec: cachev1alpha1.MemcachedSpec{
+					Auth: &cachev1alpha1.MemcachedAuthSpec{SecretName: "users", ClientUsername: tt.clientUsername},
+				},
+			}
+			ctx := context.Background()
+
+			hash, creds, err := r.reconcileAuth(ctx, r.Log, m)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("reconcileAuth() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if hash == "" {
+				t.Errorf("reconcileAuth() hash is empty")
+			}
+			if creds == nil || creds.Username != tt.wantClient || creds.Password != string(tt.users[tt.wantClient]) {
+				t.Errorf("reconcileAuth() credentials = %v, want user %s", creds, tt.wantClient)
+			}
+
+			sasl := &corev1.Secret{}
+			if err := r.Get(ctx, types.NamespacedName{Name: saslSecretName(m.Name), Namespace: m.Namespace}, sasl); err != nil {
+				t.Fatalf("failed to get the SASL Secret: %v", err)
+			}
+			if got := string(sasl.Data[saslPasswordDBKey]); got != tt.wantPasswordDB {
+				t.Errorf("password database = %q, want %q", got, tt.wantPasswordDB)
+			}
+			if got := string(sasl.Data[saslConfKey]); got != "mech_list: plain\n" {
+				t.Errorf("SASL configuration = %q, want PLAIN only", got)
+			}
+			client := &corev1.Secret{}
+			if err := r.Get(ctx, types.NamespacedName{Name: clientSecretName(m.Name), Namespace: m.Namespace}, client); err != nil {
+				t.Fatalf("failed to get the client Secret: %v", err)
+			}
+			if got := string(client.Data[clientSecretUsernameKey]); got != tt.wantClient {
+				t.Errorf("client Secret username = %q, want %q", got, tt.wantClient)
+			}
+		})
+	}
+}
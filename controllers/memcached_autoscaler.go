@@ -0,0 +1,188 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	cachev1alpha1 "github.com/example-inc/memcached-operator/api/v1alpha1"
+)
+
+// Defaults of the Memcached autoscaling spec
+const (
+	defaultTargetEvictionsPerSecond       = 1
+	defaultTargetMemoryUtilizationPercent = 90
+	defaultMinHitRatioPercent             = 90
+	defaultScaleDownDelay                 = 10 * time.Minute
+)
+
+// memcachedAutoscalingSettings is the autoscaling spec of a Memcached with defaults applied
+type memcachedAutoscalingSettings struct {
+	minReplicas      int32
+	maxReplicas      int32
+	targetEvictions  float64
+	targetMemory     float64
+	minHitRatio      float64
+	scaleDownDelay   time.Duration
+	lastScaleTime    time.Time
+	hasLastScaleTime bool
+}
+
+func memcachedAutoscalingSettingsFor(m *cachev1alpha1.Memcached) memcachedAutoscalingSettings {
+	spec := m.Spec.Autoscaling
+	settings := memcachedAutoscalingSettings{
+		minReplicas:     m.Spec.Size,
+		maxReplicas:     spec.MaxReplicas,
+		targetEvictions: defaultTargetEvictionsPerSecond,
+		targetMemory:    defaultTargetMemoryUtilizationPercent / 100.0,
+		minHitRatio:     defaultMinHitRatioPercent / 100.0,
+		scaleDownDelay:  defaultScaleDownDelay,
+	}
+	if spec.MinReplicas != nil {
+		settings.minReplicas = *spec.MinReplicas
+	}
+	if settings.minReplicas < 1 {
+		settings.minReplicas = 1
+	}
+	if settings.maxReplicas < settings.minReplicas {
+		settings.maxReplicas = settings.minReplicas
+	}
+	if spec.TargetEvictionsPerSecond != nil {
+		settings.targetEvictions = float64(*spec.TargetEvictionsPerSecond)
+	}
+	if spec.TargetMemoryUtilizationPercent > 0 {
+		settings.targetMemory = float64(spec.TargetMemoryUtilizationPercent) / 100
+	}
+	if spec.MinHitRatioPercent != nil {
+		settings.minHitRatio = float64(*spec.MinHitRatioPercent) / 100
+	}
+	if spec.ScaleDownDelaySeconds != nil {
+		settings.scaleDownDelay = time.Duration(*spec.ScaleDownDelaySeconds) * time.Second
+	}
+	if status := m.Status.Autoscaling; status != nil && status.LastScaleTime != nil {
+		settings.lastScaleTime = status.LastScaleTime.Time
+		settings.hasLastScaleTime = true
+	}
+	return settings
+}
+
+// autoscale returns the number of replicas the deployment should have for the given usage of the pool.
+// It records the usage and the decision in the autoscaling status of the Memcached.
+func (r *MemcachedReconciler) autoscale(log logr.Logger, m *cachev1alpha1.Memcached, current int32, usage poolStats, ok bool, now time.Time) int32 {
+	desired, reason := memcachedReplicasFor(memcachedAutoscalingSettingsFor(m), current, usage, ok, now)
+
+	status := m.Status.Autoscaling
+	if status == nil {
+		status = &cachev1alpha1.MemcachedAutoscalingStatus{}
+		m.Status.Autoscaling = status
+	}
+	if ok {
+		status.EvictionsPerSecond = strconv.FormatFloat(usage.EvictionsPerSecond, 'f', 2, 64)
+		status.MemoryUtilizationPercent = int32(usage.MemoryUtilization * 100)
+		status.HitRatioPercent = nil
+		if usage.HasGets {
+			hitRatio := int32(usage.HitRatio * 100)
+			status.HitRatioPercent = &hitRatio
+		}
+	}
+	status.DesiredReplicas = desired
+	// Without a new sample, the reason of the previous decision still holds
+	if ok || desired != current || status.Reason == "" {
+		status.Reason = reason
+	}
+	if desired != current {
+		log.Info("Autoscaling Memcached", "from", current, "to", desired, "reason", reason)
+	}
+	return desired
+}
+
+// poolUsage collects a new stats sample, and computes the usage of the pool since the previous one.
+// It returns false until two samples far enough apart have been collected.
+func (r *MemcachedReconciler) poolUsage(log logr.Logger, key types.NamespacedName, pods []corev1.Pod, access memcachedAccess, now time.Time) (poolStats, bool) {
+	r.samplesMu.Lock()
+	previous, ok := r.samples[key]
+	r.samplesMu.Unlock()
+	if ok && now.Sub(previous.Time) < minStatsRateWindow {
+		// Too close to the previous sample for meaningful rates, e.g. a reconcile triggered by a pod or a status
+		// update, so don't even ask the pods
+		return poolStats{}, false
+	}
+
+	sample := collectStats(log, pods, access, now)
+	r.samplesMu.Lock()
+	defer r.samplesMu.Unlock()
+	if r.samples == nil {
+		r.samples = map[types.NamespacedName]statsSample{}
+	}
+	r.samples[key] = sample
+	if !ok || len(sample.Pods) == 0 {
+		return poolStats{}, false
+	}
+	return usageBetween(previous, sample), true
+}
+
+// forgetSamples drops the stats samples of a deleted Memcached
+func (r *MemcachedReconciler) forgetSamples(key types.NamespacedName) {
+	r.samplesMu.Lock()
+	defer r.samplesMu.Unlock()
+	delete(r.samples, key)
+}
+
+// memcachedReplicasFor decides the number of replicas of a memcached pool, and why. It scales up by one replica
+// when the pool evicts too much or is too full. It scales down by one replica, at most once per scale down
+// delay, when the remaining replicas would still hold the data without going over the memory target and the
+// hit ratio is above its minimum.
+func memcachedReplicasFor(s memcachedAutoscalingSettings, current int32, usage poolStats, ok bool, now time.Time) (int32, string) {
+	if current < s.minReplicas {
+		return s.minReplicas, "Replicas are raised to the autoscaling minimum of " + strconv.FormatInt(int64(s.minReplicas), 10)
+	}
+	if current > s.maxReplicas {
+		return s.maxReplicas, "Replicas are lowered to the autoscaling maximum of " + strconv.FormatInt(int64(s.maxReplicas), 10)
+	}
+	if !ok {
+		return current, "Waiting for memcached stats"
+	}
+
+	evictions := strconv.FormatFloat(usage.EvictionsPerSecond, 'f', 2, 64)
+	memory := strconv.FormatInt(int64(usage.MemoryUtilization*100), 10)
+	if usage.EvictionsPerSecond > s.targetEvictions || usage.MemoryUtilization > s.targetMemory {
+		why := "Evictions at " + evictions + "/s and memory utilization at " + memory + "% are above target"
+		if current >= s.maxReplicas {
+			return current, why + ", but replicas are at the maximum"
+		}
+		return current + 1, why + ", scaling up"
+	}
+
+	if current <= s.minReplicas {
+		return current, "Replicas are at the minimum"
+	}
+	if s.hasLastScaleTime && now.Sub(s.lastScaleTime) < s.scaleDownDelay {
+		return current, "Usage is below target, but the pool was scaled less than " + s.scaleDownDelay.String() + " ago"
+	}
+	if usage.HasGets && usage.HitRatio < s.minHitRatio {
+		return current, "Usage is below target, but the hit ratio of " + strconv.FormatInt(int64(usage.HitRatio*100), 10) + "% is too low to scale down"
+	}
+	// With one replica less, the same data is spread over less memory
+	projected := usage.MemoryUtilization * float64(current) / float64(current-1)
+	if projected > s.targetMemory {
+		return current, "Usage is below target, but memory utilization would reach " + strconv.FormatInt(int64(projected*100), 10) + "% with one replica less"
+	}
+	return current - 1, "Evictions at " + evictions + "/s and memory utilization at " + memory + "% leave room to scale down"
+}
@@ -0,0 +1,74 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemcachedReplicasFor(t *testing.T) {
+	now := time.Date(2020, time.June, 8, 12, 0, 0, 0, time.UTC)
+	settings := memcachedAutoscalingSettings{
+		minReplicas:     2,
+		maxReplicas:     4,
+		targetEvictions: 1,
+		targetMemory:    0.9,
+		minHitRatio:     0.9,
+		scaleDownDelay:  10 * time.Minute,
+	}
+	scaledAgo := func(d time.Duration) memcachedAutoscalingSettings {
+		s := settings
+		s.lastScaleTime = now.Add(-d)
+		s.hasLastScaleTime = true
+		return s
+	}
+	idle := poolStats{EvictionsPerSecond: 0, MemoryUtilization: 0.5}
+
+	tests := []struct {
+		name     string
+		settings memcachedAutoscalingSettings
+		current  int32
+		usage    poolStats
+		ok       bool
+		want     int32
+	}{
+		{name: "raised to the minimum", settings: settings, current: 1, want: 2},
+		{name: "lowered to the maximum", settings: settings, current: 5, want: 4},
+		{name: "waiting for stats", settings: settings, current: 3, want: 3},
+		{name: "evictions above target", settings: settings, current: 3, usage: poolStats{EvictionsPerSecond: 1.5, MemoryUtilization: 0.5}, ok: true, want: 4},
+		{name: "memory above target", settings: settings, current: 3, usage: poolStats{MemoryUtilization: 0.95}, ok: true, want: 4},
+		{name: "above target at the maximum", settings: settings, current: 4, usage: poolStats{EvictionsPerSecond: 5}, ok: true, want: 4},
+		{name: "evictions at target do not scale up", settings: settings, current: 3, usage: poolStats{EvictionsPerSecond: 1, MemoryUtilization: 0.5}, ok: true, want: 2},
+		{name: "below target at the minimum", settings: settings, current: 2, usage: idle, ok: true, want: 2},
+		{name: "below target", settings: settings, current: 3, usage: idle, ok: true, want: 2},
+		{name: "within the scale down delay", settings: scaledAgo(5 * time.Minute), current: 3, usage: idle, ok: true, want: 3},
+		{name: "after the scale down delay", settings: scaledAgo(10 * time.Minute), current: 3, usage: idle, ok: true, want: 2},
+		{name: "hit ratio too low", settings: settings, current: 3, usage: poolStats{MemoryUtilization: 0.5, HitRatio: 0.8, HasGets: true}, ok: true, want: 3},
+		{name: "hit ratio without gets", settings: settings, current: 3, usage: poolStats{MemoryUtilization: 0.5}, ok: true, want: 2},
+		{name: "memory would go above target", settings: settings, current: 3, usage: poolStats{MemoryUtilization: 0.7}, ok: true, want: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reason := memcachedReplicasFor(tt.settings, tt.current, tt.usage, tt.ok, now)
+			if got != tt.want {
+				t.Errorf("memcachedReplicasFor() = %v (%s), want %v", got, reason, tt.want)
+			}
+			if reason == "" {
+				t.Errorf("memcachedReplicasFor() gave no reason")
+			}
+		})
+	}
+}
@@ -17,16 +17,23 @@ package controllers
 import (
 	"context"
 	"reflect"
+	"sync"
+	"time"
 
 	"github.com/go-logr/logr"
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2beta2 "k8s.io/api/autoscaling/v2beta2"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	cachev1alpha1 "github.com/example-inc/memcached-operator/api/v1alpha1"
 )
@@ -36,12 +43,24 @@ type MemcachedReconciler struct {
 	client.Client
 	Log    logr.Logger
 	Scheme *runtime.Scheme
+	// Clock is used to evaluate the scaling schedules
+	Clock clock.Clock
+
+	// samples are the latest stats of every autoscaled Memcached, see memcached_autoscaler.go
+	samplesMu sync.Mutex
+	samples   map[types.NamespacedName]statsSample
 }
 
 // +kubebuilder:rbac:groups=cache.example.com,resources=memcacheds,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=cache.example.com,resources=memcacheds/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=autoscaling,resources=horizontalpodautoscalers,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=cert-manager.io,resources=certificates,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
 
 func (r *MemcachedReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	ctx := context.Background() // this context will NOT trigger a new Reconcile. It is often used to update Status about the result from a Reconcile action.
@@ -56,6 +75,7 @@ func (r *MemcachedReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
 			// Return and don't requeue
 			log.Info("Memcached resource not found. Ignoring since object must be deleted")
+			r.forgetSamples(req.NamespacedName)
 			return ctrl.Result{}, nil
 		}
 		// Error reading the object - requeue the request.
@@ -63,12 +83,53 @@ func (r *MemcachedReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return ctrl.Result{}, err
 	}
 
+	// Delete the copies of the server list in other namespaces before the Memcached goes away, see memcached_serverlist.go
+	if err := r.finalizeServerList(ctx, log, memcached); err != nil {
+		return ctrl.Result{}, err
+	}
+	if memcached.DeletionTimestamp != nil {
+		return ctrl.Result{}, nil
+	}
+
+	// Render the SASL configuration and the client credentials, see memcached_auth.go
+	saslHash, creds, err := r.reconcileAuth(ctx, log, memcached)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	// Issue or renew the serving certificate, see memcached_tls.go
+	now := r.Clock.Now()
+	previousTLS := memcached.Status.TLS.DeepCopy()
+	previousConditions := append([]cachev1alpha1.Condition(nil), memcached.Status.Conditions...)
+	previousServerListGeneration := memcached.Status.ServerListGeneration
+	setPodSecurityCondition(&memcached.Status.Conditions, memcached.Spec.Security, now)
+	serving, err := r.reconcileTLS(ctx, log, memcached, now)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	// Restrict who can connect to memcached, see memcached_networkpolicy.go
+	if err := r.reconcileNetworkPolicy(ctx, log, memcached); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	access := memcachedAccess{Credentials: creds}
+	// The pods roll whenever one of these changes
+	podAnnotations := map[string]string{}
+	if saslHash != "" {
+		podAnnotations[saslConfigHashAnnotation] = saslHash
+	}
+	if serving != nil {
+		podAnnotations[tlsCertHashAnnotation] = serving.certHash
+		access.TLS = serving.clientConfig
+	}
+
 	// Check if the deployment already exists, if not create a new one
 	found := &appsv1.Deployment{}
 	err = r.Get(ctx, types.NamespacedName{Name: memcached.Name, Namespace: memcached.Namespace}, found)
+	// The sizing applied in Apply mode is kept on the deployment, see memcached_sizing.go
+	restoreAppliedSizing(memcached, found)
 	if err != nil && errors.IsNotFound(err) {
 		// Define a new deployment
-		dep := r.deploymentForMemcached(memcached)
+		dep := r.deploymentForMemcached(memcached, podAnnotations)
 		log.Info("Creating a new Deployment", "Deployment.Namespace", dep.Namespace, "Deployment.Name", dep.Name)
 		err = r.Create(ctx, dep)
 		if err != nil {
@@ -83,19 +144,6 @@ func (r *MemcachedReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return ctrl.Result{}, err
 	}
 
-	// Ensure the deployment size is the same as the spec in the CR
-	size := memcached.Spec.Size
-	if *found.Spec.Replicas != size {
-		found.Spec.Replicas = &size
-		err = r.Update(ctx, found)
-		if err != nil {
-			log.Error(err, "Failed to update Deployment", "Deployment.Namespace", found.Namespace, "Deployment.Name", found.Name)
-			return ctrl.Result{}, err
-		}
-		// Spec updated - return and requeue
-		return ctrl.Result{Requeue: true}, nil
-	}
-
 	// Get a list of the pods for this CRs deployment
 	podList := &corev1.PodList{}
 	listOpts := []client.ListOption{
@@ -108,9 +156,97 @@ func (r *MemcachedReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	}
 	podNames := getPodNames(podList.Items)
 
-	// Update CR's status.Nodes if needed
-	if !reflect.DeepEqual(podNames, memcached.Status.Nodes) {
+	// Publish the ready pods for the clients, see memcached_serverlist.go
+	if err = r.reconcileService(ctx, log, memcached); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err = r.reconcileServerList(ctx, log, memcached, podList.Items); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Create, update or delete the HorizontalPodAutoscaler, depending on the spec in the CR, see hpa.go
+	hpaStatus, err := reconcileHPA(ctx, r.Client, r.Scheme, log, memcached, found, memcached.Spec.HorizontalPodAutoscaler, memcached.Spec.Size)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	memcached.Status.HorizontalPodAutoscaler = hpaStatus
+
+	// The autoscaler and the sizing recommendations work from the stats of the memcached pods
+	var usage poolStats
+	var haveUsage bool
+	requeueAfter := time.Duration(0)
+	if memcached.Spec.Autoscaling != nil || memcached.Spec.Sizing != nil {
+		usage, haveUsage = r.poolUsage(log, req.NamespacedName, podList.Items, access, now)
+		requeueAfter = memcachedStatsInterval
+	}
+
+	// Ensure the deployment size is the same as the spec in the CR, or what the autoscaler or the sizing
+	// recommendation decides, within the bounds of the scheduled scaling windows, see schedule.go
+	bounds, nextBoundary := evaluateSchedules(log, memcached.Spec.Schedules, now)
+	size := memcached.Spec.Size
+	if memcached.Spec.Autoscaling != nil && memcached.Spec.HorizontalPodAutoscaler == nil {
+		size = r.autoscale(log, memcached, *found.Spec.Replicas, usage, haveUsage, now)
+	} else {
+		memcached.Status.Autoscaling = nil
+	}
+	if memcached.Spec.Sizing != nil {
+		recommended := r.recommendSize(log, memcached, *found.Spec.Replicas, usage, haveUsage, now)
+		if recommended > 0 && memcached.Spec.Autoscaling == nil {
+			size = recommended
+		}
+	} else {
+		memcached.Status.Sizing = nil
+	}
+	size, _ = bounds.apply(size)
+	if memcached.Spec.HorizontalPodAutoscaler != nil {
+		// The HorizontalPodAutoscaler owns the replicas
+		size = *found.Spec.Replicas
+	}
+
+	// Ensure the pods are the same as the spec in the CR, with the memory of the applied sizing recommendation,
+	// see memcached_sizing.go, the current SASL configuration and certificate, and the security context. Changing them rolls the pods
+	updated := false
+	if *found.Spec.Replicas != size {
+		found.Spec.Replicas = &size
+		updated = true
+		if memcached.Spec.Autoscaling != nil {
+			// The scale down delay counts from here
+			lastScaleTime := metav1.NewTime(now)
+			memcached.Status.Autoscaling.LastScaleTime = &lastScaleTime
+		}
+	}
+	// The applied sizing is recorded on the deployment in the same update that rolls it out
+	if setAppliedSizingAnnotations(memcached, found) {
+		updated = true
+	}
+	if syncPodTemplate(&found.Spec.Template, &r.deploymentForMemcached(memcached, podAnnotations).Spec.Template, memcachedPodAnnotations) {
+		log.Info("Updating the memcached pods", "memoryMB", memcachedMemoryMB(memcached), "auth", memcached.Spec.Auth != nil, "tls", serving != nil)
+		updated = true
+	}
+	if updated {
+		err = r.Update(ctx, found)
+		if err != nil {
+			log.Error(err, "Failed to update Deployment", "Deployment.Namespace", found.Namespace, "Deployment.Name", found.Name)
+			return ctrl.Result{}, err
+		}
+		if memcached.Spec.Autoscaling != nil || memcached.Spec.Sizing != nil || memcached.Spec.HorizontalPodAutoscaler != nil {
+			if err := r.Status().Update(ctx, memcached); err != nil {
+				log.Error(err, "Failed to update Memcached status")
+				return ctrl.Result{}, err
+			}
+		}
+		// Spec updated - return and requeue
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	// Update CR's status.Nodes and status.ActiveSchedules if needed
+	// The autoscaling and sizing status change on every reconcile, so they are always updated
+	if !reflect.DeepEqual(podNames, memcached.Status.Nodes) || !reflect.DeepEqual(bounds.active, memcached.Status.ActiveSchedules) ||
+		!reflect.DeepEqual(previousTLS, memcached.Status.TLS) || !reflect.DeepEqual(previousConditions, memcached.Status.Conditions) ||
+		previousServerListGeneration != memcached.Status.ServerListGeneration ||
+		memcached.Status.Autoscaling != nil || memcached.Status.Sizing != nil || memcached.Status.HorizontalPodAutoscaler != nil {
 		memcached.Status.Nodes = podNames
+		memcached.Status.ActiveSchedules = bounds.active
 		err := r.Status().Update(ctx, memcached)
 		if err != nil {
 			log.Error(err, "Failed to update Memcached status")
@@ -118,11 +254,17 @@ func (r *MemcachedReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		}
 	}
 
-	return ctrl.Result{}, nil
+	// Collect the stats again on the next interval when autoscaling or sizing, and reconcile exactly when a schedule
+	// window starts or ends, or the certificate issued by the operator is due for renewal
+	requeueAfter = requeueAtBoundary(requeueAfter, now, nextBoundary)
+	if serving != nil && !serving.renewAt.IsZero() {
+		requeueAfter = requeueAtBoundary(requeueAfter, now, serving.renewAt)
+	}
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
 }
 
-// deploymentForMemcached returns a memcached Deployment object
-func (r *MemcachedReconciler) deploymentForMemcached(m *cachev1alpha1.Memcached) *appsv1.Deployment {
+// deploymentForMemcached returns a memcached Deployment object. The annotations are set on the pod template
+func (r *MemcachedReconciler) deploymentForMemcached(m *cachev1alpha1.Memcached, podAnnotations map[string]string) *appsv1.Deployment {
 	ls := labelsForMemcached(m.Name)
 	replicas := m.Spec.Size
 
@@ -138,15 +280,17 @@ func (r *MemcachedReconciler) deploymentForMemcached(m *cachev1alpha1.Memcached)
 			},
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: ls,
+					Labels:      ls,
+					Annotations: podAnnotations,
 				},
 				Spec: corev1.PodSpec{
 					Containers: []corev1.Container{{
-						Image:   "memcached:1.4.36-alpine",
-						Name:    "memcached",
-						Command: []string{"memcached", "-m=64", "-o", "modern", "-v"},
+						Image:     "memcached:1.4.36-alpine",
+						Name:      "memcached",
+						Command:   memcachedCommand(memcachedMemoryMB(m)),
+						Resources: memcachedResources(memcachedMemoryMB(m)),
 						Ports: []corev1.ContainerPort{{
-							ContainerPort: 11211,
+							ContainerPort: memcachedPort,
 							Name:          "memcached",
 						}},
 					}},
@@ -154,11 +298,26 @@ func (r *MemcachedReconciler) deploymentForMemcached(m *cachev1alpha1.Memcached)
 			},
 		},
 	}
+	if m.Spec.Auth != nil {
+		applySASL(m, &dep.Spec.Template)
+	}
+	// TLS is only enabled once the certificate is issued, which the annotation of its hash tells
+	_, tls := podAnnotations[tlsCertHashAnnotation]
+	if tls {
+		applyTLS(m, &dep.Spec.Template)
+	}
+	// Run as the memcache user, with the restricted security context and the overrides of the CR, see podsecurity.go
+	applyPodSecurity(&dep.Spec.Template, m.Spec.Security, memcachedUser)
+	// Readiness and liveness probes, see healthchecks.go
+	applyHealthChecks(&dep.Spec.Template, m.Spec.HealthChecks, "memcached", memcachedLivenessCheck(m, tls))
 	// Set Memcached instance as the owner and controller
 	ctrl.SetControllerReference(m, dep, r.Scheme)
 	return dep
 }
 
+// memcachedPodAnnotations are the pod template annotations the operator manages
+var memcachedPodAnnotations = []string{saslConfigHashAnnotation, tlsCertHashAnnotation, corev1.SeccompPodAnnotationKey}
+
 // labelsForMemcached returns the labels for selecting the resources
 // belonging to the given memcached CR name.
 func labelsForMemcached(name string) map[string]string {
@@ -178,5 +337,14 @@ func (r *MemcachedReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&cachev1alpha1.Memcached{}). // these two replaces Watches(...) function that is used in older documentation and guides/blogs. Might be other functions that I can also use!
 		Owns(&appsv1.Deployment{}).      // these two replaces Watches(...) function that is used in older documentation and guides/blogs. Might be other functions that I can also use!
+		Owns(&autoscalingv2beta2.HorizontalPodAutoscaler{}).
+		Owns(&corev1.Secret{}).
+		Owns(&corev1.ConfigMap{}).
+		Owns(&corev1.Service{}).
+		Owns(&networkingv1.NetworkPolicy{}).
+		// Roll the pods when the users or the certificate of a Memcached change
+		Watches(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.memcachedsForSecret)}).
+		// Update the server list when a pod becomes ready or goes away
+		Watches(&source.Kind{Type: &corev1.Pod{}}, &handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(memcachedForPod)}).
 		Complete(r)
 }
@@ -0,0 +1,120 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	cachev1alpha1 "github.com/example-inc/memcached-operator/api/v1alpha1"
+)
+
+// operatorLabels are the labels of the operator pod and of its namespace, see config/manager/manager.yaml.
+// The operator connects to the memcached pods to collect their stats.
+var operatorLabels = map[string]string{"control-plane": "controller-manager"}
+
+// reconcileNetworkPolicy creates, updates or deletes the NetworkPolicy of a Memcached, depending on spec.allowedClients
+func (r *MemcachedReconciler) reconcileNetworkPolicy(ctx context.Context, log logr.Logger, m *cachev1alpha1.Memcached) error {
+	found := &networkingv1.NetworkPolicy{}
+	err := r.Get(ctx, types.NamespacedName{Name: m.Name, Namespace: m.Namespace}, found)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to get NetworkPolicy")
+		return err
+	}
+	exists := err == nil
+
+	if m.Spec.AllowedClients == nil {
+		// Only delete a network policy we created ourselves
+		if exists && metav1.IsControlledBy(found, m) {
+			log.Info("Deleting NetworkPolicy", "NetworkPolicy.Namespace", found.Namespace, "NetworkPolicy.Name", found.Name)
+			err = r.Delete(ctx, found)
+			if err != nil && !errors.IsNotFound(err) {
+				log.Error(err, "Failed to delete NetworkPolicy", "NetworkPolicy.Namespace", found.Namespace, "NetworkPolicy.Name", found.Name)
+				return err
+			}
+		}
+		return nil
+	}
+
+	np := r.networkPolicyForMemcached(log, m)
+	if !exists {
+		log.Info("Creating a new NetworkPolicy", "NetworkPolicy.Namespace", np.Namespace, "NetworkPolicy.Name", np.Name)
+		err = r.Create(ctx, np)
+		if err != nil {
+			log.Error(err, "Failed to create new NetworkPolicy", "NetworkPolicy.Namespace", np.Namespace, "NetworkPolicy.Name", np.Name)
+			return err
+		}
+		return nil
+	}
+
+	// Ensure the network policy is the same as the spec in the CR
+	if !equality.Semantic.DeepEqual(found.Spec, np.Spec) {
+		found.Spec = np.Spec
+		log.Info("Updating NetworkPolicy", "NetworkPolicy.Namespace", found.Namespace, "NetworkPolicy.Name", found.Name)
+		err = r.Update(ctx, found)
+		if err != nil {
+			log.Error(err, "Failed to update NetworkPolicy", "NetworkPolicy.Namespace", found.Namespace, "NetworkPolicy.Name", found.Name)
+			return err
+		}
+	}
+	return nil
+}
+
+// networkPolicyForMemcached returns a NetworkPolicy object only letting the allowed clients and the operator
+// connect to the memcached port of the pods of a Memcached
+func (r *MemcachedReconciler) networkPolicyForMemcached(log logr.Logger, m *cachev1alpha1.Memcached) *networkingv1.NetworkPolicy {
+	peers := []networkingv1.NetworkPolicyPeer{{
+		PodSelector:       &metav1.LabelSelector{MatchLabels: operatorLabels},
+		NamespaceSelector: &metav1.LabelSelector{MatchLabels: operatorLabels},
+	}}
+	for _, c := range m.Spec.AllowedClients {
+		if c.PodSelector == nil && c.NamespaceSelector == nil {
+			log.Info("Skipping allowed client without selectors")
+			continue
+		}
+		peers = append(peers, networkingv1.NetworkPolicyPeer{PodSelector: c.PodSelector, NamespaceSelector: c.NamespaceSelector})
+	}
+
+	// The protocol and the policy types are set explicitly, as the API server defaults them
+	protocol := corev1.ProtocolTCP
+	port := intstr.FromInt(int(memcachedPort))
+	np := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      m.Name,
+			Namespace: m.Namespace,
+			Labels:    labelsForMemcached(m.Name),
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: labelsForMemcached(m.Name)},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{{
+				Ports: []networkingv1.NetworkPolicyPort{{Protocol: &protocol, Port: &port}},
+				From:  peers,
+			}},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+		},
+	}
+	// Set Memcached instance as the owner and controller
+	ctrl.SetControllerReference(m, np, r.Scheme)
+	return np
+}
@@ -0,0 +1,315 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	cachev1alpha1 "github.com/example-inc/memcached-operator/api/v1alpha1"
+)
+
+// Keys of the server list ConfigMap of a Memcached
+const (
+	serverListServersKey    = "servers"
+	serverListGenerationKey = "generation"
+)
+
+const (
+	// serverListNamespaceLabel is set on the copies of the server list in consumer namespaces, to the namespace of
+	// the Memcached. They can't be owned by it, so the operator deletes them itself
+	serverListNamespaceLabel = "cache.example.com/memcached-namespace"
+	// serverListFinalizer makes the operator delete the copies of the server list before the Memcached goes away
+	serverListFinalizer = "cache.example.com/server-list"
+)
+
+// serverListName is the name of the ConfigMap holding the server list of a Memcached
+func serverListName(name string) string {
+	return name + "-servers"
+}
+
+// readyMemcachedServers returns the addresses of the ready memcached pods, ordered by pod name. The pods are
+// addressed by their hostname, so clients can verify the certificate of the Memcached.
+func readyMemcachedServers(pods []corev1.Pod) []string {
+	sorted := append([]corev1.Pod(nil), pods...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	var servers []string
+	for i := range sorted {
+		pod := &sorted[i]
+		if pod.DeletionTimestamp != nil || pod.Status.PodIP == "" || !isPodReady(pod) {
+			continue
+		}
+		servers = append(servers, net.JoinHostPort(memcachedHostname(pod), strconv.FormatInt(int64(memcachedPort), 10)))
+	}
+	return servers
+}
+
+// memcachedHostname returns the hostname of a memcached pod. The cluster DNS resolves it for the endpoints of the
+// headless Service of the Memcached, and the certificate of the Memcached covers it, see certificateDNSNames.
+func memcachedHostname(pod *corev1.Pod) string {
+	ip := strings.NewReplacer(".", "-", ":", "-").Replace(pod.Status.PodIP)
+	return ip + "." + pod.Labels["memcached_cr"] + "." + pod.Namespace + ".svc"
+}
+
+// reconcileService creates the headless Service giving the memcached pods their hostnames, and keeps its ports and
+// selector in line with the CR
+func (r *MemcachedReconciler) reconcileService(ctx context.Context, log logr.Logger, m *cachev1alpha1.Memcached) error {
+	svc := r.serviceForMemcached(m)
+	found := &corev1.Service{}
+	err := r.Get(ctx, types.NamespacedName{Name: svc.Name, Namespace: svc.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		log.Info("Creating a new Service", "Service.Namespace", svc.Namespace, "Service.Name", svc.Name)
+		if err = r.Create(ctx, svc); err != nil {
+			log.Error(err, "Failed to create new Service", "Service.Namespace", svc.Namespace, "Service.Name", svc.Name)
+			return err
+		}
+		return nil
+	} else if err != nil {
+		log.Error(err, "Failed to get Service")
+		return err
+	}
+
+	if !servicePortsMatch(found.Spec.Ports, svc.Spec.Ports) || !reflect.DeepEqual(found.Spec.Selector, svc.Spec.Selector) ||
+		!found.Spec.PublishNotReadyAddresses {
+		found.Spec.Ports = svc.Spec.Ports
+		found.Spec.Selector = svc.Spec.Selector
+		found.Spec.PublishNotReadyAddresses = true
+		log.Info("Updating Service", "Service.Namespace", found.Namespace, "Service.Name", found.Name)
+		if err = r.Update(ctx, found); err != nil {
+			log.Error(err, "Failed to update Service", "Service.Namespace", found.Namespace, "Service.Name", found.Name)
+			return err
+		}
+	}
+	return nil
+}
+
+// serviceForMemcached returns the headless memcached Service object. The not ready pods are published too, so
+// their hostname resolves by the time they are ready and join the server list.
+func (r *MemcachedReconciler) serviceForMemcached(m *cachev1alpha1.Memcached) *corev1.Service {
+	ls := labelsForMemcached(m.Name)
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      m.Name,
+			Namespace: m.Namespace,
+			Labels:    ls,
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP:                corev1.ClusterIPNone,
+			Selector:                 ls,
+			PublishNotReadyAddresses: true,
+			Ports: []corev1.ServicePort{{
+				Name:       "memcached",
+				Port:       memcachedPort,
+				TargetPort: intstr.FromString("memcached"),
+				Protocol:   corev1.ProtocolTCP,
+			}},
+		},
+	}
+	// Set Memcached instance as the owner and controller
+	ctrl.SetControllerReference(m, svc, r.Scheme)
+	return svc
+}
+
+// ketamaPoint is the first point of a server on a ketama ring, as libmemcached computes it from the MD5 of
+// "<host>-0", or "<host>:<port>-0" when the server does not listen on the default port
+func ketamaPoint(address string) uint32 {
+	key := address
+	if host, port, err := net.SplitHostPort(address); err == nil && port == strconv.FormatInt(int64(memcachedPort), 10) {
+		key = host
+	}
+	digest := md5.Sum([]byte(key + "-0"))
+	return binary.LittleEndian.Uint32(digest[0:4])
+}
+
+// ketamaOrder sorts servers by their first point on a ketama ring
+func ketamaOrder(servers []string) []string {
+	sorted := append([]string(nil), servers...)
+	sort.Slice(sorted, func(i, j int) bool {
+		pi, pj := ketamaPoint(sorted[i]), ketamaPoint(sorted[j])
+		if pi != pj {
+			return pi < pj
+		}
+		return sorted[i] < sorted[j]
+	})
+	return sorted
+}
+
+// reconcileServerList publishes the ready pods of a Memcached in its server list ConfigMap, and in the copies in
+// the consumer namespaces. The generation is only incremented when the servers change, so clients only rebuild
+// their ring then.
+func (r *MemcachedReconciler) reconcileServerList(ctx context.Context, log logr.Logger, m *cachev1alpha1.Memcached, pods []corev1.Pod) error {
+	servers := strings.Join(ketamaOrder(readyMemcachedServers(pods)), "\n")
+
+	found := &corev1.ConfigMap{}
+	err := r.Get(ctx, types.NamespacedName{Name: serverListName(m.Name), Namespace: m.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		cm := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: serverListName(m.Name), Namespace: m.Namespace, Labels: labelsForMemcached(m.Name)},
+			Data:       map[string]string{serverListServersKey: servers, serverListGenerationKey: "1"},
+		}
+		// Set Memcached instance as the owner and controller
+		ctrl.SetControllerReference(m, cm, r.Scheme)
+		log.Info("Creating a new ConfigMap", "ConfigMap.Namespace", cm.Namespace, "ConfigMap.Name", cm.Name)
+		if err = r.Create(ctx, cm); err != nil {
+			log.Error(err, "Failed to create new ConfigMap", "ConfigMap.Namespace", cm.Namespace, "ConfigMap.Name", cm.Name)
+			return err
+		}
+		found = cm
+	} else if err != nil {
+		log.Error(err, "Failed to get ConfigMap")
+		return err
+	} else if found.Data[serverListServersKey] != servers {
+		generation, _ := strconv.ParseInt(found.Data[serverListGenerationKey], 10, 64)
+		found.Data = map[string]string{serverListServersKey: servers, serverListGenerationKey: strconv.FormatInt(generation+1, 10)}
+		log.Info("Updating the server list", "ConfigMap.Namespace", found.Namespace, "ConfigMap.Name", found.Name, "generation", generation+1)
+		if err = r.Update(ctx, found); err != nil {
+			log.Error(err, "Failed to update ConfigMap", "ConfigMap.Namespace", found.Namespace, "ConfigMap.Name", found.Name)
+			return err
+		}
+	}
+	m.Status.ServerListGeneration, _ = strconv.ParseInt(found.Data[serverListGenerationKey], 10, 64)
+
+	return r.reconcileServerListCopies(ctx, log, m, found.Data, m.Spec.ServerList.ConsumerNamespaces)
+}
+
+// reconcileServerListCopies copies the server list of a Memcached into the given namespaces, and deletes the copies
+// in any other namespace
+func (r *MemcachedReconciler) reconcileServerListCopies(ctx context.Context, log logr.Logger, m *cachev1alpha1.Memcached, data map[string]string, namespaces []string) error {
+	copies := &corev1.ConfigMapList{}
+	if err := r.List(ctx, copies, client.MatchingLabels{"memcached_cr": m.Name, serverListNamespaceLabel: m.Namespace}); err != nil {
+		log.Error(err, "Failed to list the copies of the server list")
+		return err
+	}
+	wanted := map[string]bool{}
+	for _, namespace := range namespaces {
+		if namespace != m.Namespace {
+			wanted[namespace] = true
+		}
+	}
+
+	for i := range copies.Items {
+		found := &copies.Items[i]
+		if !wanted[found.Namespace] || found.Name != serverListName(m.Name) {
+			log.Info("Deleting ConfigMap", "ConfigMap.Namespace", found.Namespace, "ConfigMap.Name", found.Name)
+			if err := r.Delete(ctx, found); err != nil && !errors.IsNotFound(err) {
+				log.Error(err, "Failed to delete ConfigMap", "ConfigMap.Namespace", found.Namespace, "ConfigMap.Name", found.Name)
+				return err
+			}
+			continue
+		}
+		delete(wanted, found.Namespace)
+		if !reflect.DeepEqual(found.Data, data) {
+			found.Data = data
+			log.Info("Updating the server list", "ConfigMap.Namespace", found.Namespace, "ConfigMap.Name", found.Name)
+			if err := r.Update(ctx, found); err != nil {
+				log.Error(err, "Failed to update ConfigMap", "ConfigMap.Namespace", found.Namespace, "ConfigMap.Name", found.Name)
+				return err
+			}
+		}
+	}
+
+	for _, namespace := range namespaces {
+		if !wanted[namespace] {
+			continue
+		}
+		labels := labelsForMemcached(m.Name)
+		labels[serverListNamespaceLabel] = m.Namespace
+		cm := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: serverListName(m.Name), Namespace: namespace, Labels: labels},
+			Data:       data,
+		}
+		log.Info("Creating a new ConfigMap", "ConfigMap.Namespace", cm.Namespace, "ConfigMap.Name", cm.Name)
+		if err := r.Create(ctx, cm); err != nil && errors.IsNotFound(err) {
+			// The namespace does not exist (yet)
+			log.Info("Skipping the copy of the server list into a missing namespace", "namespace", namespace)
+		} else if err != nil {
+			log.Error(err, "Failed to create new ConfigMap", "ConfigMap.Namespace", cm.Namespace, "ConfigMap.Name", cm.Name)
+			return err
+		}
+	}
+	return nil
+}
+
+// finalizeServerList deletes the copies of the server list of a Memcached that is being deleted, and removes the
+// finalizer. It adds the finalizer while the Memcached has consumer namespaces.
+func (r *MemcachedReconciler) finalizeServerList(ctx context.Context, log logr.Logger, m *cachev1alpha1.Memcached) error {
+	if m.DeletionTimestamp == nil {
+		if len(m.Spec.ServerList.ConsumerNamespaces) == 0 || containsString(m.Finalizers, serverListFinalizer) {
+			return nil
+		}
+		m.Finalizers = append(m.Finalizers, serverListFinalizer)
+		if err := r.Update(ctx, m); err != nil {
+			log.Error(err, "Failed to add the finalizer")
+			return err
+		}
+		return nil
+	}
+
+	if !containsString(m.Finalizers, serverListFinalizer) {
+		return nil
+	}
+	if err := r.reconcileServerListCopies(ctx, log, m, nil, nil); err != nil {
+		return err
+	}
+	var finalizers []string
+	for _, finalizer := range m.Finalizers {
+		if finalizer != serverListFinalizer {
+			finalizers = append(finalizers, finalizer)
+		}
+	}
+	m.Finalizers = finalizers
+	if err := r.Update(ctx, m); err != nil {
+		log.Error(err, "Failed to remove the finalizer")
+		return err
+	}
+	return nil
+}
+
+// memcachedForPod maps a memcached pod to its Memcached, so the server list follows the pods becoming ready and
+// going away
+func memcachedForPod(obj handler.MapObject) []reconcile.Request {
+	// Only the pods of a Memcached, see labelsForMemcached
+	if obj.Meta.GetLabels()["app"] != "memcached" || obj.Meta.GetLabels()["memcached_cr"] == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: obj.Meta.GetLabels()["memcached_cr"], Namespace: obj.Meta.GetNamespace()}}}
+}
+
+// containsString returns true if the slice contains the string
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKetamaPoint(t *testing.T) {
+	// The first 4 bytes, little endian, of the MD5 of the key libmemcached hashes for the first point of a server
+	tests := []struct {
+		name    string
+		address string
+		want    uint32
+	}{
+		{name: "default port is left out", address: "10.0.0.1:11211", want: 563378236},
+		{name: "another server", address: "10.0.0.2:11211", want: 2732803642},
+		{name: "pod hostname", address: "10-0-0-1.cache.default.svc:11211", want: 97681434},
+		{name: "other port is kept", address: "127.0.0.1:11212", want: 647876633},
+		{name: "no port", address: "10.0.0.1", want: 563378236},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ketamaPoint(tt.address); got != tt.want {
+				t.Errorf("ketamaPoint() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKetamaOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		servers []string
+		want    []string
+	}{
+		{
+			name:    "ordered by first point",
+			servers: []string{"127.0.0.1:11212", "10.0.0.2:11211", "10.0.0.1:11211"},
+			want:    []string{"10.0.0.1:11211", "127.0.0.1:11212", "10.0.0.2:11211"},
+		},
+		{
+			name:    "a new server does not reorder the others",
+			servers: []string{"10.0.0.2:11211", "10.0.0.1:11211", "10-0-0-1.cache.default.svc:11211"},
+			want:    []string{"10-0-0-1.cache.default.svc:11211", "10.0.0.1:11211", "10.0.0.2:11211"},
+		},
+		{
+			name: "empty",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ketamaOrder(tt.servers); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ketamaOrder() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,280 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cachev1alpha1 "github.com/example-inc/memcached-operator/api/v1alpha1"
+)
+
+const (
+	// defaultMemcachedMemoryMB is used when a Memcached does not set spec.memoryMB
+	defaultMemcachedMemoryMB = 64
+	// memcachedMemoryOverheadMB is the memory a memcached process needs on top of its item memory,
+	// for connections and the hash table
+	memcachedMemoryOverheadMB = 32
+
+	defaultMinSizingMemoryMB              = 64
+	defaultMaxSizingMemoryMB              = 4096
+	defaultSizingTargetUtilizationPercent = 80
+	defaultMinApplyInterval               = time.Hour
+
+	// memoryStepMB rounds the recommended memory up, so it doesn't change on every small fluctuation
+	memoryStepMB = 16
+	// evictionGrowthFactor is how much larger than the pool the working set is assumed to be while the pool evicts
+	evictionGrowthFactor = 1.25
+	// minApplyChangePercent is how much the recommended memory or replicas must differ from the applied ones to be
+	// rolled out
+	minApplyChangePercent = 20
+)
+
+// Annotations of the memcached deployment holding the sizing applied in Apply mode. They are updated together with
+// the pods, so the status can't fall behind what is rolled out
+const (
+	appliedMemoryAnnotation   = "cache.example.com/applied-memory-mb"
+	appliedReplicasAnnotation = "cache.example.com/applied-replicas"
+	lastAppliedTimeAnnotation = "cache.example.com/last-applied-time"
+)
+
+// memcachedMemoryMB returns the memory per pod of a Memcached: the memory last rolled out in Apply mode,
+// or spec.memoryMB
+func memcachedMemoryMB(m *cachev1alpha1.Memcached) int32 {
+	if m.Spec.Sizing != nil && m.Spec.Sizing.Mode == cachev1alpha1.ApplySizingMode &&
+		m.Status.Sizing != nil && m.Status.Sizing.AppliedMemoryMB > 0 {
+		return m.Status.Sizing.AppliedMemoryMB
+	}
+	if m.Spec.MemoryMB > 0 {
+		return m.Spec.MemoryMB
+	}
+	return defaultMemcachedMemoryMB
+}
+
+// restoreAppliedSizing reads the sizing applied in Apply mode from the annotations of the deployment into the status.
+// A deployment without them, e.g. a new one, runs with spec.memoryMB and spec.size.
+func restoreAppliedSizing(m *cachev1alpha1.Memcached, dep *appsv1.Deployment) {
+	if m.Spec.Sizing == nil || m.Spec.Sizing.Mode != cachev1alpha1.ApplySizingMode {
+		return
+	}
+	if m.Status.Sizing == nil {
+		m.Status.Sizing = &cachev1alpha1.MemcachedSizingStatus{}
+	}
+	status := m.Status.Sizing
+	memoryMB, _ := strconv.ParseInt(dep.Annotations[appliedMemoryAnnotation], 10, 32)
+	replicas, _ := strconv.ParseInt(dep.Annotations[appliedReplicasAnnotation], 10, 32)
+	status.AppliedMemoryMB, status.AppliedReplicas = int32(memoryMB), int32(replicas)
+	status.LastAppliedTime = nil
+	if applied, err := time.Parse(time.RFC3339, dep.Annotations[lastAppliedTimeAnnotation]); err == nil {
+		appliedTime := metav1.NewTime(applied)
+		status.LastAppliedTime = &appliedTime
+	}
+}
+
+// setAppliedSizingAnnotations records the sizing applied in Apply mode on the deployment. It returns true if the
+// annotations changed.
+func setAppliedSizingAnnotations(m *cachev1alpha1.Memcached, dep *appsv1.Deployment) bool {
+	status := m.Status.Sizing
+	if m.Spec.Sizing == nil || m.Spec.Sizing.Mode != cachev1alpha1.ApplySizingMode || status == nil || status.LastAppliedTime == nil {
+		return false
+	}
+	annotations := map[string]string{
+		appliedMemoryAnnotation:   strconv.FormatInt(int64(status.AppliedMemoryMB), 10),
+		appliedReplicasAnnotation: strconv.FormatInt(int64(status.AppliedReplicas), 10),
+		lastAppliedTimeAnnotation: status.LastAppliedTime.UTC().Format(time.RFC3339),
+	}
+	changed := false
+	for key, value := range annotations {
+		if dep.Annotations[key] != value {
+			if dep.Annotations == nil {
+				dep.Annotations = map[string]string{}
+			}
+			dep.Annotations[key] = value
+			changed = true
+		}
+	}
+	return changed
+}
+
+// memcachedCommand returns the command of the memcached container
+func memcachedCommand(memoryMB int32) []string {
+	return []string{"memcached", "-m=" + strconv.FormatInt(int64(memoryMB), 10), "-o", "modern", "-v"}
+}
+
+// memcachedResources returns the resources of the memcached container, its item memory plus some overhead
+func memcachedResources(memoryMB int32) corev1.ResourceRequirements {
+	memory := resource.MustParse(strconv.FormatInt(int64(memoryMB+memcachedMemoryOverheadMB), 10) + "Mi")
+	return corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceMemory: memory},
+		Limits:   corev1.ResourceList{corev1.ResourceMemory: memory},
+	}
+}
+
+// sizingSettings is the sizing spec of a Memcached with defaults applied
+type sizingSettings struct {
+	minMemoryMB       int32
+	maxMemoryMB       int32
+	targetUtilization float64
+	minReplicas       int32
+}
+
+func sizingSettingsFor(m *cachev1alpha1.Memcached) sizingSettings {
+	spec := m.Spec.Sizing
+	settings := sizingSettings{
+		minMemoryMB:       defaultMinSizingMemoryMB,
+		maxMemoryMB:       defaultMaxSizingMemoryMB,
+		targetUtilization: defaultSizingTargetUtilizationPercent / 100.0,
+		minReplicas:       1,
+	}
+	if spec.MinMemoryMB > 0 {
+		settings.minMemoryMB = spec.MinMemoryMB
+	}
+	if spec.MaxMemoryMB > 0 {
+		settings.maxMemoryMB = spec.MaxMemoryMB
+	}
+	if settings.maxMemoryMB < settings.minMemoryMB {
+		settings.maxMemoryMB = settings.minMemoryMB
+	}
+	if spec.TargetMemoryUtilizationPercent > 0 {
+		settings.targetUtilization = float64(spec.TargetMemoryUtilizationPercent) / 100
+	}
+	if m.Spec.Autoscaling != nil {
+		settings.minReplicas = memcachedAutoscalingSettingsFor(m).minReplicas
+	}
+	return settings
+}
+
+// recommendSize records the sizing recommendation for a Memcached in its status. In Apply mode, it marks the
+// recommended memory and replicas as applied when either differs enough from the applied ones and the last rollout
+// is long enough ago, and returns the applied number of replicas. It returns 0 when there is nothing to apply.
+func (r *MemcachedReconciler) recommendSize(log logr.Logger, m *cachev1alpha1.Memcached, current int32, usage poolStats, ok bool, now time.Time) int32 {
+	status := m.Status.Sizing
+	if status == nil {
+		status = &cachev1alpha1.MemcachedSizingStatus{}
+		m.Status.Sizing = status
+	}
+	currentMemoryMB := memcachedMemoryMB(m)
+	status.CurrentMemoryMB = currentMemoryMB
+	if !ok {
+		if status.Reason == "" {
+			status.Reason = "Waiting for memcached stats"
+		}
+	} else {
+		memoryMB, replicas, workingSetMB, reason := recommendSizing(sizingSettingsFor(m), usage, current)
+		status.WorkingSetMB = workingSetMB
+		status.RecommendedMemoryMB = memoryMB
+		status.RecommendedReplicas = replicas
+		status.Reason = reason
+	}
+
+	if m.Spec.Sizing.Mode != cachev1alpha1.ApplySizingMode || status.RecommendedMemoryMB == 0 {
+		return 0
+	}
+	appliedReplicas := status.AppliedReplicas
+	if appliedReplicas == 0 {
+		appliedReplicas = current
+	}
+	change := math.Max(changePercent(status.RecommendedMemoryMB, currentMemoryMB), changePercent(status.RecommendedReplicas, appliedReplicas))
+	interval := defaultMinApplyInterval
+	if m.Spec.Sizing.MinApplyIntervalMinutes > 0 {
+		interval = time.Duration(m.Spec.Sizing.MinApplyIntervalMinutes) * time.Minute
+	}
+	if change >= minApplyChangePercent && (status.LastAppliedTime == nil || now.Sub(status.LastAppliedTime.Time) >= interval) {
+		log.Info("Applying sizing recommendation", "fromMemoryMB", currentMemoryMB, "toMemoryMB", status.RecommendedMemoryMB,
+			"fromReplicas", appliedReplicas, "toReplicas", status.RecommendedReplicas, "reason", status.Reason)
+		appliedTime := metav1.NewTime(now)
+		status.AppliedMemoryMB = status.RecommendedMemoryMB
+		status.AppliedReplicas = status.RecommendedReplicas
+		status.LastAppliedTime = &appliedTime
+	}
+	return status.AppliedReplicas
+}
+
+// changePercent returns how much a value differs from the current one, in percent of the current one
+func changePercent(value, current int32) float64 {
+	if current == 0 {
+		return 0
+	}
+	return math.Abs(float64(value-current)) / float64(current) * 100
+}
+
+// recommendSizing computes the memory per pod and the number of replicas that hold the working set of a pool
+// at the target utilization. It keeps the current number of replicas, unless the memory per pod would fall
+// outside of the allowed range. The working set is measured from the slab usage, and is assumed to be larger
+// than the whole pool while the pool evicts.
+func recommendSizing(s sizingSettings, usage poolStats, current int32) (int32, int32, int64, string) {
+	const mb = 1024 * 1024
+	workingSet := usage.SlabBytes
+	if workingSet == 0 {
+		workingSet = usage.Bytes
+	}
+	workingSetMB := int64(math.Ceil(float64(workingSet) / mb))
+
+	demand := float64(workingSet)
+	reason := "The working set of " + strconv.FormatInt(workingSetMB, 10) + "MB"
+	if usage.EvictionsPerSecond > 0 {
+		if grown := float64(usage.LimitBytes) * evictionGrowthFactor; grown > demand {
+			demand = grown
+			reason = "The pool evicts " + strconv.FormatFloat(usage.EvictionsPerSecond, 'f', 2, 64) + " items/s, so its working set of more than " +
+				strconv.FormatInt(int64(usage.LimitBytes/mb), 10) + "MB"
+		}
+	}
+	requiredMB := demand / mb / s.targetUtilization
+	reason += " takes " + strconv.FormatInt(int64(s.targetUtilization*100), 10) + "% of the recommended pool"
+
+	replicas := current
+	if replicas < s.minReplicas {
+		replicas = s.minReplicas
+	}
+	memoryMB := roundUpMemory(requiredMB / float64(replicas))
+	switch {
+	case memoryMB > s.maxMemoryMB:
+		// Bigger pods are not allowed, add replicas instead
+		replicas = int32(math.Ceil(requiredMB / float64(s.maxMemoryMB)))
+		memoryMB = roundUpMemory(requiredMB / float64(replicas))
+		reason += ", pods are limited to " + strconv.FormatInt(int64(s.maxMemoryMB), 10) + "MB"
+	case memoryMB < s.minMemoryMB:
+		// Smaller pods are not allowed, remove replicas instead
+		fewer := int32(math.Ceil(requiredMB / float64(s.minMemoryMB)))
+		if fewer < s.minReplicas {
+			fewer = s.minReplicas
+		}
+		if fewer < replicas {
+			replicas = fewer
+			reason += ", pods need at least " + strconv.FormatInt(int64(s.minMemoryMB), 10) + "MB"
+		}
+		memoryMB = s.minMemoryMB
+	}
+	if memoryMB > s.maxMemoryMB {
+		memoryMB = s.maxMemoryMB
+	}
+	return memoryMB, replicas, workingSetMB, reason
+}
+
+// roundUpMemory rounds a memory in MB up to the next memoryStepMB
+func roundUpMemory(memoryMB float64) int32 {
+	steps := int32(math.Ceil(memoryMB / memoryStepMB))
+	if steps < 1 {
+		steps = 1
+	}
+	return steps * memoryStepMB
+}
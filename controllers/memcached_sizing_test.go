@@ -0,0 +1,221 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	cachev1alpha1 "github.com/example-inc/memcached-operator/api/v1alpha1"
+)
+
+func TestRecommendSizing(t *testing.T) {
+	const mb = 1024 * 1024
+	defaults := sizingSettings{minMemoryMB: 64, maxMemoryMB: 4096, targetUtilization: 0.8, minReplicas: 1}
+	withMinReplicas := defaults
+	withMinReplicas.minReplicas = 3
+
+	tests := []struct {
+		name             string
+		settings         sizingSettings
+		usage            poolStats
+		current          int32
+		wantMemoryMB     int32
+		wantReplicas     int32
+		wantWorkingSetMB int64
+	}{
+		{
+			name:             "rounded up to the memory step",
+			settings:         defaults,
+			usage:            poolStats{SlabBytes: 100 * mb, Bytes: 90 * mb},
+			current:          1,
+			wantMemoryMB:     128,
+			wantReplicas:     1,
+			wantWorkingSetMB: 100,
+		},
+		{
+			name:             "item bytes without slab stats",
+			settings:         defaults,
+			usage:            poolStats{Bytes: 60 * mb},
+			current:          1,
+			wantMemoryMB:     80,
+			wantReplicas:     1,
+			wantWorkingSetMB: 60,
+		},
+		{
+			name:             "memory is split over the current replicas",
+			settings:         defaults,
+			usage:            poolStats{SlabBytes: 800 * mb},
+			current:          4,
+			wantMemoryMB:     256,
+			wantReplicas:     4,
+			wantWorkingSetMB: 800,
+		},
+		{
+			name:             "evicting pool grows beyond its limit",
+			settings:         defaults,
+			usage:            poolStats{SlabBytes: 200 * mb, LimitBytes: 256 * mb, EvictionsPerSecond: 1},
+			current:          1,
+			wantMemoryMB:     400,
+			wantReplicas:     1,
+			wantWorkingSetMB: 200,
+		},
+		{
+			name:             "maximum memory adds replicas",
+			settings:         defaults,
+			usage:            poolStats{SlabBytes: 8000 * mb},
+			current:          1,
+			wantMemoryMB:     3344,
+			wantReplicas:     3,
+			wantWorkingSetMB: 8000,
+		},
+		{
+			name:             "minimum memory removes replicas",
+			settings:         defaults,
+			usage:            poolStats{SlabBytes: 80 * mb},
+			current:          4,
+			wantMemoryMB:     64,
+			wantReplicas:     2,
+			wantWorkingSetMB: 80,
+		},
+		{
+			name:             "minimum memory keeps the minimum replicas",
+			settings:         withMinReplicas,
+			usage:            poolStats{SlabBytes: 80 * mb},
+			current:          4,
+			wantMemoryMB:     64,
+			wantReplicas:     3,
+			wantWorkingSetMB: 80,
+		},
+		{
+			name:             "below the minimum replicas",
+			settings:         withMinReplicas,
+			usage:            poolStats{SlabBytes: 240 * mb},
+			current:          1,
+			wantMemoryMB:     112,
+			wantReplicas:     3,
+			wantWorkingSetMB: 240,
+		},
+		{
+			name:             "empty pool",
+			settings:         defaults,
+			usage:            poolStats{},
+			current:          2,
+			wantMemoryMB:     64,
+			wantReplicas:     1,
+			wantWorkingSetMB: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			memoryMB, replicas, workingSetMB, reason := recommendSizing(tt.settings, tt.usage, tt.current)
+			if memoryMB != tt.wantMemoryMB || replicas != tt.wantReplicas || workingSetMB != tt.wantWorkingSetMB {
+				t.Errorf("recommendSizing() = %v, %v, %v, want %v, %v, %v", memoryMB, replicas, workingSetMB,
+					tt.wantMemoryMB, tt.wantReplicas, tt.wantWorkingSetMB)
+			}
+			if reason == "" {
+				t.Errorf("recommendSizing() reason is empty")
+			}
+		})
+	}
+}
+
+func TestRecommendSizeApply(t *testing.T) {
+	const mb = 1024 * 1024
+	now := time.Date(2020, 6, 8, 12, 0, 0, 0, time.UTC)
+	applied := func(ago time.Duration, memoryMB, replicas int32) *cachev1alpha1.MemcachedSizingStatus {
+		appliedTime := metav1.NewTime(now.Add(-ago))
+		return &cachev1alpha1.MemcachedSizingStatus{AppliedMemoryMB: memoryMB, AppliedReplicas: replicas, LastAppliedTime: &appliedTime}
+	}
+
+	tests := []struct {
+		name    string
+		mode    cachev1alpha1.SizingMode
+		status  *cachev1alpha1.MemcachedSizingStatus
+		usage   poolStats
+		ok      bool
+		current int32
+		want    int32
+	}{
+		{
+			// 288MB take 360MB at 80%, three pods of at most 128MB
+			name:    "replicas change enough",
+			mode:    cachev1alpha1.ApplySizingMode,
+			usage:   poolStats{SlabBytes: 288 * mb},
+			ok:      true,
+			current: 2,
+			want:    3,
+		},
+		{
+			// 1056MB take 1320MB at 80%, eleven pods of at most 128MB
+			name:    "replicas change too little",
+			mode:    cachev1alpha1.ApplySizingMode,
+			usage:   poolStats{SlabBytes: 1056 * mb},
+			ok:      true,
+			current: 10,
+			want:    0,
+		},
+		{
+			name:    "last rollout too recent",
+			mode:    cachev1alpha1.ApplySizingMode,
+			status:  applied(30*time.Minute, 128, 2),
+			usage:   poolStats{SlabBytes: 288 * mb},
+			ok:      true,
+			current: 2,
+			want:    2,
+		},
+		{
+			name:    "last rollout long enough ago",
+			mode:    cachev1alpha1.ApplySizingMode,
+			status:  applied(2*time.Hour, 128, 2),
+			usage:   poolStats{SlabBytes: 288 * mb},
+			ok:      true,
+			current: 2,
+			want:    3,
+		},
+		{
+			name:    "recommend mode",
+			mode:    cachev1alpha1.RecommendSizingMode,
+			usage:   poolStats{SlabBytes: 288 * mb},
+			ok:      true,
+			current: 2,
+			want:    0,
+		},
+		{
+			name:    "no stats yet",
+			mode:    cachev1alpha1.ApplySizingMode,
+			current: 2,
+			want:    0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &MemcachedReconciler{Log: ctrl.Log.WithName("test")}
+			m := &cachev1alpha1.Memcached{
+				Spec: cachev1alpha1.MemcachedSpec{
+					MemoryMB: 128,
+					Sizing:   &cachev1alpha1.MemcachedSizingSpec{Mode: tt.mode, MaxMemoryMB: 128},
+				},
+				Status: cachev1alpha1.MemcachedStatus{Sizing: tt.status},
+			}
+			if got := r.recommendSize(r.Log, m, tt.current, tt.usage, tt.ok, now); got != tt.want {
+				t.Errorf("recommendSize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
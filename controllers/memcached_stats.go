@@ -0,0 +1,306 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// memcachedPort is the port memcached listens on
+	memcachedPort = int32(11211)
+	// memcachedStatsTimeout bounds a single stats request to a memcached pod
+	memcachedStatsTimeout = 2 * time.Second
+	// memcachedStatsInterval is how often the stats of an autoscaled or sized Memcached are collected
+	memcachedStatsInterval = 30 * time.Second
+	// minStatsRateWindow is the shortest time between two samples rates are computed from
+	minStatsRateWindow = 10 * time.Second
+)
+
+// Opcodes and status of the memcached binary protocol, which is the only one memcached speaks with SASL enabled
+const (
+	binaryRequestMagic  = byte(0x80)
+	binaryResponseMagic = byte(0x81)
+	binaryStatOpcode    = byte(0x10)
+	binaryAuthOpcode    = byte(0x21)
+	binaryHeaderLength  = 24
+	binaryStatusOK      = uint16(0)
+)
+
+// memcachedAccess is how the operator connects to the pods of a Memcached
+type memcachedAccess struct {
+	// Credentials are only set when the Memcached requires SASL authentication
+	Credentials *saslCredentials
+	// TLS is only set when the Memcached serves TLS
+	TLS *tls.Config
+}
+
+// memcachedStats sends a stats command, e.g. "stats" or "stats slabs", to the memcached server at address,
+// and returns the STAT lines of the response by name. With credentials, the stats are requested over the
+// binary protocol after authenticating with SASL
+func memcachedStats(address, command string, access memcachedAccess) (map[string]string, error) {
+	dialer := &net.Dialer{Timeout: memcachedStatsTimeout}
+	var conn net.Conn
+	var err error
+	if access.TLS != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, access.TLS)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(memcachedStatsTimeout))
+	if access.Credentials != nil {
+		return binaryStats(conn, strings.TrimSpace(strings.TrimPrefix(command, "stats")), access.Credentials)
+	}
+
+	if _, err := conn.Write([]byte(command + "\r\n")); err != nil {
+		return nil, err
+	}
+	stats := map[string]string{}
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		switch {
+		case line == "END":
+			return stats, nil
+		case strings.HasPrefix(line, "STAT "):
+			fields := strings.SplitN(line, " ", 3)
+			if len(fields) == 3 {
+				stats[fields[1]] = fields[2]
+			}
+		case strings.HasSuffix(strings.SplitN(line, " ", 2)[0], "ERROR"):
+			return nil, fmt.Errorf("%s failed: %s", command, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("%s: connection closed before END", command)
+}
+
+// binaryStats authenticates with SASL PLAIN and requests a group of stats, e.g. "" or "slabs", over the binary protocol
+func binaryStats(conn net.Conn, group string, creds *saslCredentials) (map[string]string, error) {
+	reader := bufio.NewReader(conn)
+	auth := "\x00" + creds.Username + "\x00" + creds.Password
+	if err := writeBinaryRequest(conn, binaryAuthOpcode, []byte("PLAIN"), []byte(auth)); err != nil {
+		return nil, err
+	}
+	status, _, value, err := readBinaryResponse(reader)
+	if err != nil {
+		return nil, err
+	}
+	if status != binaryStatusOK {
+		return nil, fmt.Errorf("SASL authentication failed with status 0x%x: %s", status, value)
+	}
+
+	if err := writeBinaryRequest(conn, binaryStatOpcode, []byte(group), nil); err != nil {
+		return nil, err
+	}
+	stats := map[string]string{}
+	for {
+		status, key, value, err := readBinaryResponse(reader)
+		if err != nil {
+			return nil, err
+		}
+		if status != binaryStatusOK {
+			return nil, fmt.Errorf("stats %s failed with status 0x%x: %s", group, status, value)
+		}
+		// The stats end with an empty key
+		if len(key) == 0 {
+			return stats, nil
+		}
+		stats[string(key)] = string(value)
+	}
+}
+
+// writeBinaryRequest writes a binary protocol request without extras
+func writeBinaryRequest(w io.Writer, opcode byte, key, value []byte) error {
+	packet := make([]byte, binaryHeaderLength, binaryHeaderLength+len(key)+len(value))
+	packet[0] = binaryRequestMagic
+	packet[1] = opcode
+	binary.BigEndian.PutUint16(packet[2:4], uint16(len(key)))
+	binary.BigEndian.PutUint32(packet[8:12], uint32(len(key)+len(value)))
+	packet = append(append(packet, key...), value...)
+	_, err := w.Write(packet)
+	return err
+}
+
+// readBinaryResponse reads a binary protocol response, and returns its status, key and value
+func readBinaryResponse(r io.Reader) (uint16, []byte, []byte, error) {
+	header := make([]byte, binaryHeaderLength)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, nil, err
+	}
+	if header[0] != binaryResponseMagic {
+		return 0, nil, nil, fmt.Errorf("unexpected magic 0x%x in binary protocol response", header[0])
+	}
+	keyLength := int(binary.BigEndian.Uint16(header[2:4]))
+	extrasLength := int(header[4])
+	status := binary.BigEndian.Uint16(header[6:8])
+	body := make([]byte, binary.BigEndian.Uint32(header[8:12]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, nil, err
+	}
+	if extrasLength+keyLength > len(body) {
+		return 0, nil, nil, fmt.Errorf("malformed binary protocol response")
+	}
+	return status, body[extrasLength : extrasLength+keyLength], body[extrasLength+keyLength:], nil
+}
+
+// podStats are the counters and gauges of a single memcached pod the autoscaler looks at
+type podStats struct {
+	Evictions     uint64
+	GetHits       uint64
+	GetMisses     uint64
+	Bytes         uint64
+	LimitMaxbytes uint64
+	// SlabBytes is the memory taken by the chunks in use, which is more than Bytes because items are
+	// stored in fixed size chunks
+	SlabBytes uint64
+}
+
+// podStatsFrom picks the fields of podStats out of the response to a stats command
+func podStatsFrom(stats map[string]string) podStats {
+	value := func(name string) uint64 {
+		v, _ := strconv.ParseUint(stats[name], 10, 64)
+		return v
+	}
+	return podStats{
+		Evictions:     value("evictions"),
+		GetHits:       value("get_hits"),
+		GetMisses:     value("get_misses"),
+		Bytes:         value("bytes"),
+		LimitMaxbytes: value("limit_maxbytes"),
+	}
+}
+
+// slabBytes sums up the memory of the used chunks of every slab class in the response to "stats slabs"
+func slabBytes(stats map[string]string) uint64 {
+	var total uint64
+	for name, value := range stats {
+		// Per slab class stats are named <class>:<stat>
+		parts := strings.SplitN(name, ":", 2)
+		if len(parts) != 2 || parts[1] != "used_chunks" {
+			continue
+		}
+		used, _ := strconv.ParseUint(value, 10, 64)
+		chunkSize, _ := strconv.ParseUint(stats[parts[0]+":chunk_size"], 10, 64)
+		total += used * chunkSize
+	}
+	return total
+}
+
+// statsSample are the stats of every pod of a Memcached at a point in time, keyed by pod name
+type statsSample struct {
+	Time time.Time
+	Pods map[string]podStats
+}
+
+// poolStats is the usage of a memcached pool between two samples
+type poolStats struct {
+	EvictionsPerSecond float64
+	// MemoryUtilization is between 0 and 1
+	MemoryUtilization float64
+	// HitRatio is between 0 and 1, and only valid if HasGets is set
+	HitRatio float64
+	HasGets  bool
+	// Bytes, LimitBytes and SlabBytes are the totals of the pods in the latest sample
+	Bytes      uint64
+	LimitBytes uint64
+	SlabBytes  uint64
+	// Pods is the number of pods in the latest sample
+	Pods int32
+}
+
+// collectStats queries the stats of every running memcached pod. The pods are queried concurrently, so a pod that
+// hangs delays the sample by the stats timeout at most. Pods that don't answer are logged and left out.
+func collectStats(log logr.Logger, pods []corev1.Pod, access memcachedAccess, now time.Time) statsSample {
+	sample := statsSample{Time: now, Pods: map[string]podStats{}}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := range pods {
+		pod := &pods[i]
+		if pod.DeletionTimestamp != nil || pod.Status.Phase != corev1.PodRunning || pod.Status.PodIP == "" {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			address := net.JoinHostPort(pod.Status.PodIP, strconv.FormatInt(int64(memcachedPort), 10))
+			stats, err := memcachedStats(address, "stats", access)
+			if err != nil {
+				log.Error(err, "Failed to get memcached stats", "pod", pod.Name)
+				return
+			}
+			podStats := podStatsFrom(stats)
+			if slabs, err := memcachedStats(address, "stats slabs", access); err != nil {
+				log.Error(err, "Failed to get memcached slab stats", "pod", pod.Name)
+			} else {
+				podStats.SlabBytes = slabBytes(slabs)
+			}
+			mu.Lock()
+			sample.Pods[pod.Name] = podStats
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return sample
+}
+
+// usageBetween computes the usage of a pool from two samples. Rates only count the pods that are in both
+// samples, and skip pods whose counters went down because memcached restarted.
+func usageBetween(previous, current statsSample) poolStats {
+	usage := poolStats{Pods: int32(len(current.Pods))}
+	var evictions, hits, misses uint64
+	for name, stats := range current.Pods {
+		usage.Bytes += stats.Bytes
+		usage.LimitBytes += stats.LimitMaxbytes
+		usage.SlabBytes += stats.SlabBytes
+
+		before, ok := previous.Pods[name]
+		if !ok || stats.Evictions < before.Evictions || stats.GetHits < before.GetHits || stats.GetMisses < before.GetMisses {
+			continue
+		}
+		evictions += stats.Evictions - before.Evictions
+		hits += stats.GetHits - before.GetHits
+		misses += stats.GetMisses - before.GetMisses
+	}
+	if usage.LimitBytes > 0 {
+		usage.MemoryUtilization = float64(usage.Bytes) / float64(usage.LimitBytes)
+	}
+	if elapsed := current.Time.Sub(previous.Time).Seconds(); elapsed > 0 {
+		usage.EvictionsPerSecond = float64(evictions) / elapsed
+	}
+	if hits+misses > 0 {
+		usage.HitRatio = float64(hits) / float64(hits+misses)
+		usage.HasGets = true
+	}
+	return usage
+}
@@ -0,0 +1,412 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	cachev1alpha1 "github.com/example-inc/memcached-operator/api/v1alpha1"
+)
+
+const (
+	// memcachedTLSImage is the memcached image used with TLS, which is only supported from memcached 1.6 on
+	memcachedTLSImage = "memcached:1.6.9-alpine"
+
+	tlsVolumeName = "tls"
+	tlsMountPath  = "/etc/memcached/tls"
+	// tlsCertHashAnnotation is a hash of the serving certificate on the pod template, so the pods roll to a renewed one
+	tlsCertHashAnnotation = "cache.example.com/tls-cert-hash"
+
+	defaultCertificateDuration    = 90 * 24 * time.Hour
+	defaultCertificateRenewBefore = 30 * 24 * time.Hour
+	// caCertificateDuration is how long the CA the operator issues the certificates of a Memcached from is valid
+	caCertificateDuration = 10 * 365 * 24 * time.Hour
+
+	// operatorIssuer is the issuer reported in the status for the certificates the operator issues itself
+	operatorIssuer = "operator"
+)
+
+// certificateGVK is the cert-manager Certificate, which is handled as unstructured so the operator does not
+// depend on cert-manager
+var certificateGVK = schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1alpha2", Kind: "Certificate"}
+
+// memcachedTLS is the certificate a Memcached serves TLS with
+type memcachedTLS struct {
+	// certHash changes whenever the certificate is renewed
+	certHash string
+	// clientConfig is what the operator connects to the memcached pods with
+	clientConfig *tls.Config
+	// renewAt is when the certificate must be renewed. Zero when cert-manager renews it
+	renewAt time.Time
+}
+
+// tlsSecretName is the name of the Secret holding the serving certificate of a Memcached
+func tlsSecretName(name string) string {
+	return name + "-tls"
+}
+
+// caSecretName is the name of the Secret holding the CA the operator issues the certificates of a Memcached from
+func caSecretName(name string) string {
+	return name + "-ca"
+}
+
+// certificateDNSNames are the names the certificate of a Memcached is valid for: its headless Service, and the
+// hostnames of its pods published in the server list, see memcachedHostname
+func certificateDNSNames(m *cachev1alpha1.Memcached) []string {
+	host := m.Name + "." + m.Namespace + ".svc"
+	return []string{host, "*." + host}
+}
+
+// reconcileTLS makes sure the certificate of a Memcached is issued and renewed, by cert-manager or by the operator.
+// It returns nil when TLS is disabled, or the certificate is not issued yet.
+func (r *MemcachedReconciler) reconcileTLS(ctx context.Context, log logr.Logger, m *cachev1alpha1.Memcached, now time.Time) (*memcachedTLS, error) {
+	if m.Spec.TLS == nil {
+		m.Status.TLS = nil
+		if err := r.deleteCertificate(ctx, log, m); err != nil {
+			return nil, err
+		}
+		for _, name := range []string{tlsSecretName(m.Name), caSecretName(m.Name)} {
+			if err := r.deleteOwnedSecret(ctx, log, m, name); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	}
+
+	duration, renewBefore := defaultCertificateDuration, defaultCertificateRenewBefore
+	if days := m.Spec.TLS.DurationDays; days > 0 {
+		duration = time.Duration(days) * 24 * time.Hour
+	}
+	if days := m.Spec.TLS.RenewBeforeDays; days > 0 {
+		renewBefore = time.Duration(days) * 24 * time.Hour
+	}
+	if renewBefore >= duration {
+		renewBefore = duration / 3
+	}
+
+	status := &cachev1alpha1.MemcachedTLSStatus{Issuer: operatorIssuer}
+	m.Status.TLS = status
+	var secret *corev1.Secret
+	var err error
+	if ref := m.Spec.TLS.IssuerRef; ref != nil {
+		status.Issuer = ref.Name
+		secret, err = r.reconcileCertificate(ctx, log, m, duration, renewBefore)
+		if err != nil && meta.IsNoMatchError(err) {
+			status.Reason = "cert-manager is not installed"
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if secret == nil {
+			status.Reason = "Waiting for cert-manager to issue the certificate"
+			return nil, nil
+		}
+	} else {
+		if err := r.deleteCertificate(ctx, log, m); err != nil {
+			return nil, err
+		}
+		secret, err = r.issueCertificate(ctx, log, m, duration, renewBefore, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cert, err := parseCertificate(secret.Data[corev1.TLSCertKey])
+	if err != nil {
+		log.Error(err, "Failed to parse the certificate", "Secret.Name", secret.Name)
+		status.Reason = "The certificate in Secret " + secret.Name + " is invalid"
+		return nil, nil
+	}
+	notAfter := metav1.NewTime(cert.NotAfter)
+	status.NotAfter = &notAfter
+
+	clientConfig := &tls.Config{ServerName: certificateDNSNames(m)[0], MinVersion: tls.VersionTLS12}
+	if ca := secret.Data["ca.crt"]; len(ca) > 0 {
+		// Without a CA in the Secret, the certificate is verified against the system roots
+		clientConfig.RootCAs = x509.NewCertPool()
+		clientConfig.RootCAs.AppendCertsFromPEM(ca)
+	}
+	sum := sha256.Sum256(secret.Data[corev1.TLSCertKey])
+	result := &memcachedTLS{certHash: hex.EncodeToString(sum[:]), clientConfig: clientConfig}
+	if m.Spec.TLS.IssuerRef == nil {
+		result.renewAt = cert.NotAfter.Add(-renewBefore)
+	}
+	return result, nil
+}
+
+// reconcileCertificate creates or updates the cert-manager Certificate of a Memcached, and returns the Secret
+// cert-manager stores the certificate in, or nil when it is not issued yet
+func (r *MemcachedReconciler) reconcileCertificate(ctx context.Context, log logr.Logger, m *cachev1alpha1.Memcached, duration, renewBefore time.Duration) (*corev1.Secret, error) {
+	ref := m.Spec.TLS.IssuerRef
+	issuerRef := map[string]interface{}{"name": ref.Name, "kind": "Issuer", "group": "cert-manager.io"}
+	if ref.Kind != "" {
+		issuerRef["kind"] = ref.Kind
+	}
+	if ref.Group != "" {
+		issuerRef["group"] = ref.Group
+	}
+	var dnsNames []interface{}
+	for _, name := range certificateDNSNames(m) {
+		dnsNames = append(dnsNames, name)
+	}
+	spec := map[string]interface{}{
+		"secretName":  tlsSecretName(m.Name),
+		"dnsNames":    dnsNames,
+		"duration":    duration.String(),
+		"renewBefore": renewBefore.String(),
+		"issuerRef":   issuerRef,
+	}
+
+	certificate := &unstructured.Unstructured{}
+	certificate.SetGroupVersionKind(certificateGVK)
+	err := r.Get(ctx, types.NamespacedName{Name: m.Name, Namespace: m.Namespace}, certificate)
+	if err != nil && errors.IsNotFound(err) {
+		certificate = &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+		certificate.SetGroupVersionKind(certificateGVK)
+		certificate.SetName(m.Name)
+		certificate.SetNamespace(m.Namespace)
+		certificate.SetLabels(labelsForMemcached(m.Name))
+		// Set Memcached instance as the owner and controller
+		ctrl.SetControllerReference(m, certificate, r.Scheme)
+		log.Info("Creating a new Certificate", "Certificate.Namespace", m.Namespace, "Certificate.Name", m.Name)
+		if err := r.Create(ctx, certificate); err != nil {
+			log.Error(err, "Failed to create new Certificate", "Certificate.Namespace", m.Namespace, "Certificate.Name", m.Name)
+			return nil, err
+		}
+		return nil, nil
+	} else if err != nil {
+		if !meta.IsNoMatchError(err) {
+			log.Error(err, "Failed to get Certificate")
+		}
+		return nil, err
+	}
+
+	// cert-manager defaults parts of the spec, so only compare what the operator sets
+	if !equality.Semantic.DeepDerivative(spec, certificate.Object["spec"]) {
+		certificate.Object["spec"] = spec
+		log.Info("Updating Certificate", "Certificate.Namespace", certificate.GetNamespace(), "Certificate.Name", certificate.GetName())
+		if err := r.Update(ctx, certificate); err != nil {
+			log.Error(err, "Failed to update Certificate", "Certificate.Namespace", certificate.GetNamespace(), "Certificate.Name", certificate.GetName())
+			return nil, err
+		}
+	}
+
+	secret := &corev1.Secret{}
+	err = r.Get(ctx, types.NamespacedName{Name: tlsSecretName(m.Name), Namespace: m.Namespace}, secret)
+	if err != nil && errors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		log.Error(err, "Failed to get Secret")
+		return nil, err
+	}
+	if len(secret.Data[corev1.TLSCertKey]) == 0 || len(secret.Data[corev1.TLSPrivateKeyKey]) == 0 {
+		return nil, nil
+	}
+	return secret, nil
+}
+
+// deleteCertificate deletes the cert-manager Certificate of a Memcached, if the operator created one
+func (r *MemcachedReconciler) deleteCertificate(ctx context.Context, log logr.Logger, m *cachev1alpha1.Memcached) error {
+	certificate := &unstructured.Unstructured{}
+	certificate.SetGroupVersionKind(certificateGVK)
+	err := r.Get(ctx, types.NamespacedName{Name: m.Name, Namespace: m.Namespace}, certificate)
+	if err != nil && (errors.IsNotFound(err) || meta.IsNoMatchError(err)) {
+		return nil
+	} else if err != nil {
+		log.Error(err, "Failed to get Certificate")
+		return err
+	}
+	// Only delete a certificate we created ourselves
+	if !metav1.IsControlledBy(certificate, m) {
+		return nil
+	}
+	log.Info("Deleting Certificate", "Certificate.Namespace", certificate.GetNamespace(), "Certificate.Name", certificate.GetName())
+	if err := r.Delete(ctx, certificate); err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to delete Certificate", "Certificate.Namespace", certificate.GetNamespace(), "Certificate.Name", certificate.GetName())
+		return err
+	}
+	return nil
+}
+
+// issueCertificate makes sure the operator's CA of a Memcached exists, and that the serving certificate is issued
+// by it, valid for the names of the Memcached, and not due for renewal. It returns the Secret of the certificate.
+func (r *MemcachedReconciler) issueCertificate(ctx context.Context, log logr.Logger, m *cachev1alpha1.Memcached, duration, renewBefore time.Duration, now time.Time) (*corev1.Secret, error) {
+	caSecret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: caSecretName(m.Name), Namespace: m.Namespace}, caSecret)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to get Secret")
+		return nil, err
+	}
+	ca, caKey, err := parseKeyPair(caSecret.Data)
+	// The CA must outlive every certificate it issues
+	if err != nil || now.Add(duration).After(ca.NotAfter) {
+		log.Info("Issuing a new CA", "Secret.Name", caSecretName(m.Name))
+		caPEM, caKeyPEM, err := newCertificate(pkix.Name{CommonName: m.Name + "." + m.Namespace + " memcached CA"}, nil, caCertificateDuration, now, nil, nil)
+		if err != nil {
+			log.Error(err, "Failed to issue CA")
+			return nil, err
+		}
+		caSecret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: caSecretName(m.Name), Namespace: m.Namespace, Labels: labelsForMemcached(m.Name)},
+			Type:       corev1.SecretTypeTLS,
+			Data:       map[string][]byte{corev1.TLSCertKey: caPEM, corev1.TLSPrivateKeyKey: caKeyPEM},
+		}
+		if err := r.reconcileOwnedSecret(ctx, log, m, caSecret); err != nil {
+			return nil, err
+		}
+		if ca, caKey, err = parseKeyPair(caSecret.Data); err != nil {
+			return nil, err
+		}
+	}
+	caPEM := caSecret.Data[corev1.TLSCertKey]
+
+	secret := &corev1.Secret{}
+	err = r.Get(ctx, types.NamespacedName{Name: tlsSecretName(m.Name), Namespace: m.Namespace}, secret)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to get Secret")
+		return nil, err
+	}
+	if cert, _, err := parseKeyPair(secret.Data); err == nil && now.Before(cert.NotAfter.Add(-renewBefore)) &&
+		cert.CheckSignatureFrom(ca) == nil && equality.Semantic.DeepEqual(cert.DNSNames, certificateDNSNames(m)) {
+		return secret, nil
+	}
+
+	log.Info("Issuing a new certificate", "Secret.Name", tlsSecretName(m.Name))
+	certPEM, keyPEM, err := newCertificate(pkix.Name{CommonName: certificateDNSNames(m)[0]}, certificateDNSNames(m), duration, now, ca, caKey)
+	if err != nil {
+		log.Error(err, "Failed to issue certificate")
+		return nil, err
+	}
+	secret = &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: tlsSecretName(m.Name), Namespace: m.Namespace, Labels: labelsForMemcached(m.Name)},
+		Type:       corev1.SecretTypeTLS,
+		Data:       map[string][]byte{corev1.TLSCertKey: certPEM, corev1.TLSPrivateKeyKey: keyPEM, "ca.crt": caPEM},
+	}
+	if err := r.reconcileOwnedSecret(ctx, log, m, secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// newCertificate issues a certificate for the given names, signed by the given CA, or a CA certificate when
+// the CA is nil. It returns the PEM encoded certificate and key.
+func newCertificate(subject pkix.Name, dnsNames []string, duration time.Duration, now time.Time, ca *x509.Certificate, caKey *ecdsa.PrivateKey) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      subject,
+		DNSNames:     dnsNames,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(duration),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	parent, signer := template, key
+	if ca == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+		template.ExtKeyUsage = nil
+	} else {
+		parent, signer = ca, caKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+}
+
+// parseCertificate parses the first certificate of a PEM bundle
+func parseCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no PEM encoded certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// parseKeyPair parses the certificate and the key of a Secret issued by the operator
+func parseKeyPair(data map[string][]byte) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	cert, err := parseCertificate(data[corev1.TLSCertKey])
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _ := pem.Decode(data[corev1.TLSPrivateKeyKey])
+	if block == nil {
+		return nil, nil, fmt.Errorf("no PEM encoded key")
+	}
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+// applyTLS enables TLS on the memcached container of a pod template, with the certificate mounted from its Secret
+func applyTLS(m *cachev1alpha1.Memcached, template *corev1.PodTemplateSpec) {
+	// Only readable by the group of the pod, which owns the volume, see applyPodSecurity
+	mode := int32(0440)
+	template.Spec.Volumes = append(template.Spec.Volumes, corev1.Volume{
+		Name: tlsVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: tlsSecretName(m.Name), DefaultMode: &mode},
+		},
+	})
+	container := &template.Spec.Containers[0]
+	container.Image = memcachedTLSImage
+	container.Command = append(container.Command, "-Z", "-o",
+		"ssl_chain_cert="+tlsMountPath+"/"+corev1.TLSCertKey+",ssl_key="+tlsMountPath+"/"+corev1.TLSPrivateKeyKey)
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      tlsVolumeName,
+		MountPath: tlsMountPath,
+		ReadOnly:  true,
+	})
+}
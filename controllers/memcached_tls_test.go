@@ -0,0 +1,165 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cachev1alpha1 "github.com/example-inc/memcached-operator/api/v1alpha1"
+)
+
+func TestNewCertificate(t *testing.T) {
+	now := time.Date(2020, 6, 8, 12, 0, 0, 0, time.UTC)
+	caPEM, caKeyPEM, err := newCertificate(pkix.Name{CommonName: "test CA"}, nil, caCertificateDuration, now, nil, nil)
+	if err != nil {
+		t.Fatalf("newCertificate() CA error = %v", err)
+	}
+	ca, caKey, err := parseKeyPair(map[string][]byte{corev1.TLSCertKey: caPEM, corev1.TLSPrivateKeyKey: caKeyPEM})
+	if err != nil {
+		t.Fatalf("parseKeyPair() CA error = %v", err)
+	}
+	if !ca.IsCA || ca.KeyUsage&x509.KeyUsageCertSign == 0 {
+		t.Errorf("CA IsCA = %v, KeyUsage = %v, want a certificate signing CA", ca.IsCA, ca.KeyUsage)
+	}
+	if want := now.Add(caCertificateDuration); !ca.NotAfter.Equal(want) {
+		t.Errorf("CA NotAfter = %v, want %v", ca.NotAfter, want)
+	}
+
+	m := &cachev1alpha1.Memcached{ObjectMeta: metav1.ObjectMeta{Name: "cache", Namespace: "default"}}
+	dnsNames := certificateDNSNames(m)
+	certPEM, keyPEM, err := newCertificate(pkix.Name{CommonName: dnsNames[0]}, dnsNames, defaultCertificateDuration, now, ca, caKey)
+	if err != nil {
+		t.Fatalf("newCertificate() error = %v", err)
+	}
+	cert, _, err := parseKeyPair(map[string][]byte{corev1.TLSCertKey: certPEM, corev1.TLSPrivateKeyKey: keyPEM})
+	if err != nil {
+		t.Fatalf("parseKeyPair() error = %v", err)
+	}
+	if cert.IsCA {
+		t.Errorf("certificate IsCA = true, want false")
+	}
+	if !reflect.DeepEqual(cert.DNSNames, dnsNames) {
+		t.Errorf("certificate DNSNames = %v, want %v", cert.DNSNames, dnsNames)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	// The operator connects to the Service, and mcrouter and the webservers to the pods by their hostname
+	for _, name := range []string{"cache.default.svc", memcachedHostname(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Labels: map[string]string{"memcached_cr": "cache"}},
+		Status:     corev1.PodStatus{PodIP: "10.0.0.1"},
+	})} {
+		opts := x509.VerifyOptions{DNSName: name, Roots: roots, CurrentTime: now, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}
+		if _, err := cert.Verify(opts); err != nil {
+			t.Errorf("Verify(%q) error = %v", name, err)
+		}
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{DNSName: "cache.other.svc", Roots: roots, CurrentTime: now}); err == nil {
+		t.Errorf("Verify(%q) succeeded, want an error", "cache.other.svc")
+	}
+}
+
+func TestReconcileTLSRenewal(t *testing.T) {
+	issued := time.Date(2020, 6, 8, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	tests := []struct {
+		name        string
+		tls         cachev1alpha1.MemcachedTLSSpec
+		after       time.Duration
+		wantRenewAt time.Time
+		wantRenewed bool
+	}{
+		{
+			name:        "defaults, before the renewal threshold",
+			after:       59 * day,
+			wantRenewAt: issued.Add(60 * day),
+		},
+		{
+			name:        "defaults, at the renewal threshold",
+			after:       60 * day,
+			wantRenewAt: issued.Add(60 * day),
+			wantRenewed: true,
+		},
+		{
+			name:        "custom duration, past the renewal threshold",
+			tls:         cachev1alpha1.MemcachedTLSSpec{DurationDays: 10, RenewBeforeDays: 2},
+			after:       9 * day,
+			wantRenewAt: issued.Add(8 * day),
+			wantRenewed: true,
+		},
+		{
+			name:        "renewing before the whole duration falls back to a third of it",
+			tls:         cachev1alpha1.MemcachedTLSSpec{DurationDays: 3, RenewBeforeDays: 5},
+			after:       time.Hour,
+			wantRenewAt: issued.Add(2 * day),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			_ = clientgoscheme.AddToScheme(scheme)
+			_ = cachev1alpha1.AddToScheme(scheme)
+			r := &MemcachedReconciler{Client: fake.NewFakeClientWithScheme(scheme), Log: ctrl.Log.WithName("test"), Scheme: scheme}
+			tlsSpec := tt.tls
+			m := &cachev1alpha1.Memcached{
+				ObjectMeta: metav1.ObjectMeta{Name: "cache", Namespace: "default", UID: "uid"},
+				Spec:       cachev1alpha1.MemcachedSpec{TLS: &tlsSpec},
+			}
+			ctx := context.Background()
+
+			first, err := r.reconcileTLS(ctx, r.Log, m, issued)
+			if err != nil || first == nil {
+				t.Fatalf("reconcileTLS() = %v, %v, want the certificate", first, err)
+			}
+			if !first.renewAt.Equal(tt.wantRenewAt) {
+				t.Errorf("reconcileTLS() renewAt = %v, want %v", first.renewAt, tt.wantRenewAt)
+			}
+			ca := &corev1.Secret{}
+			if err := r.Get(ctx, types.NamespacedName{Name: caSecretName(m.Name), Namespace: m.Namespace}, ca); err != nil {
+				t.Fatalf("failed to get the CA Secret: %v", err)
+			}
+
+			second, err := r.reconcileTLS(ctx, r.Log, m, issued.Add(tt.after))
+			if err != nil || second == nil {
+				t.Fatalf("reconcileTLS() = %v, %v, want the certificate", second, err)
+			}
+			if renewed := second.certHash != first.certHash; renewed != tt.wantRenewed {
+				t.Errorf("reconcileTLS() renewed = %v, want %v", renewed, tt.wantRenewed)
+			}
+			// The CA outlives the certificates, so the clients keep trusting the renewed one
+			caAfter := &corev1.Secret{}
+			if err := r.Get(ctx, types.NamespacedName{Name: caSecretName(m.Name), Namespace: m.Namespace}, caAfter); err != nil {
+				t.Fatalf("failed to get the CA Secret: %v", err)
+			}
+			if !reflect.DeepEqual(caAfter.Data, ca.Data) {
+				t.Errorf("reconcileTLS() issued a new CA")
+			}
+		})
+	}
+}
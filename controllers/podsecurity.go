@@ -0,0 +1,139 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	cachev1alpha1 "github.com/example-inc/memcached-operator/api/v1alpha1"
+)
+
+const (
+	// memcachedUser is the memcache user of the memcached image. The kubelet can only verify that a numeric user is not root
+	memcachedUser = int64(11211)
+	// webserverUser is nobody
+	webserverUser = int64(65534)
+
+	tmpVolumeName = "tmp"
+)
+
+// applyPodSecurity runs every container of a pod template with the restricted security context, and the overrides
+// of the CR. A read-only root filesystem comes with an emptyDir on /tmp.
+func applyPodSecurity(template *corev1.PodTemplateSpec, spec *cachev1alpha1.PodSecuritySpec, defaultUser int64) {
+	if spec == nil {
+		spec = &cachev1alpha1.PodSecuritySpec{}
+	}
+	user := defaultUser
+	if spec.RunAsUser != nil {
+		user = *spec.RunAsUser
+	}
+	group := user
+	if spec.RunAsGroup != nil {
+		group = *spec.RunAsGroup
+	}
+	nonRoot := boolOr(spec.RunAsNonRoot, true)
+	// The volumes belong to the group too, so secrets can be mounted readable by the group only
+	template.Spec.SecurityContext = &corev1.PodSecurityContext{
+		RunAsUser:    &user,
+		RunAsGroup:   &group,
+		RunAsNonRoot: &nonRoot,
+		FSGroup:      &group,
+	}
+
+	// The seccomp profile is only an annotation in the pod API we build against
+	profile := corev1.SeccompProfileRuntimeDefault
+	if spec.SeccompProfile == cachev1alpha1.UnconfinedSeccompProfile {
+		profile = "unconfined"
+	}
+	annotations := map[string]string{corev1.SeccompPodAnnotationKey: profile}
+	for key, value := range template.Annotations {
+		annotations[key] = value
+	}
+	template.Annotations = annotations
+
+	readOnly := boolOr(spec.ReadOnlyRootFilesystem, true)
+	if readOnly {
+		template.Spec.Volumes = append(template.Spec.Volumes, corev1.Volume{
+			Name:         tmpVolumeName,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		})
+	}
+	for i := range template.Spec.Containers {
+		container := &template.Spec.Containers[i]
+		escalation := boolOr(spec.AllowPrivilegeEscalation, false)
+		readOnlyRootFilesystem := readOnly
+		container.SecurityContext = &corev1.SecurityContext{
+			AllowPrivilegeEscalation: &escalation,
+			ReadOnlyRootFilesystem:   &readOnlyRootFilesystem,
+			Capabilities: &corev1.Capabilities{
+				Drop: []corev1.Capability{"ALL"},
+				Add:  spec.AddCapabilities,
+			},
+		}
+		if readOnly {
+			container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{Name: tmpVolumeName, MountPath: "/tmp"})
+		}
+	}
+}
+
+// restrictedViolations lists how the overrides of a CR weaken its pods below the restricted Pod Security Standard.
+// A writable root filesystem is allowed by the standard.
+func restrictedViolations(spec *cachev1alpha1.PodSecuritySpec) []string {
+	if spec == nil {
+		return nil
+	}
+	var violations []string
+	if spec.RunAsUser != nil && *spec.RunAsUser == 0 {
+		violations = append(violations, "runAsUser is 0")
+	}
+	if !boolOr(spec.RunAsNonRoot, true) {
+		violations = append(violations, "runAsNonRoot is false")
+	}
+	if boolOr(spec.AllowPrivilegeEscalation, false) {
+		violations = append(violations, "allowPrivilegeEscalation is true")
+	}
+	for _, capability := range spec.AddCapabilities {
+		// NET_BIND_SERVICE is the only capability the standard allows to add
+		if capability != "NET_BIND_SERVICE" {
+			violations = append(violations, "capability "+string(capability)+" is added")
+		}
+	}
+	if spec.SeccompProfile == cachev1alpha1.UnconfinedSeccompProfile {
+		violations = append(violations, "seccompProfile is Unconfined")
+	}
+	return violations
+}
+
+// setPodSecurityCondition reports whether the pods of a CR meet the restricted Pod Security Standard
+func setPodSecurityCondition(conditions *[]cachev1alpha1.Condition, spec *cachev1alpha1.PodSecuritySpec, now time.Time) {
+	if violations := restrictedViolations(spec); len(violations) > 0 {
+		setCondition(conditions, cachev1alpha1.PodSecurityRestrictedCondition, corev1.ConditionFalse, "SecurityOverridden",
+			"spec.security weakens the pods below the restricted Pod Security Standard: "+strings.Join(violations, ", "), now)
+		return
+	}
+	setCondition(conditions, cachev1alpha1.PodSecurityRestrictedCondition, corev1.ConditionTrue, "Restricted",
+		"The pods meet the restricted Pod Security Standard", now)
+}
+
+// boolOr returns the value of b, or def when it is unset
+func boolOr(b *bool, def bool) bool {
+	if b == nil {
+		return def
+	}
+	return *b
+}
@@ -0,0 +1,68 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+// syncPodTemplate copies what the operator manages, including the given annotations, from the desired pod template
+// of a single container deployment to the found one. It returns true if anything changed. Annotations set by others,
+// e.g. kubectl rollout restart, are kept.
+func syncPodTemplate(found, desired *corev1.PodTemplateSpec, managedAnnotations []string) bool {
+	changed := false
+	for _, key := range managedAnnotations {
+		value, ok := desired.Annotations[key]
+		current, has := found.Annotations[key]
+		if ok == has && value == current {
+			continue
+		}
+		if ok {
+			if found.Annotations == nil {
+				found.Annotations = map[string]string{}
+			}
+			found.Annotations[key] = value
+		} else {
+			delete(found.Annotations, key)
+		}
+		changed = true
+	}
+
+	container, desiredContainer := &found.Spec.Containers[0], &desired.Spec.Containers[0]
+	if container.Image != desiredContainer.Image ||
+		!equality.Semantic.DeepEqual(container.Command, desiredContainer.Command) ||
+		!equality.Semantic.DeepEqual(container.Resources, desiredContainer.Resources) ||
+		!equality.Semantic.DeepEqual(container.Env, desiredContainer.Env) ||
+		!equality.Semantic.DeepEqual(container.VolumeMounts, desiredContainer.VolumeMounts) ||
+		!equality.Semantic.DeepEqual(container.SecurityContext, desiredContainer.SecurityContext) ||
+		!equality.Semantic.DeepEqual(container.ReadinessProbe, desiredContainer.ReadinessProbe) ||
+		!equality.Semantic.DeepEqual(container.LivenessProbe, desiredContainer.LivenessProbe) ||
+		!equality.Semantic.DeepEqual(found.Spec.Volumes, desired.Spec.Volumes) ||
+		!equality.Semantic.DeepEqual(found.Spec.SecurityContext, desired.Spec.SecurityContext) {
+		container.Image = desiredContainer.Image
+		container.Command = desiredContainer.Command
+		container.Resources = desiredContainer.Resources
+		container.Env = desiredContainer.Env
+		container.VolumeMounts = desiredContainer.VolumeMounts
+		container.SecurityContext = desiredContainer.SecurityContext
+		container.ReadinessProbe = desiredContainer.ReadinessProbe
+		container.LivenessProbe = desiredContainer.LivenessProbe
+		found.Spec.Volumes = desired.Spec.Volumes
+		found.Spec.SecurityContext = desired.Spec.SecurityContext
+		changed = true
+	}
+	return changed
+}
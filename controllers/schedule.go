@@ -0,0 +1,123 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/robfig/cron/v3"
+
+	cachev1alpha1 "github.com/example-inc/memcached-operator/api/v1alpha1"
+)
+
+// maxScheduleSteps is the most cron starts evaluateSchedules walks through per schedule. Any start inside the window
+// makes it active, stopping early only makes the end of the window come too soon, and the reconcile with it.
+const maxScheduleSteps = 1000
+
+// scheduleBounds are the replica bounds of the currently active scaling schedules
+type scheduleBounds struct {
+	// min and max are nil when no active schedule sets them
+	min *int32
+	max *int32
+	// minFrom and maxFrom are the names of the schedules min and max come from
+	minFrom string
+	maxFrom string
+	// active are the names of the active schedules
+	active []string
+}
+
+// evaluateSchedules finds the schedules whose window is active at now, and combines their bounds by taking
+// the stricter one. It also returns the next time a window starts or ends, or the zero time if there is none.
+// Schedules with an invalid cron expression or time zone are logged and ignored.
+func evaluateSchedules(log logr.Logger, schedules []cachev1alpha1.ScalingSchedule, now time.Time) (scheduleBounds, time.Time) {
+	bounds := scheduleBounds{}
+	var next time.Time
+	updateNext := func(t time.Time) {
+		if !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+
+	for _, schedule := range schedules {
+		timeZone := schedule.TimeZone
+		if timeZone == "" {
+			timeZone = "UTC"
+		}
+		cronSchedule, err := cron.ParseStandard("CRON_TZ=" + timeZone + " " + schedule.Cron)
+		if err != nil {
+			log.Error(err, "Ignoring invalid schedule", "schedule", schedule.Name)
+			continue
+		}
+		duration := time.Duration(schedule.DurationMinutes) * time.Minute
+
+		// The window is active if the schedule started at most its duration ago
+		var lastStart time.Time
+		start := cronSchedule.Next(now.Add(-duration))
+		for steps := 0; steps < maxScheduleSteps && !start.IsZero() && !start.After(now); steps++ {
+			lastStart = start
+			start = cronSchedule.Next(start)
+		}
+		updateNext(cronSchedule.Next(now))
+		if lastStart.IsZero() || !lastStart.Add(duration).After(now) {
+			continue
+		}
+		updateNext(lastStart.Add(duration))
+
+		bounds.active = append(bounds.active, schedule.Name)
+		min, max := schedule.MinReplicas, schedule.MaxReplicas
+		if schedule.Size != nil {
+			min, max = schedule.Size, schedule.Size
+		}
+		if min != nil && (bounds.min == nil || *min > *bounds.min) {
+			bounds.min, bounds.minFrom = min, schedule.Name
+		}
+		if max != nil && (bounds.max == nil || *max < *bounds.max) {
+			bounds.max, bounds.maxFrom = max, schedule.Name
+		}
+	}
+	return bounds, next
+}
+
+// apply bounds the given number of replicas. It returns the bounded number, and the reason if it changed.
+// The maximum wins when the active schedules conflict.
+func (b scheduleBounds) apply(replicas int32) (int32, string) {
+	if b.max != nil && replicas > *b.max {
+		return *b.max, "Schedule " + b.maxFrom + " allows at most " + strconv.FormatInt(int64(*b.max), 10) + " replicas"
+	}
+	if b.min != nil && replicas < *b.min {
+		if b.max != nil && *b.max < *b.min {
+			return *b.max, "Schedule " + b.maxFrom + " allows at most " + strconv.FormatInt(int64(*b.max), 10) + " replicas"
+		}
+		return *b.min, "Schedule " + b.minFrom + " requires at least " + strconv.FormatInt(int64(*b.min), 10) + " replicas"
+	}
+	return replicas, ""
+}
+
+// requeueAtBoundary shortens requeueAfter so the reconcile happens when the next schedule window starts or ends
+func requeueAtBoundary(requeueAfter time.Duration, now, next time.Time) time.Duration {
+	if next.IsZero() {
+		return requeueAfter
+	}
+	untilNext := next.Sub(now)
+	if untilNext <= 0 {
+		untilNext = time.Second
+	}
+	if requeueAfter == 0 || untilNext < requeueAfter {
+		return untilNext
+	}
+	return requeueAfter
+}
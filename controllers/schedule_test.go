@@ -0,0 +1,159 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	cachev1alpha1 "github.com/example-inc/memcached-operator/api/v1alpha1"
+)
+
+func TestEvaluateSchedules(t *testing.T) {
+	int32Ptr := func(i int32) *int32 { return &i }
+	business := cachev1alpha1.ScalingSchedule{Name: "business", Cron: "0 8 * * *", DurationMinutes: 600, MinReplicas: int32Ptr(5)}
+	night := cachev1alpha1.ScalingSchedule{Name: "night", Cron: "0 16 * * *", DurationMinutes: 120, MaxReplicas: int32Ptr(3)}
+	newYork := cachev1alpha1.ScalingSchedule{Name: "new-york", Cron: "0 8 * * *", DurationMinutes: 60, TimeZone: "America/New_York", Size: int32Ptr(4)}
+
+	tests := []struct {
+		name       string
+		schedules  []cachev1alpha1.ScalingSchedule
+		now        time.Time
+		wantActive []string
+		wantNext   time.Time
+		replicas   int32
+		want       int32
+	}{
+		{
+			name:      "before the window",
+			schedules: []cachev1alpha1.ScalingSchedule{business},
+			now:       time.Date(2020, 6, 8, 7, 30, 0, 0, time.UTC),
+			wantNext:  time.Date(2020, 6, 8, 8, 0, 0, 0, time.UTC),
+			replicas:  2,
+			want:      2,
+		},
+		{
+			name:       "active window",
+			schedules:  []cachev1alpha1.ScalingSchedule{business},
+			now:        time.Date(2020, 6, 8, 9, 0, 0, 0, time.UTC),
+			wantActive: []string{"business"},
+			wantNext:   time.Date(2020, 6, 8, 18, 0, 0, 0, time.UTC),
+			replicas:   2,
+			want:       5,
+		},
+		{
+			name:      "window ended",
+			schedules: []cachev1alpha1.ScalingSchedule{business},
+			now:       time.Date(2020, 6, 8, 18, 0, 0, 0, time.UTC),
+			wantNext:  time.Date(2020, 6, 9, 8, 0, 0, 0, time.UTC),
+			replicas:  2,
+			want:      2,
+		},
+		{
+			name:       "next boundary is the start of another schedule",
+			schedules:  []cachev1alpha1.ScalingSchedule{business, night},
+			now:        time.Date(2020, 6, 8, 9, 0, 0, 0, time.UTC),
+			wantActive: []string{"business"},
+			wantNext:   time.Date(2020, 6, 8, 16, 0, 0, 0, time.UTC),
+			replicas:   2,
+			want:       5,
+		},
+		{
+			name:       "conflicting schedules, the maximum wins",
+			schedules:  []cachev1alpha1.ScalingSchedule{business, night},
+			now:        time.Date(2020, 6, 8, 17, 0, 0, 0, time.UTC),
+			wantActive: []string{"business", "night"},
+			wantNext:   time.Date(2020, 6, 8, 18, 0, 0, 0, time.UTC),
+			replicas:   2,
+			want:       3,
+		},
+		{
+			name: "invalid time zone is ignored",
+			schedules: []cachev1alpha1.ScalingSchedule{
+				{Name: "mars", Cron: "0 8 * * *", DurationMinutes: 600, TimeZone: "Mars/Olympus_Mons", Size: int32Ptr(1)},
+				business,
+			},
+			now:        time.Date(2020, 6, 8, 9, 0, 0, 0, time.UTC),
+			wantActive: []string{"business"},
+			wantNext:   time.Date(2020, 6, 8, 18, 0, 0, 0, time.UTC),
+			replicas:   2,
+			want:       5,
+		},
+		{
+			name:      "standard time before the DST change",
+			schedules: []cachev1alpha1.ScalingSchedule{newYork},
+			now:       time.Date(2020, 3, 6, 12, 30, 0, 0, time.UTC),
+			wantNext:  time.Date(2020, 3, 6, 13, 0, 0, 0, time.UTC),
+			replicas:  2,
+			want:      2,
+		},
+		{
+			name:       "daylight saving time after the DST change",
+			schedules:  []cachev1alpha1.ScalingSchedule{newYork},
+			now:        time.Date(2020, 3, 9, 12, 30, 0, 0, time.UTC),
+			wantActive: []string{"new-york"},
+			wantNext:   time.Date(2020, 3, 9, 13, 0, 0, 0, time.UTC),
+			replicas:   2,
+			want:       4,
+		},
+		{
+			name: "every minute for a week stops after a fixed number of steps",
+			schedules: []cachev1alpha1.ScalingSchedule{
+				{Name: "always", Cron: "* * * * *", DurationMinutes: 10080, MinReplicas: int32Ptr(2)},
+			},
+			now:        time.Date(2020, 6, 8, 9, 0, 0, 0, time.UTC),
+			wantActive: []string{"always"},
+			wantNext:   time.Date(2020, 6, 8, 9, 1, 0, 0, time.UTC),
+			replicas:   1,
+			want:       2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bounds, next := evaluateSchedules(ctrl.Log.WithName("test"), tt.schedules, tt.now)
+			if !reflect.DeepEqual(bounds.active, tt.wantActive) {
+				t.Errorf("evaluateSchedules() active = %v, want %v", bounds.active, tt.wantActive)
+			}
+			if !next.Equal(tt.wantNext) {
+				t.Errorf("evaluateSchedules() next = %v, want %v", next, tt.wantNext)
+			}
+			if got, _ := bounds.apply(tt.replicas); got != tt.want {
+				t.Errorf("apply(%v) = %v, want %v", tt.replicas, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateSchedulesWithClock(t *testing.T) {
+	schedules := []cachev1alpha1.ScalingSchedule{{Name: "lunch", Cron: "0 12 * * *", DurationMinutes: 60}}
+	fakeClock := clock.NewFakeClock(time.Date(2020, 6, 8, 11, 0, 0, 0, time.UTC))
+
+	// Requeueing at each boundary walks the clock in and out of the window
+	wantActive := []bool{false, true, false, true}
+	for i, want := range wantActive {
+		bounds, next := evaluateSchedules(ctrl.Log.WithName("test"), schedules, fakeClock.Now())
+		if active := len(bounds.active) == 1; active != want {
+			t.Errorf("step %d at %v: active = %v, want %v", i, fakeClock.Now(), active, want)
+		}
+		fakeClock.Step(requeueAtBoundary(time.Hour*24, fakeClock.Now(), next))
+	}
+	if want := time.Date(2020, 6, 9, 13, 0, 0, 0, time.UTC); !fakeClock.Now().Equal(want) {
+		t.Errorf("clock = %v, want %v", fakeClock.Now(), want)
+	}
+}
@@ -0,0 +1,289 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+const (
+	// activatorEventBufferSize is the number of wake up triggers that can be queued for the controller
+	activatorEventBufferSize = 100
+	// activatorPollInterval is how often a held connection checks whether the webserver is ready
+	activatorPollInterval = 500 * time.Millisecond
+	// activatorDialTimeout bounds a single connection attempt to the webserver
+	activatorDialTimeout = 5 * time.Second
+)
+
+// activatorTarget is the activator's bookkeeping for a single Webserver
+type activatorTarget struct {
+	listener net.Listener
+	port     int32
+	// backend is the host:port of the Service that selects the webserver pods
+	backend     string
+	wakeTimeout time.Duration
+	// lastActivity is the unix time in nanoseconds of the last byte seen in either direction
+	lastActivity int64
+	// waiting is the number of connections held until the webserver is ready
+	waiting int32
+}
+
+func (t *activatorTarget) touch() {
+	atomic.StoreInt64(&t.lastActivity, time.Now().UnixNano())
+}
+
+// activityWriter records activity on its target for every write
+type activityWriter struct {
+	io.Writer
+	target *activatorTarget
+}
+
+func (w activityWriter) Write(b []byte) (int, error) {
+	w.target.touch()
+	return w.Writer.Write(b)
+}
+
+// Activator is a TCP proxy in front of the Webservers that may scale to zero. While such a Webserver has no ready
+// pod, its Service routes to a port of the activator instead of the pods. The activator holds new connections,
+// triggers a reconcile through Events() so the controller scales the webserver up, and forwards them once a pod
+// is ready. The Service routes to the pods again from then on.
+// It is registered with the manager through mgr.Add, and listens on a port of IP per Webserver.
+type Activator struct {
+	client.Client
+	Log logr.Logger
+	// IP is the address the Services of the Webservers route to, the IP of the operator pod.
+	// Scale to zero is unavailable when it is empty
+	IP string
+
+	events chan event.GenericEvent
+
+	mu      sync.Mutex
+	targets map[types.NamespacedName]*activatorTarget
+}
+
+// NewActivator returns an Activator that reads Deployments through the given client
+func NewActivator(c client.Client, log logr.Logger, ip string) *Activator {
+	return &Activator{
+		Client:  c,
+		Log:     log,
+		IP:      ip,
+		events:  make(chan event.GenericEvent, activatorEventBufferSize),
+		targets: map[types.NamespacedName]*activatorTarget{},
+	}
+}
+
+// Available returns true if Webservers can be routed through the activator
+func (a *Activator) Available() bool {
+	return a != nil && a.IP != ""
+}
+
+// Events returns the channel the activator sends wake up triggers on. Use it with a source.Channel.
+func (a *Activator) Events() <-chan event.GenericEvent {
+	return a.events
+}
+
+// Start implements manager.Runnable. It blocks until stop is closed, and then closes every listener.
+func (a *Activator) Start(stop <-chan struct{}) error {
+	<-stop
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for key, t := range a.targets {
+		t.listener.Close()
+		delete(a.targets, key)
+	}
+	return nil
+}
+
+// Activate starts proxying the traffic of a Webserver to backend, and returns the port the activator listens
+// on for it. It listens on port when it is free, so the port survives a restart of the operator. Calling it
+// again only updates the backend and the wake timeout.
+func (a *Activator) Activate(key types.NamespacedName, backend string, wakeTimeout time.Duration, port int32) (int32, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if t, ok := a.targets[key]; ok {
+		t.backend = backend
+		t.wakeTimeout = wakeTimeout
+		return t.port, nil
+	}
+
+	var listener net.Listener
+	var err error
+	if port > 0 {
+		listener, err = net.Listen("tcp", net.JoinHostPort(a.IP, strconv.FormatInt(int64(port), 10)))
+		if err != nil {
+			a.Log.Info("Previous port of the Webserver is not available, using another one", "webserver", key, "port", port, "error", err.Error())
+		}
+	}
+	if listener == nil {
+		listener, err = net.Listen("tcp", net.JoinHostPort(a.IP, "0"))
+		if err != nil {
+			return 0, err
+		}
+	}
+	t := &activatorTarget{
+		listener:    listener,
+		port:        int32(listener.Addr().(*net.TCPAddr).Port),
+		backend:     backend,
+		wakeTimeout: wakeTimeout,
+	}
+	a.targets[key] = t
+	a.Log.Info("Activating Webserver", "webserver", key, "port", t.port, "backend", backend)
+	go a.accept(key, t)
+	return t.port, nil
+}
+
+// Deactivate stops proxying the traffic of a Webserver
+func (a *Activator) Deactivate(key types.NamespacedName) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if t, ok := a.targets[key]; ok {
+		a.Log.Info("Deactivating Webserver", "webserver", key)
+		t.listener.Close()
+		delete(a.targets, key)
+	}
+}
+
+// LastActivity returns when the activator last saw traffic for a Webserver, and false if it never did
+func (a *Activator) LastActivity(key types.NamespacedName) (time.Time, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	t, ok := a.targets[key]
+	if !ok || atomic.LoadInt64(&t.lastActivity) == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, atomic.LoadInt64(&t.lastActivity)), true
+}
+
+// Waiting returns true if connections to a Webserver are held until it is ready
+func (a *Activator) Waiting(key types.NamespacedName) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	t, ok := a.targets[key]
+	return ok && atomic.LoadInt32(&t.waiting) > 0
+}
+
+// accept serves the connections to a Webserver until its listener is closed
+func (a *Activator) accept(key types.NamespacedName, t *activatorTarget) {
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+		go a.serve(key, t, conn)
+	}
+}
+
+// serve waits for the webserver to be ready, and then proxies a connection to it
+func (a *Activator) serve(key types.NamespacedName, t *activatorTarget, conn net.Conn) {
+	defer conn.Close()
+	t.touch()
+
+	a.mu.Lock()
+	backend, wakeTimeout := t.backend, t.wakeTimeout
+	a.mu.Unlock()
+
+	backendConn, err := a.dialWhenReady(key, t, backend, time.Now().Add(wakeTimeout))
+	if err != nil {
+		a.Log.Error(err, "Failed to reach Webserver, dropping connection", "webserver", key)
+		return
+	}
+	defer backendConn.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(activityWriter{Writer: backendConn, target: t}, conn)
+		// Let the webserver know the client is done sending
+		if tcpConn, ok := backendConn.(*net.TCPConn); ok {
+			tcpConn.CloseWrite()
+		}
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(activityWriter{Writer: conn, target: t}, backendConn)
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			tcpConn.CloseWrite()
+		}
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+}
+
+// dialWhenReady connects to the webserver. While it has no ready pod, the connection is counted as waiting
+// and the controller is asked to scale the webserver up, until the deadline.
+func (a *Activator) dialWhenReady(key types.NamespacedName, t *activatorTarget, backend string, deadline time.Time) (net.Conn, error) {
+	counted := false
+	defer func() {
+		if counted {
+			atomic.AddInt32(&t.waiting, -1)
+		}
+	}()
+
+	for {
+		ready, err := a.ready(key)
+		if err != nil {
+			return nil, err
+		}
+		if ready {
+			// The Service may not route to the new pod yet, keep trying until the deadline
+			conn, err := net.DialTimeout("tcp", backend, activatorDialTimeout)
+			if err == nil || time.Now().After(deadline) {
+				return conn, err
+			}
+		} else if !counted {
+			counted = true
+			atomic.AddInt32(&t.waiting, 1)
+			a.Log.Info("Holding connection until the Webserver is ready", "webserver", key)
+			a.trigger(key)
+		}
+		if time.Now().After(deadline) {
+			return nil, context.DeadlineExceeded
+		}
+		time.Sleep(activatorPollInterval)
+	}
+}
+
+// ready returns true if the deployment of a Webserver has a ready pod
+func (a *Activator) ready(key types.NamespacedName) (bool, error) {
+	dep := &appsv1.Deployment{}
+	if err := a.Get(context.Background(), key, dep); err != nil {
+		return false, err
+	}
+	return dep.Status.ReadyReplicas > 0, nil
+}
+
+// trigger asks the Webserver controller to reconcile the given Webserver
+func (a *Activator) trigger(key types.NamespacedName) {
+	evt := event.GenericEvent{Meta: &metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace}}
+	select {
+	case a.events <- evt:
+	default:
+		// The periodic resync of the controller will pick the Webserver up eventually
+		a.Log.Info("Wake up trigger buffer is full, dropping event", "webserver", key)
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newTestActivator returns an activator on the loopback interface, with the Deployment of key reporting
+// the given number of ready replicas
+func newTestActivator(t *testing.T, key types.NamespacedName, readyReplicas int32) *Activator {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	dep := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace},
+		Status:     appsv1.DeploymentStatus{ReadyReplicas: readyReplicas},
+	}
+	return NewActivator(fake.NewFakeClientWithScheme(scheme, dep), ctrl.Log.WithName("test"), "127.0.0.1")
+}
+
+// dialActivator sends a request through the activator and returns everything it answers
+func dialActivator(t *testing.T, port int32, request string) string {
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.FormatInt(int64(port), 10)))
+	if err != nil {
+		t.Fatalf("failed to connect to the activator: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := conn.Write([]byte(request)); err != nil {
+		t.Fatalf("failed to write to the activator: %v", err)
+	}
+	conn.(*net.TCPConn).CloseWrite()
+	response, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("failed to read from the activator: %v", err)
+	}
+	return string(response)
+}
+
+func TestActivatorProxiesToReadyBackend(t *testing.T) {
+	// The backend echoes what it reads
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer backend.Close()
+	go func() {
+		for {
+			conn, err := backend.Accept()
+			if err != nil {
+				return
+			}
+			io.Copy(conn, conn)
+			conn.Close()
+		}
+	}()
+
+	key := types.NamespacedName{Name: "web", Namespace: "default"}
+	a := newTestActivator(t, key, 1)
+	port, err := a.Activate(key, backend.Addr().String(), time.Second, 0)
+	if err != nil {
+		t.Fatalf("Activate() error = %v", err)
+	}
+	defer a.Deactivate(key)
+
+	if got := dialActivator(t, port, "ping"); got != "ping" {
+		t.Errorf("response = %q, want %q", got, "ping")
+	}
+	if _, ok := a.LastActivity(key); !ok {
+		t.Errorf("LastActivity() = false, want the time of the connection")
+	}
+	if a.Waiting(key) {
+		t.Errorf("Waiting() = true, want false")
+	}
+	select {
+	case evt := <-a.Events():
+		t.Errorf("unexpected wake up trigger for %s", evt.Meta.GetName())
+	default:
+	}
+
+	// Activating again keeps the port
+	again, err := a.Activate(key, backend.Addr().String(), time.Second, 0)
+	if err != nil || again != port {
+		t.Errorf("Activate() = %v, %v, want %v", again, err, port)
+	}
+}
+
+func TestActivatorWakeTimeout(t *testing.T) {
+	key := types.NamespacedName{Name: "web", Namespace: "default"}
+	a := newTestActivator(t, key, 0)
+	// Nothing listens on the backend, the webserver never becomes ready
+	port, err := a.Activate(key, "127.0.0.1:1", 100*time.Millisecond, 0)
+	if err != nil {
+		t.Fatalf("Activate() error = %v", err)
+	}
+	defer a.Deactivate(key)
+
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.FormatInt(int64(port), 10)))
+	if err != nil {
+		t.Fatalf("failed to connect to the activator: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	response := make(chan []byte)
+	go func() {
+		// The activator closes the connection without an answer
+		got, _ := ioutil.ReadAll(conn)
+		response <- got
+	}()
+
+	select {
+	case evt := <-a.Events():
+		if evt.Meta.GetName() != key.Name || evt.Meta.GetNamespace() != key.Namespace {
+			t.Errorf("wake up trigger for %s/%s, want %s", evt.Meta.GetNamespace(), evt.Meta.GetName(), key)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no wake up trigger")
+	}
+	if !a.Waiting(key) {
+		t.Errorf("Waiting() = false while the connection is held")
+	}
+
+	// The connection is dropped once the wake timeout has passed
+	select {
+	case got := <-response:
+		if len(got) > 0 {
+			t.Errorf("response = %q, want none", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the connection was not dropped after the wake timeout")
+	}
+	if a.Waiting(key) {
+		t.Errorf("Waiting() = true after the wake timeout")
+	}
+}
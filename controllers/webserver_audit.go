@@ -0,0 +1,59 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	webserverv1alpha1 "github.com/example-inc/memcached-operator/api/v1alpha1"
+)
+
+// maxScalingHistory is the number of scaling decisions kept in the status of a Webserver
+const maxScalingHistory = 20
+
+// Policies that decide the number of replicas of a Webserver
+const (
+	sizeScalingPolicy     = "Size"
+	latencyScalingPolicy  = "Latency"
+	forecastScalingPolicy = "Forecast"
+	scheduleScalingPolicy = "Schedule"
+	// idleScalingPolicy scales a webserver without traffic to zero, activationScalingPolicy wakes it up again
+	idleScalingPolicy       = "Idle"
+	activationScalingPolicy = "Activation"
+)
+
+// recordScalingDecision adds a decision to the front of the scaling history of the Webserver, dropping the
+// oldest ones beyond maxScalingHistory, and writes it to the audit log as a single structured record
+func (r *WebserverReconciler) recordScalingDecision(webserver *webserverv1alpha1.Webserver, decision webserverv1alpha1.ScalingDecision) {
+	history := append([]webserverv1alpha1.ScalingDecision{decision}, webserver.Status.ScalingHistory...)
+	if len(history) > maxScalingHistory {
+		history = history[:maxScalingHistory]
+	}
+	webserver.Status.ScalingHistory = history
+
+	r.AuditLog.Info("Scaling decision",
+		"webserver", webserver.Name,
+		"namespace", webserver.Namespace,
+		"time", decision.Time.UTC(),
+		"fromReplicas", decision.FromReplicas,
+		"toReplicas", decision.ToReplicas,
+		"policy", decision.Policy,
+		"reason", decision.Reason,
+		"latencyMs", decision.LatencyMs,
+		"scaleUpThresholdMs", decision.ScaleUpThresholdMs,
+		"scaleDownThresholdMs", decision.ScaleDownThresholdMs,
+		"forecastLatencyMs", decision.ForecastLatencyMs,
+		"activeSchedules", decision.ActiveSchedules,
+		"dryRun", decision.DryRun,
+	)
+}
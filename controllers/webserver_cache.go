@@ -0,0 +1,209 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	webserverv1alpha1 "github.com/example-inc/memcached-operator/api/v1alpha1"
+)
+
+// Environment variables of the webserver container holding the connection settings of its Memcached
+const (
+	memcachedServersEnv  = "MEMCACHED_SERVERS"
+	memcachedUsernameEnv = "MEMCACHED_USERNAME"
+	memcachedPasswordEnv = "MEMCACHED_PASSWORD"
+)
+
+// memcachedServersAnnotation rolls the webserver pods when the memcached pods of their Memcached change
+const memcachedServersAnnotation = "cache.example.com/memcached-servers"
+
+// webserverCache is what the pod template of a Webserver needs to know about its Memcached
+type webserverCache struct {
+	name string
+	// auth is set when the Memcached requires SASL, and the credentials are passed to the webserver
+	auth bool
+	// servers is a hash of the memcached pods, only set when the pods roll on server changes
+	servers string
+	// settling is set while the memcached Deployment rolls or scales. The webserver pods keep the servers they
+	// have until it settles, see holdServers
+	settling bool
+}
+
+// reconcileCache looks up the Memcached of a Webserver, and sets the CacheReady condition. It returns nil when the
+// Webserver has no cacheRef, or the Memcached does not exist.
+func (r *WebserverReconciler) reconcileCache(ctx context.Context, log logr.Logger, ws *webserverv1alpha1.Webserver, now time.Time) (*webserverCache, error) {
+	ref := ws.Spec.CacheRef
+	if ref == nil {
+		removeCondition(&ws.Status.Conditions, webserverv1alpha1.CacheReadyCondition)
+		return nil, nil
+	}
+
+	m := &webserverv1alpha1.Memcached{}
+	err := r.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: ws.Namespace}, m)
+	if err != nil && errors.IsNotFound(err) {
+		setCondition(&ws.Status.Conditions, webserverv1alpha1.CacheReadyCondition, corev1.ConditionFalse, "CacheNotFound",
+			"Memcached "+ref.Name+" does not exist", now)
+		return nil, nil
+	} else if err != nil {
+		log.Error(err, "Failed to get Memcached", "Memcached.Name", ref.Name)
+		return nil, err
+	}
+
+	podList := &corev1.PodList{}
+	listOpts := []client.ListOption{
+		client.InNamespace(m.Namespace),
+		client.MatchingLabels(labelsForMemcached(m.Name)),
+	}
+	if err = r.List(ctx, podList, listOpts...); err != nil {
+		log.Error(err, "Failed to list pods", "Memcached.Namespace", m.Namespace, "Memcached.Name", m.Name)
+		return nil, err
+	}
+	if servers := readyMemcachedServers(podList.Items); len(servers) == 0 {
+		setCondition(&ws.Status.Conditions, webserverv1alpha1.CacheReadyCondition, corev1.ConditionFalse, "NoReadyServers",
+			"Memcached "+m.Name+" has no ready pods", now)
+	} else {
+		setCondition(&ws.Status.Conditions, webserverv1alpha1.CacheReadyCondition, corev1.ConditionTrue, "CacheReady",
+			"Memcached "+m.Name+" has "+strconv.Itoa(len(servers))+" ready pods", now)
+	}
+
+	cache := &webserverCache{name: m.Name, auth: m.Spec.Auth != nil}
+	if ref.RollOnServerChange {
+		cache.servers = memcachedServersHash(podList.Items)
+		dep := &appsv1.Deployment{}
+		err = r.Get(ctx, types.NamespacedName{Name: m.Name, Namespace: m.Namespace}, dep)
+		if err != nil && !errors.IsNotFound(err) {
+			log.Error(err, "Failed to get Deployment", "Deployment.Namespace", m.Namespace, "Deployment.Name", m.Name)
+			return nil, err
+		}
+		cache.settling = err != nil || !deploymentSettled(dep)
+	}
+	return cache, nil
+}
+
+// memcachedServersHash identifies the memcached pods by the address the clients reach them at. Whether the pods are
+// ready does not matter, so a pod failing its readiness probe for a moment doesn't roll the webservers.
+func memcachedServersHash(pods []corev1.Pod) string {
+	var servers []string
+	for i := range pods {
+		pod := &pods[i]
+		if pod.DeletionTimestamp != nil || pod.Status.PodIP == "" {
+			continue
+		}
+		servers = append(servers, memcachedHostname(pod))
+	}
+	sort.Strings(servers)
+	sum := sha256.Sum256([]byte(strings.Join(servers, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// deploymentSettled returns true when every replica of a Deployment runs its current pod template and is available
+func deploymentSettled(dep *appsv1.Deployment) bool {
+	replicas := int32(1)
+	if dep.Spec.Replicas != nil {
+		replicas = *dep.Spec.Replicas
+	}
+	return dep.Status.ObservedGeneration >= dep.Generation && dep.Status.Replicas == replicas &&
+		dep.Status.UpdatedReplicas == replicas && dep.Status.AvailableReplicas == replicas
+}
+
+// holdServers keeps the servers annotation of the running webserver pods while the Memcached settles, so they roll
+// once after a memcached rollout rather than at every step of it
+func (c *webserverCache) holdServers(template *corev1.PodTemplateSpec) {
+	if c != nil && c.servers != "" && c.settling {
+		c.servers = template.Annotations[memcachedServersAnnotation]
+	}
+}
+
+// applyCache passes the server list and the credentials of the Memcached to the webserver container. They are
+// optional, so the pods start before the Memcached publishes them.
+func applyCache(template *corev1.PodTemplateSpec, cache *webserverCache) {
+	if cache == nil {
+		return
+	}
+	optional := true
+	container := &template.Spec.Containers[0]
+	container.Env = append(container.Env, corev1.EnvVar{
+		Name: memcachedServersEnv,
+		ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: serverListName(cache.name)},
+			Key:                  serverListServersKey,
+			Optional:             &optional,
+		}},
+	})
+	if cache.auth {
+		credentials := []struct{ env, key string }{
+			{memcachedUsernameEnv, clientSecretUsernameKey},
+			{memcachedPasswordEnv, clientSecretPasswordKey},
+		}
+		for _, credential := range credentials {
+			container.Env = append(container.Env, corev1.EnvVar{
+				Name: credential.env,
+				ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: clientSecretName(cache.name)},
+					Key:                  credential.key,
+					Optional:             &optional,
+				}},
+			})
+		}
+	}
+	if cache.servers != "" {
+		if template.Annotations == nil {
+			template.Annotations = map[string]string{}
+		}
+		template.Annotations[memcachedServersAnnotation] = cache.servers
+	}
+}
+
+// webserversForMemcached maps a Memcached to the Webservers in its namespace using it
+func (r *WebserverReconciler) webserversForMemcached(obj handler.MapObject) []reconcile.Request {
+	webserverList := &webserverv1alpha1.WebserverList{}
+	if err := r.List(context.Background(), webserverList, client.InNamespace(obj.Meta.GetNamespace())); err != nil {
+		r.Log.Error(err, "Failed to list Webservers", "Memcached.Namespace", obj.Meta.GetNamespace(), "Memcached.Name", obj.Meta.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, ws := range webserverList.Items {
+		if ws.Spec.CacheRef != nil && ws.Spec.CacheRef.Name == obj.Meta.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: ws.Name, Namespace: ws.Namespace}})
+		}
+	}
+	return requests
+}
+
+// webserversForMemcachedDeployment maps the Deployment of a Memcached to the Webservers using it, so they roll once
+// a memcached rollout settles
+func (r *WebserverReconciler) webserversForMemcachedDeployment(obj handler.MapObject) []reconcile.Request {
+	if owner := metav1.GetControllerOf(obj.Meta); owner == nil || owner.Kind != "Memcached" {
+		return nil
+	}
+	return r.webserversForMemcached(obj)
+}
@@ -16,39 +16,79 @@ package controllers
 
 import (
 	"context"
-	"crypto/tls"
-	"fmt"
-	"log"
-	"net/http"
-	"net/http/httptrace"
-	"os"
+	"reflect"
 	"strconv"
 	"time"
 
 	"github.com/go-logr/logr"
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2beta2 "k8s.io/api/autoscaling/v2beta2"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1beta1 "k8s.io/api/networking/v1beta1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	webserverv1alpha1 "github.com/example-inc/memcached-operator/api/v1alpha1"
 )
 
+// Constant values used as thresholds/limits for scaling up/down
+const (
+	latencyScaleUpLimit   = int64(900)
+	latencyScaleDownLimit = int64(200)
+)
+
+// podDeletionCostAnnotation tells the ReplicaSet controller which pods to remove first when scaling down.
+// Pods with a lower cost are removed first
+const (
+	podDeletionCostAnnotation = "controller.kubernetes.io/pod-deletion-cost"
+	readyPodDeletionCost      = "0"
+	unreadyPodDeletionCost    = "-100"
+)
+
+// The webserver container listens on this named port. The Service and the probes are derived from it
+const (
+	webserverPortName = "ping"
+	webserverPort     = int32(8080)
+)
+
 // WebServerReconciler reconciles a WebServer object
 type WebserverReconciler struct {
 	client.Client
 	Log    logr.Logger
 	Scheme *runtime.Scheme
+	// Prober measures the latency of the Webservers in the background
+	Prober *LatencyProber
+	// Clock is used to evaluate the scaling schedules
+	Clock clock.Clock
+	// Recorder publishes the recommendations of the Recommend autoscaling mode as events
+	Recorder record.EventRecorder
+	// AuditLog receives a structured record of every scaling decision, see webserver_audit.go
+	AuditLog logr.Logger
+	// Activator holds the traffic of the Webservers that scale to zero, see webserver_activator.go
+	Activator *Activator
 }
 
-// +kubebuilder:rbac:groups=cache.example.com,resources=webserver,verbs=get;list;watch;create;update;patch;delete
-// +kubebuilder:rbac:groups=cache.example.com,resources=webserver/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=cache.example.com,resources=webservers,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=cache.example.com,resources=webservers/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=cache.example.com,resources=memcacheds,verbs=get;list;watch
 // +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
-// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;patch;delete
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=endpoints,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=autoscaling,resources=horizontalpodautoscalers,verbs=get;list;watch;create;update;patch;delete
 
 func (r *WebserverReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	ctx := context.Background() // this context will NOT trigger a new Reconcile. It is often used to update Status about the result from a Reconcile action.
@@ -70,11 +110,17 @@ func (r *WebserverReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return ctrl.Result{}, err
 	}
 
+	// Pass the connection settings of the Memcached the webserver uses, see webserver_cache.go
+	cache, err := r.reconcileCache(ctx, log, webserver, r.Clock.Now())
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
 	// Check if deployment exists, if not create it
 	found := &appsv1.Deployment{}
 	err = r.Get(ctx, types.NamespacedName{Name: webserver.Name, Namespace: webserver.Namespace}, found)
 	if err != nil && errors.IsNotFound(err) {
-		dep := r.deploymentForWebserver(webserver)
+		dep := r.deploymentForWebserver(webserver, cache)
 		log.Info("Creating a new Deployment", "Deployment.Namespace", dep.Namespace, "Deployment.Name", dep.Name)
 		err = r.Create(ctx, dep)
 		if err != nil {
@@ -89,81 +135,415 @@ func (r *WebserverReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return ctrl.Result{}, err
 	}
 
-	latencyMs := getLatencyMilliseconds()
-	latencyMsString := strconv.FormatInt(latencyMs, 10)
-	fullLogString := "\n\n!!!! LatencyMS value: " + latencyMsString + " ----------\n\n"
-	log.Info(fullLogString)
+	// Ready the activator for a webserver that may scale to zero, see webserver_scaletozero.go
+	activatorPort, err := r.reconcileActivator(ctx, log, webserver, r.Clock.Now())
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	scaleToZero := activatorPort != 0
 
-	// webserver.Status.Latency = latency
-	// err = r.Status().Update(ctx, webserver)
-	// if err != nil {
-	// 	log.Error(err, "Failed to update Webserver status")
-	// 	return ctrl.Result{}, err
-	// }
+	// Check if the service exists, if not create it. The prober reaches the webserver through it. It only routes
+	// through the activator while the webserver has no ready pod
+	viaActivator := scaleToZero && (*found.Spec.Replicas == 0 || found.Status.ReadyReplicas == 0)
+	if err = r.reconcileRouting(ctx, log, webserver, viaActivator, activatorPort); err != nil {
+		return ctrl.Result{}, err
+	}
 
-	// Constant values used as thresholds/limits for scaling up/down
-	latencyScaleUpLimit := int64(900)
-	latencyScaleDownLimit := int64(200)
+	// Create, update or delete the ingress, depending on the spec in the CR
+	if err = r.reconcileIngress(ctx, log, webserver); err != nil {
+		return ctrl.Result{}, err
+	}
 
-	// Update Status.Latency if needed
-	if latencyMs >= latencyScaleUpLimit {
-		log.Info("Latency is larger than " + strconv.FormatInt(latencyScaleUpLimit, 10) + ". Latency: " + latencyMsString)
-		newSize := *found.Spec.Replicas + 1
-		found.Spec.Replicas = &newSize
+	// Ensure the pods run with the security context, the health checks and the cache settings derived from the CR,
+	// see podsecurity.go, healthchecks.go and webserver_cache.go. Changing them rolls the pods
+	setPodSecurityCondition(&webserver.Status.Conditions, webserver.Spec.Security, r.Clock.Now())
+	cache.holdServers(&found.Spec.Template)
+	if syncPodTemplate(&found.Spec.Template, &r.deploymentForWebserver(webserver, cache).Spec.Template, webserverPodAnnotations) {
+		log.Info("Updating the webserver pods", "Deployment.Namespace", found.Namespace, "Deployment.Name", found.Name)
+		if err = r.Update(ctx, found); err != nil {
+			log.Error(err, "Failed to update Deployment", "Deployment.Namespace", found.Namespace, "Deployment.Name", found.Name)
+			return ctrl.Result{}, err
+		}
+		// Spec updated - return and requeue
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	// Create, update or delete the HorizontalPodAutoscaler, depending on the autoscaling mode, see hpa.go
+	var hpaSpec *webserverv1alpha1.HorizontalPodAutoscalerSpec
+	if webserver.Spec.Autoscaling.Mode == webserverv1alpha1.HorizontalPodAutoscalerAutoscalingMode {
+		hpaSpec = webserver.Spec.Autoscaling.HorizontalPodAutoscaler
+	}
+	hpaStatus, err := reconcileHPA(ctx, r.Client, r.Scheme, log, webserver, found, hpaSpec, webserver.Spec.Size)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	webserver.Status.HorizontalPodAutoscaler = hpaStatus
+	if webserver.Spec.Autoscaling.Mode == webserverv1alpha1.HorizontalPodAutoscalerAutoscalingMode {
+		// The HorizontalPodAutoscaler owns the replicas, only reflect its status
+		return r.updateStatusForHPA(ctx, log, webserver, found)
+	}
+
+	// Ensure the deployment size is the same as the spec in the CR when autoscaling is disabled,
+	// and at least the minimum size when autoscaling is enabled
+	size := webserver.Spec.Size
+	minReplicas := minReplicasFor(webserver, scaleToZero)
+	current := *found.Spec.Replicas
+	desired, reason := current, "Replicas are within the autoscaling bounds"
+	// policy is what decided the number of replicas, for the scaling history
+	policy := ""
+	autoscaling := webserver.Spec.Autoscaling.Mode != webserverv1alpha1.DisabledAutoscalingMode
+	// In Recommend mode everything below runs as in Latency mode, but nothing is changed
+	recommendOnly := webserver.Spec.Autoscaling.Mode == webserverv1alpha1.RecommendAutoscalingMode
+	if !autoscaling {
+		desired, reason, policy = size, "Autoscaling is disabled, replicas are kept at spec.size", sizeScalingPolicy
+	} else if current < minReplicas {
+		desired, reason, policy = minReplicas, "Replicas are raised to "+strconv.FormatInt(int64(minReplicas), 10)+", the minimum size when autoscaling", sizeScalingPolicy
+	}
+
+	// Scheduled scaling windows bound the number of replicas, see schedule.go
+	now := r.Clock.Now()
+	bounds, nextBoundary := evaluateSchedules(log, webserver.Spec.Schedules, now)
+
+	// The latency is measured in the background by the prober, see webserver_prober.go
+	var latencyMs int64
+	var forecastLatencyMs *int64
+	latency, ok := r.Prober.Latency(req.NamespacedName)
+	if ok {
+		latencyMs = latency.Milliseconds()
+		log.Info("Measured latency", "latencyMs", latencyMs)
+		webserver.Status.Latency = strconv.FormatInt(latencyMs, 10)
+
+		// Replace a pod that has been a latency outlier compared to its peers for too long
+		if webserver.Spec.Probe.Mode == webserverv1alpha1.PodsProbeMode && webserver.Spec.Probe.PodReplacement != nil && !recommendOnly {
+			replaced, err := r.replaceOutlierPod(ctx, log, webserver, r.Prober.PodLatencies(req.NamespacedName))
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			if replaced {
+				// Pod deleted - return and requeue
+				return ctrl.Result{RequeueAfter: time.Second * 5}, nil
+			}
+		}
+
+		if autoscaling && desired == current {
+			desired, reason, err = r.autoscale(ctx, log, webserver, found, latencyMs, recommendOnly)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			if desired != current {
+				policy = latencyScalingPolicy
+			}
+		}
+
+		// Scale up ahead of an expected latency spike, see webserver_forecast.go
+		if autoscaling && webserver.Spec.Autoscaling.Predictive != nil {
+			forecast, err := r.forecast(ctx, log, webserver, latencyMs, current, now)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			webserver.Status.Forecast = forecast
+			if forecast != nil {
+				forecastLatencyMs = &forecast.PredictedLatencyMs
+			}
+			if forecast != nil && !webserver.Spec.Autoscaling.Predictive.DryRun && forecast.RecommendedReplicas > desired {
+				desired, policy = forecast.RecommendedReplicas, forecastScalingPolicy
+				reason = "Latency is forecast to reach " + strconv.FormatInt(forecast.PredictedLatencyMs, 10) + "ms by " +
+					forecast.ForecastTime.UTC().Format(time.RFC3339) + ", scaling up ahead of it"
+			}
+		}
+	} else {
+		log.Info("No latency samples for the Webserver yet")
+	}
+
+	// Without traffic, the webserver is scaled to zero until the activator sees a connection for it
+	var untilIdle time.Duration
+	if scaleToZero {
+		idleDesired, idleReason, idle, remaining := r.idleScaling(webserver, current, now)
+		if idle {
+			desired, reason = idleDesired, idleReason
+			if idleDesired != current {
+				policy = idleScalingPolicy
+				if current == 0 {
+					policy = activationScalingPolicy
+				}
+			}
+		}
+		untilIdle = remaining
+	}
+
+	// The stricter of the schedule and the autoscaling bounds applies
+	if bounded, why := bounds.apply(desired); bounded != desired {
+		desired, reason, policy = bounded, why, scheduleScalingPolicy
+	}
+
+	decision := webserverv1alpha1.ScalingDecision{
+		Time:                 metav1.NewTime(now),
+		FromReplicas:         current,
+		ToReplicas:           desired,
+		Policy:               policy,
+		Reason:               reason,
+		ScaleUpThresholdMs:   latencyScaleUpLimit,
+		ScaleDownThresholdMs: latencyScaleDownLimit,
+		ForecastLatencyMs:    forecastLatencyMs,
+		ActiveSchedules:      bounds.active,
+	}
+	if ok {
+		decision.LatencyMs = &latencyMs
+	}
+
+	if recommendOnly {
+		r.recordRecommendation(log, webserver, decision)
+		desired, reason = current, "Autoscaling is in Recommend mode, the deployment is not scaled"
+	} else {
+		webserver.Status.Recommendation = nil
+	}
+
+	if desired != current {
+		if scaleToZero && desired == 0 && !viaActivator {
+			// Hand the traffic to the activator before the last pod goes away
+			if err = r.reconcileRouting(ctx, log, webserver, true, activatorPort); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		found.Spec.Replicas = &desired
+		log.Info("Scaling Deployment", "Deployment.Namespace", found.Namespace, "Deployment.Name", found.Name, "from", current, "to", desired, "reason", reason)
 		err = r.Update(ctx, found)
 		if err != nil {
 			log.Error(err, "Failed to update Deployment", "Deployment.Namespace", found.Namespace, "Deployment.Name", found.Name)
 			return ctrl.Result{}, err
 		}
+		r.recordScalingDecision(webserver, decision)
+	}
+
+	webserver.Status.DesiredReplicas = desired
+	webserver.Status.CurrentReplicas = found.Status.Replicas
+	webserver.Status.ScalingReason = reason
+	webserver.Status.ActiveSchedules = bounds.active
+	if found.Status.Replicas != desired {
+		webserver.Status.ScalingReason += ", waiting for the deployment to reach " + strconv.FormatInt(int64(desired), 10) + " replicas"
+	}
+	if last := r.Prober.LastProbe(req.NamespacedName); last != nil {
+		webserver.Status.LatencyBreakdown = latencyBreakdownFor(last)
+	}
+	webserver.Status.PodLatencies = nil
+	for _, pl := range r.Prober.PodLatencies(req.NamespacedName) {
+		webserver.Status.PodLatencies = append(webserver.Status.PodLatencies, webserverv1alpha1.PodLatency{
+			Name:             pl.Name,
+			LatencyMs:        pl.Latency.Milliseconds(),
+			OutlierIntervals: pl.OutlierIntervals,
+		})
+	}
+	err = r.Status().Update(ctx, webserver)
+	if err != nil {
+		log.Error(err, "Failed to update Webserver status")
+		return ctrl.Result{}, err
+	}
+
+	result := ctrl.Result{}
+	if desired != current {
 		// Spec updated - return and requeue
-		return ctrl.Result{RequeueAfter: time.Second * 5}, nil
+		result.RequeueAfter = time.Second * 5
+	}
+	// Reconcile again exactly when a schedule window starts or ends, or the webserver becomes idle
+	result.RequeueAfter = requeueAtBoundary(result.RequeueAfter, now, nextBoundary)
+	if untilIdle > 0 && (result.RequeueAfter == 0 || untilIdle < result.RequeueAfter) {
+		result.RequeueAfter = untilIdle
+	}
+	return result, nil
+}
+
+// updateStatusForHPA updates the status of a Webserver whose replicas are managed by a HorizontalPodAutoscaler
+func (r *WebserverReconciler) updateStatusForHPA(ctx context.Context, log logr.Logger, webserver *webserverv1alpha1.Webserver, found *appsv1.Deployment) (ctrl.Result, error) {
+	if latency, ok := r.Prober.Latency(types.NamespacedName{Name: webserver.Name, Namespace: webserver.Namespace}); ok {
+		webserver.Status.Latency = strconv.FormatInt(latency.Milliseconds(), 10)
+	}
+	webserver.Status.CurrentReplicas = found.Status.Replicas
+	webserver.Status.ActiveSchedules = nil
+	webserver.Status.Recommendation = nil
+	webserver.Status.Forecast = nil
+	if hpa := webserver.Status.HorizontalPodAutoscaler; hpa != nil {
+		webserver.Status.DesiredReplicas = hpa.DesiredReplicas
+		webserver.Status.ScalingReason = "Replicas are managed by the HorizontalPodAutoscaler"
+	} else {
+		webserver.Status.DesiredReplicas = *found.Spec.Replicas
+		webserver.Status.ScalingReason = "Autoscaling is in HorizontalPodAutoscaler mode, but spec.autoscaling.horizontalPodAutoscaler is not set"
+	}
+	if err := r.Status().Update(ctx, webserver); err != nil {
+		log.Error(err, "Failed to update Webserver status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// autoscale returns the number of replicas the webserver deployment should have for the given latency, and why.
+// It never scales below spec.size, nor below one replica.
+// When dryRun is set, the pods are left untouched.
+func (r *WebserverReconciler) autoscale(ctx context.Context, log logr.Logger, webserver *webserverv1alpha1.Webserver, found *appsv1.Deployment, latencyMs int64, dryRun bool) (int32, string, error) {
+	current := *found.Spec.Replicas
+	latencyMsString := strconv.FormatInt(latencyMs, 10)
+
+	if latencyMs >= latencyScaleUpLimit {
+		log.Info("Latency is larger than " + strconv.FormatInt(latencyScaleUpLimit, 10) + ". Latency: " + latencyMsString)
+		return current + 1, "Latency " + latencyMsString + "ms is above " + strconv.FormatInt(latencyScaleUpLimit, 10) + "ms, scaling up", nil
 	}
 
-	// Update Status.Latency if needed
 	if latencyMs <= latencyScaleDownLimit {
 		log.Info("Latency is less than " + strconv.FormatInt(latencyScaleDownLimit, 10) + ". latencyMs: " + latencyMsString)
-		// Update the Webserver status with the pod names
+		below := "Latency " + latencyMsString + "ms is below " + strconv.FormatInt(latencyScaleDownLimit, 10) + "ms"
+
+		// Latency never scales to zero, only the lack of traffic does
+		minSize := minReplicasFor(webserver, false)
+		if current <= minSize {
+			return current, below + ", but replicas are at the minimum size", nil
+		}
+
+		// Don't scale down again before the deployment has caught up with the previous change,
+		// the pods of that change are still starting or terminating
+		if found.Status.ObservedGeneration < found.Generation || found.Status.Replicas != current {
+			return current, below + ", but the deployment has not reached " + strconv.FormatInt(int64(current), 10) + " replicas yet", nil
+		}
+
 		// List the pods for this webserver's deployment
 		podList := &corev1.PodList{}
 		listOpts := []client.ListOption{
 			client.InNamespace(webserver.Namespace),
 			client.MatchingLabels(labelsForWebserver(webserver.Name)),
 		}
-		if err = r.List(ctx, podList, listOpts...); err != nil {
+		if err := r.List(ctx, podList, listOpts...); err != nil {
 			log.Error(err, "Failed to list pods", "webserver.Namespace", webserver.Namespace, "webserver.Name", webserver.Name)
-			return ctrl.Result{}, err
+			return current, "", err
 		}
 
-		numPodsLen := len(podList.Items)
-		numPodsLenString := strconv.FormatInt(int64(numPodsLen), 10)
-		log.Info("numPodsLen is: " + numPodsLenString)
+		// Terminating pods are on their way out already, and only ready pods serve traffic
+		var livePods []corev1.Pod
+		readyPods := 0
+		for _, pod := range podList.Items {
+			if pod.DeletionTimestamp != nil {
+				continue
+			}
+			livePods = append(livePods, pod)
+			if isPodReady(&pod) {
+				readyPods++
+			}
+		}
+		log.Info("Pods of the deployment", "live", len(livePods), "ready", readyPods)
+		if readyPods == 0 {
+			return current, below + ", but no pod is ready", nil
+		}
 
-		if numPodsLen > 1 {
-			newSize := int32(numPodsLen) - 1
-			found.Spec.Replicas = &newSize
-			log.Info("New size is: " + strconv.FormatInt(int64(newSize), 10))
-			err = r.Update(ctx, found)
-			if err != nil {
-				log.Error(err, "Failed to update Deployment", "Deployment.Namespace", found.Namespace, "Deployment.Name", found.Name)
-				return ctrl.Result{}, err
+		// Make the deployment remove an unready pod first
+		if !dryRun {
+			if err := r.setPodDeletionCosts(ctx, livePods); err != nil {
+				log.Error(err, "Failed to set pod deletion costs", "webserver.Namespace", webserver.Namespace, "webserver.Name", webserver.Name)
+				return current, "", err
 			}
-			// Spec updated - return and requeue
-			return ctrl.Result{RequeueAfter: time.Second * 5}, nil
 		}
+
+		newSize := current - 1
+		log.Info("New size is: " + strconv.FormatInt(int64(newSize), 10))
+		return newSize, below + ", scaling down", nil
 	}
 
-	webserver.Status.Latency = strconv.FormatInt(latencyMs, 10)
-	err = r.Status().Update(ctx, webserver)
-	if err != nil {
-		log.Error(err, "Failed to update Webserver status")
-		return ctrl.Result{}, err
+	return current, "Latency " + latencyMsString + "ms is within the scaling thresholds", nil
+}
+
+// recordRecommendation stores what the autoscaler would do in the status of the Webserver. Whenever the
+// recommended number of replicas changes, it publishes an event and records the decision in the scaling history.
+func (r *WebserverReconciler) recordRecommendation(log logr.Logger, webserver *webserverv1alpha1.Webserver, decision webserverv1alpha1.ScalingDecision) {
+	previous := webserver.Status.Recommendation
+	webserver.Status.Recommendation = &webserverv1alpha1.ScalingRecommendation{
+		Replicas:  decision.ToReplicas,
+		Reason:    decision.Reason,
+		LatencyMs: decision.LatencyMs,
+		Time:      decision.Time,
+	}
+	if previous != nil && previous.Replicas == decision.ToReplicas {
+		return
 	}
 
-	return ctrl.Result{}, nil
+	current, desired := decision.FromReplicas, decision.ToReplicas
+	log.Info("Recommending replicas", "current", current, "recommended", desired, "reason", decision.Reason)
+	message := "Would keep " + strconv.FormatInt(int64(current), 10) + " replicas: " + decision.Reason
+	if desired != current {
+		message = "Would scale from " + strconv.FormatInt(int64(current), 10) + " to " + strconv.FormatInt(int64(desired), 10) + " replicas: " + decision.Reason
+		decision.DryRun = true
+		r.recordScalingDecision(webserver, decision)
+	}
+	r.Recorder.Event(webserver, corev1.EventTypeNormal, "ScalingRecommended", message)
+}
+
+// setPodDeletionCosts annotates the pods with the pod deletion cost, so the ReplicaSet removes unready pods
+// before ready ones when the deployment is scaled down
+func (r *WebserverReconciler) setPodDeletionCosts(ctx context.Context, pods []corev1.Pod) error {
+	for i := range pods {
+		pod := &pods[i]
+		cost := readyPodDeletionCost
+		if !isPodReady(pod) {
+			cost = unreadyPodDeletionCost
+		}
+		if pod.Annotations[podDeletionCostAnnotation] == cost {
+			continue
+		}
+		patch := client.MergeFrom(pod.DeepCopy())
+		if pod.Annotations == nil {
+			pod.Annotations = map[string]string{}
+		}
+		pod.Annotations[podDeletionCostAnnotation] = cost
+		if err := r.Patch(ctx, pod, patch); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// isPodReady returns true if the Ready condition of the pod is true
+func isPodReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// replaceOutlierPod deletes the first pod that has been a latency outlier for the configured number of
+// intervals, unless another pod was deleted less than the minimum deletion interval ago.
+// It returns true if a pod was deleted. The prober keeps triggering reconciles while a pod is an outlier.
+func (r *WebserverReconciler) replaceOutlierPod(ctx context.Context, log logr.Logger, ws *webserverv1alpha1.Webserver, podLatencies []podLatency) (bool, error) {
+	config := probeSettings(ws)
+	for _, pl := range podLatencies {
+		if pl.OutlierIntervals < config.outlierIntervals {
+			continue
+		}
+
+		// Rate limit the deletions, so a bad node or a slow dependency can't make us delete every pod
+		minInterval := minDeletionInterval(ws.Spec.Probe.PodReplacement)
+		if last := ws.Status.LastPodReplacementTime; last != nil && r.Clock.Now().Sub(last.Time) < minInterval {
+			log.Info("Pod is a latency outlier, but a pod was replaced recently", "Pod.Name", pl.Name, "LastPodReplacementTime", last)
+			return false, nil
+		}
+
+		log.Info("Deleting pod with outlier latency", "Pod.Name", pl.Name, "LatencyMs", pl.Latency.Milliseconds(), "OutlierIntervals", pl.OutlierIntervals)
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: pl.Name, Namespace: ws.Namespace}}
+		if err := r.Delete(ctx, pod); err != nil && !errors.IsNotFound(err) {
+			log.Error(err, "Failed to delete pod", "Pod.Namespace", pod.Namespace, "Pod.Name", pod.Name)
+			return false, err
+		}
+		r.Prober.ForgetPod(types.NamespacedName{Name: ws.Name, Namespace: ws.Namespace}, pl.Name)
+
+		now := metav1.NewTime(r.Clock.Now())
+		ws.Status.LastPodReplacementTime = &now
+		if err := r.Status().Update(ctx, ws); err != nil {
+			log.Error(err, "Failed to update Webserver status")
+			return true, err
+		}
+		return true, nil
+	}
+	return false, nil
 }
 
 // deploymentForWebServer returns a webserver Deployment object
-func (r *WebserverReconciler) deploymentForWebserver(ws *webserverv1alpha1.Webserver) *appsv1.Deployment {
+func (r *WebserverReconciler) deploymentForWebserver(ws *webserverv1alpha1.Webserver, cache *webserverCache) *appsv1.Deployment {
 	ls := labelsForWebserver(ws.Name)
 	replicas := ws.Spec.Size
 
@@ -185,86 +565,288 @@ func (r *WebserverReconciler) deploymentForWebserver(ws *webserverv1alpha1.Webse
 					Containers: []corev1.Container{{
 						Image: "persundecern/webserver-ping-amd64:v0.0.2",
 						Name:  "ws-ping",
-						Ports: []corev1.ContainerPort{{
-							ContainerPort: 8080,
-							Name:          "ping",
-						}},
+						Ports: webserverContainerPorts(),
 					}},
 				},
 			},
 		},
 	}
+	// Run as nobody, with the restricted security context and the overrides of the CR, see podsecurity.go
+	applyPodSecurity(&dep.Spec.Template, ws.Spec.Security, webserverUser)
+	// Readiness and liveness probes on the ping port, see healthchecks.go
+	applyHealthChecks(&dep.Spec.Template, ws.Spec.HealthChecks, webserverPortName, corev1.Handler{HTTPGet: &corev1.HTTPGetAction{
+		Path:   "/",
+		Port:   intstr.FromString(webserverPortName),
+		Scheme: corev1.URISchemeHTTP,
+	}})
+	// Connection settings of the Memcached in spec.cacheRef, see webserver_cache.go
+	applyCache(&dep.Spec.Template, cache)
 	// Set Webserver instance as the owner and controller
 	ctrl.SetControllerReference(ws, dep, r.Scheme)
 	return dep
 }
 
-// labelsForWebserver returns the labels for selecting the resources
-// belonging to the given webserver CR name.
-func labelsForWebserver(name string) map[string]string {
-	return map[string]string{"app": "webserver", "webserver_cr": name}
+// webserverPodAnnotations are the pod template annotations the operator manages
+var webserverPodAnnotations = []string{corev1.SeccompPodAnnotationKey, memcachedServersAnnotation}
+
+// webserverContainerPorts returns the ports of the webserver container
+func webserverContainerPorts() []corev1.ContainerPort {
+	return []corev1.ContainerPort{{
+		ContainerPort: webserverPort,
+		Name:          webserverPortName,
+	}}
 }
 
-func getLatencyMilliseconds() int64 {
-	// TODO: add a timer here, so that it will only check latency max every 5(?) sec
-	/** TODO:
-	* 1. Store time before
-	* 2. ping webserver pod
-	* 3. Get time after
-	* 4. Calculate latency
-	* 5. Return latency
-	 */
-	// min := 0.0
-	// max := 5.0
-	// latencyFloat := min + rand.Float64()*(max-min)
-	// latencyJSONNumber := json.Number(strconv.FormatFloat(latencyFloat, 'f', 4, 64))
-	//url := "https://www.google.com/"
-	webserverServiceSERVICEHOST := os.Getenv("WEBSERVER_SERVICE_SERVICE_HOST")
-	webserverServiceSERVICEPORT := os.Getenv("WEBSERVER_SERVICE_SERVICE_PORT")
-	url := "http://" + webserverServiceSERVICEHOST + ":" + webserverServiceSERVICEPORT
-	//url := "https://www.vg.no/"
-	latencyMilliseconds := timeGet(url).Milliseconds()
-	return latencyMilliseconds
+// reconcileService creates a Service of a Webserver, and keeps its type, ports and selector in line with the CR
+func (r *WebserverReconciler) reconcileService(ctx context.Context, log logr.Logger, svc *corev1.Service) error {
+	found := &corev1.Service{}
+	err := r.Get(ctx, types.NamespacedName{Name: svc.Name, Namespace: svc.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		log.Info("Creating a new Service", "Service.Namespace", svc.Namespace, "Service.Name", svc.Name)
+		err = r.Create(ctx, svc)
+		if err != nil {
+			log.Error(err, "Failed to create new Service", "Service.Namespace", svc.Namespace, "Service.Name", svc.Name)
+			return err
+		}
+		return nil
+	} else if err != nil {
+		log.Error(err, "Failed to get Service")
+		return err
+	}
+
+	// Ensure the service type, ports and selector are the same as derived from the CR.
+	// Ports are compared without the node port, which is allocated by the cluster.
+	if found.Spec.Type != svc.Spec.Type || !servicePortsMatch(found.Spec.Ports, svc.Spec.Ports) ||
+		!reflect.DeepEqual(found.Spec.Selector, svc.Spec.Selector) {
+		found.Spec.Type = svc.Spec.Type
+		found.Spec.Ports = svc.Spec.Ports
+		found.Spec.Selector = svc.Spec.Selector
+		log.Info("Updating Service", "Service.Namespace", found.Namespace, "Service.Name", found.Name)
+		err = r.Update(ctx, found)
+		if err != nil {
+			log.Error(err, "Failed to update Service", "Service.Namespace", found.Namespace, "Service.Name", found.Name)
+			return err
+		}
+	}
+	return nil
 }
 
-func timeGet(url string) time.Duration {
-	req, _ := http.NewRequest("GET", url, nil)
+// serviceForWebserver returns a webserver Service object, exposing the ports of the webserver container.
+// When the traffic goes through the activator, the Service has no selector, its Endpoints point to the activator.
+func (r *WebserverReconciler) serviceForWebserver(ws *webserverv1alpha1.Webserver, viaActivator bool) *corev1.Service {
+	ls := labelsForWebserver(ws.Name)
+	serviceType := ws.Spec.Service.Type
+	if serviceType == "" {
+		serviceType = corev1.ServiceTypeClusterIP
+	}
 
-	var start, connect, dns, tlsHandshake time.Time
+	var ports []corev1.ServicePort
+	for _, containerPort := range webserverContainerPorts() {
+		ports = append(ports, corev1.ServicePort{
+			Name:       containerPort.Name,
+			Port:       containerPort.ContainerPort,
+			TargetPort: intstr.FromString(containerPort.Name),
+			Protocol:   corev1.ProtocolTCP,
+		})
+	}
 
-	trace := &httptrace.ClientTrace{
-		DNSStart: func(dsi httptrace.DNSStartInfo) { dns = time.Now() },
-		DNSDone: func(ddi httptrace.DNSDoneInfo) {
-			fmt.Printf("DNS Done: %v\n", time.Since(dns))
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ws.Name,
+			Namespace: ws.Namespace,
+			Labels:    ls,
 		},
-
-		TLSHandshakeStart: func() { tlsHandshake = time.Now() },
-		TLSHandshakeDone: func(cs tls.ConnectionState, err error) {
-			fmt.Printf("TLS Handshake: %v\n", time.Since(tlsHandshake))
+		Spec: corev1.ServiceSpec{
+			Type:     serviceType,
+			Selector: ls,
+			Ports:    ports,
 		},
+	}
+	if viaActivator {
+		svc.Spec.Selector = nil
+	}
+	// Set Webserver instance as the owner and controller
+	ctrl.SetControllerReference(ws, svc, r.Scheme)
+	return svc
+}
 
-		ConnectStart: func(network, addr string) { connect = time.Now() },
-		ConnectDone: func(network, addr string, err error) {
-			fmt.Printf("Connect time: %v\n", time.Since(connect))
-		},
+// servicePortsMatch compares service ports, ignoring the node ports allocated by the cluster
+func servicePortsMatch(found, desired []corev1.ServicePort) bool {
+	if len(found) != len(desired) {
+		return false
+	}
+	for i := range found {
+		if found[i].Name != desired[i].Name || found[i].Port != desired[i].Port ||
+			found[i].TargetPort != desired[i].TargetPort || found[i].Protocol != desired[i].Protocol {
+			return false
+		}
+	}
+	return true
+}
 
-		GotFirstResponseByte: func() {
-			fmt.Printf("Time from start to first byte: %v\n", time.Since(start))
+// reconcileIngress creates, updates or deletes the Ingress of a Webserver, depending on spec.ingress
+func (r *WebserverReconciler) reconcileIngress(ctx context.Context, log logr.Logger, ws *webserverv1alpha1.Webserver) error {
+	found := &networkingv1beta1.Ingress{}
+	err := r.Get(ctx, types.NamespacedName{Name: ws.Name, Namespace: ws.Namespace}, found)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to get Ingress")
+		return err
+	}
+	exists := err == nil
+
+	if ws.Spec.Ingress == nil {
+		// Only delete an ingress we created ourselves
+		if exists && metav1.IsControlledBy(found, ws) {
+			log.Info("Deleting Ingress", "Ingress.Namespace", found.Namespace, "Ingress.Name", found.Name)
+			err = r.Delete(ctx, found)
+			if err != nil && !errors.IsNotFound(err) {
+				log.Error(err, "Failed to delete Ingress", "Ingress.Namespace", found.Namespace, "Ingress.Name", found.Name)
+				return err
+			}
+		}
+		return nil
+	}
+
+	ing := r.ingressForWebserver(ws)
+	if !exists {
+		log.Info("Creating a new Ingress", "Ingress.Namespace", ing.Namespace, "Ingress.Name", ing.Name)
+		err = r.Create(ctx, ing)
+		if err != nil {
+			log.Error(err, "Failed to create new Ingress", "Ingress.Namespace", ing.Namespace, "Ingress.Name", ing.Name)
+			return err
+		}
+		return nil
+	}
+
+	// Ensure the ingress spec and our annotations are the same as the spec in the CR.
+	// Annotations added by others, e.g. the ingress controller, are left alone.
+	changed := !reflect.DeepEqual(found.Spec, ing.Spec)
+	for key, value := range ing.Annotations {
+		if found.Annotations[key] != value {
+			changed = true
+		}
+	}
+	if changed {
+		found.Spec = ing.Spec
+		if found.Annotations == nil {
+			found.Annotations = map[string]string{}
+		}
+		for key, value := range ing.Annotations {
+			found.Annotations[key] = value
+		}
+		log.Info("Updating Ingress", "Ingress.Namespace", found.Namespace, "Ingress.Name", found.Name)
+		err = r.Update(ctx, found)
+		if err != nil {
+			log.Error(err, "Failed to update Ingress", "Ingress.Namespace", found.Namespace, "Ingress.Name", found.Name)
+			return err
+		}
+	}
+	return nil
+}
+
+// ingressForWebserver returns a webserver Ingress object, routing to the Service of the webserver
+func (r *WebserverReconciler) ingressForWebserver(ws *webserverv1alpha1.Webserver) *networkingv1beta1.Ingress {
+	spec := ws.Spec.Ingress
+	path := spec.Path
+	if path == "" {
+		path = "/"
+	}
+	pathType := networkingv1beta1.PathTypePrefix
+
+	ing := &networkingv1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        ws.Name,
+			Namespace:   ws.Namespace,
+			Labels:      labelsForWebserver(ws.Name),
+			Annotations: spec.Annotations,
 		},
+		Spec: networkingv1beta1.IngressSpec{
+			IngressClassName: spec.IngressClassName,
+			Rules: []networkingv1beta1.IngressRule{{
+				Host: spec.Host,
+				IngressRuleValue: networkingv1beta1.IngressRuleValue{
+					HTTP: &networkingv1beta1.HTTPIngressRuleValue{
+						Paths: []networkingv1beta1.HTTPIngressPath{{
+							Path:     path,
+							PathType: &pathType,
+							Backend: networkingv1beta1.IngressBackend{
+								ServiceName: ws.Name,
+								ServicePort: intstr.FromString(webserverPortName),
+							},
+						}},
+					},
+				},
+			}},
+		},
+	}
+	if spec.TLSSecretName != "" {
+		ingressTLS := networkingv1beta1.IngressTLS{SecretName: spec.TLSSecretName}
+		if spec.Host != "" {
+			ingressTLS.Hosts = []string{spec.Host}
+		}
+		ing.Spec.TLS = []networkingv1beta1.IngressTLS{ingressTLS}
+	}
+	// Set Webserver instance as the owner and controller
+	ctrl.SetControllerReference(ws, ing, r.Scheme)
+	return ing
+}
+
+// labelsForWebserver returns the labels for selecting the resources
+// belonging to the given webserver CR name.
+func labelsForWebserver(name string) map[string]string {
+	return map[string]string{"app": "webserver", "webserver_cr": name}
+}
+
+// latencyBreakdownFor converts a probe result to its representation in the Webserver status
+func latencyBreakdownFor(result *probeResult) *webserverv1alpha1.LatencyBreakdown {
+	return &webserverv1alpha1.LatencyBreakdown{
+		DNSMs:              result.DNS.Milliseconds(),
+		ConnectMs:          result.Connect.Milliseconds(),
+		TLSHandshakeMs:     result.TLSHandshake.Milliseconds(),
+		ServerProcessingMs: result.ServerProcessing.Milliseconds(),
+		FirstByteMs:        result.FirstByte.Milliseconds(),
+		TotalMs:            result.Total.Milliseconds(),
+		ProbeTime:          metav1.NewTime(result.Time),
 	}
+}
 
-	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
-	start = time.Now()
-	if _, err := http.DefaultTransport.RoundTrip(req); err != nil {
-		log.Fatal(err)
+// probeAddressForWebserver returns the host and port the prober measures the latency of the given Webserver
+// against, which is the Service created for it. The backend Service is probed when the webserver may scale
+// to zero, so the probes don't count as traffic in the activator
+func probeAddressForWebserver(key types.NamespacedName, scaleToZero bool) (string, int32) {
+	name := key.Name
+	if scaleToZero {
+		name = backendServiceName(key.Name)
 	}
+	return name + "." + key.Namespace + ".svc", webserverPort
+}
 
-	return time.Since(start)
+// probeAddressForPod returns the host and port the prober measures the latency of a single webserver pod against
+func probeAddressForPod(pod *corev1.Pod) (string, int32) {
+	port := webserverPort
+	for _, container := range pod.Spec.Containers {
+		for _, containerPort := range container.Ports {
+			if containerPort.Name == webserverPortName {
+				port = containerPort.ContainerPort
+			}
+		}
+	}
+	return pod.Status.PodIP, port
 }
 
 func (r *WebserverReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&webserverv1alpha1.Webserver{}). // these two replaces Watches(...) function that is used in older documentation and guides/blogs. Might be other functions that I can also use!
 		Owns(&appsv1.Deployment{}).          // these two replaces Watches(...) function that is used in older documentation and guides/blogs. Might be other functions that I can also use!
+		Owns(&corev1.Service{}).
+		Owns(&networkingv1beta1.Ingress{}).
+		Owns(&autoscalingv2beta2.HorizontalPodAutoscaler{}).
+		// Pass the new connection settings when the Memcached of a webserver changes
+		Watches(&source.Kind{Type: &webserverv1alpha1.Memcached{}}, &handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.webserversForMemcached)}).
+		Watches(&source.Kind{Type: &appsv1.Deployment{}}, &handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.webserversForMemcachedDeployment)}).
+		// The prober triggers a reconcile when the latency crosses a threshold
+		Watches(&source.Channel{Source: r.Prober.Events()}, &handler.EnqueueRequestForObject{}).
+		// The activator triggers a reconcile when a connection waits for a webserver scaled to zero
+		Watches(&source.Channel{Source: r.Activator.Events()}, &handler.EnqueueRequestForObject{}).
 		Complete(r)
 }
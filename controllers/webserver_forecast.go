@@ -0,0 +1,250 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	webserverv1alpha1 "github.com/example-inc/memcached-operator/api/v1alpha1"
+)
+
+const (
+	// historyBucket is the resolution of the latency history, at most one point is recorded per bucket
+	historyBucket = 5 * time.Minute
+	// historyKey is the key of the latency history in its ConfigMap
+	historyKey = "history.json"
+	// linearForecastPoints is the number of recent points the linear model is fitted to
+	linearForecastPoints = 12
+	// maxForecastSeasons is the number of past seasons the seasonal model averages
+	maxForecastSeasons = 4
+
+	defaultForecastHorizon = 15 * time.Minute
+	defaultForecastSeason  = 24 * time.Hour
+	defaultHistoryDays     = 7
+)
+
+// Models a forecast can come from
+const (
+	seasonalForecastModel = "Seasonal"
+	linearForecastModel   = "Linear"
+)
+
+// historyPoint is the latency and the number of replicas of a webserver at a point in time
+type historyPoint struct {
+	Time      int64 `json:"t"`
+	LatencyMs int64 `json:"latencyMs"`
+	Replicas  int32 `json:"replicas"`
+}
+
+// load is the latency multiplied by the number of replicas. Assuming the latency grows linearly with the
+// traffic per replica, the load stays the same when the number of replicas changes.
+func (p historyPoint) load() float64 {
+	return float64(p.LatencyMs) * float64(p.Replicas)
+}
+
+// historyConfigMapName returns the name of the ConfigMap the latency history of a Webserver is persisted in
+func historyConfigMapName(ws *webserverv1alpha1.Webserver) string {
+	return ws.Name + "-latency-history"
+}
+
+// forecast records the current latency in the persisted history of the Webserver, and forecasts the latency
+// at the configured horizon. It returns nil if the history is too short to forecast from.
+func (r *WebserverReconciler) forecast(ctx context.Context, log logr.Logger, ws *webserverv1alpha1.Webserver, latencyMs int64, replicas int32, now time.Time) (*webserverv1alpha1.LatencyForecast, error) {
+	spec := ws.Spec.Autoscaling.Predictive
+	horizon, season, historyDays := defaultForecastHorizon, defaultForecastSeason, defaultHistoryDays
+	if spec.HorizonMinutes > 0 {
+		horizon = time.Duration(spec.HorizonMinutes) * time.Minute
+	}
+	if spec.SeasonMinutes > 0 {
+		season = time.Duration(spec.SeasonMinutes) * time.Minute
+	}
+	if spec.HistoryDays > 0 {
+		historyDays = int(spec.HistoryDays)
+	}
+
+	// Check if the history exists, if not create it
+	cm := &corev1.ConfigMap{}
+	err := r.Get(ctx, types.NamespacedName{Name: historyConfigMapName(ws), Namespace: ws.Namespace}, cm)
+	if err != nil && errors.IsNotFound(err) {
+		cm = r.historyConfigMapForWebserver(ws)
+		log.Info("Creating a new ConfigMap", "ConfigMap.Namespace", cm.Namespace, "ConfigMap.Name", cm.Name)
+		if err = r.Create(ctx, cm); err != nil {
+			log.Error(err, "Failed to create new ConfigMap", "ConfigMap.Namespace", cm.Namespace, "ConfigMap.Name", cm.Name)
+			return nil, err
+		}
+	} else if err != nil {
+		log.Error(err, "Failed to get ConfigMap")
+		return nil, err
+	}
+
+	var history []historyPoint
+	if data := cm.Data[historyKey]; data != "" {
+		if err := json.Unmarshal([]byte(data), &history); err != nil {
+			// Start over rather than getting stuck on a history we can't read
+			log.Error(err, "Discarding unreadable latency history", "ConfigMap.Name", cm.Name)
+			history = nil
+		}
+	}
+
+	// Record at most one point per bucket, and drop the points that are too old
+	bucket := now.Truncate(historyBucket).Unix()
+	if len(history) == 0 || history[len(history)-1].Time < bucket {
+		history = append(history, historyPoint{Time: bucket, LatencyMs: latencyMs, Replicas: replicas})
+		oldest := now.Add(-time.Duration(historyDays) * 24 * time.Hour).Unix()
+		for len(history) > 0 && history[0].Time < oldest {
+			history = history[1:]
+		}
+		data, err := json.Marshal(history)
+		if err != nil {
+			return nil, err
+		}
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		cm.Data[historyKey] = string(data)
+		if err = r.Update(ctx, cm); err != nil {
+			log.Error(err, "Failed to update ConfigMap", "ConfigMap.Namespace", cm.Namespace, "ConfigMap.Name", cm.Name)
+			return nil, err
+		}
+	}
+
+	current := historyPoint{Time: now.Unix(), LatencyMs: latencyMs, Replicas: replicas}
+	target := now.Add(horizon)
+	load, model, ok := forecastLoad(history, current, target, season)
+	if !ok {
+		return nil, nil
+	}
+
+	recommended := forecastReplicas(load, replicas, spec.MaxReplicas)
+	predictedLatencyMs := int64(0)
+	if replicas > 0 {
+		predictedLatencyMs = int64(load / float64(replicas))
+	}
+	return &webserverv1alpha1.LatencyForecast{
+		PredictedLatencyMs:  predictedLatencyMs,
+		RecommendedReplicas: recommended,
+		Model:               model,
+		HistoryPoints:       int32(len(history)),
+		ForecastTime:        metav1.NewTime(target),
+	}, nil
+}
+
+// forecastReplicas returns the number of replicas that brings the forecast latency to the middle of the scaling
+// thresholds, up to maxReplicas, or twice the current replicas when maxReplicas is 0
+func forecastReplicas(load float64, current, maxReplicas int32) int32 {
+	targetLatencyMs := float64(latencyScaleUpLimit+latencyScaleDownLimit) / 2
+	if maxReplicas < 1 {
+		maxReplicas = 2 * current
+		if maxReplicas < 1 {
+			maxReplicas = 1
+		}
+	}
+	recommended := math.Ceil(load / targetLatencyMs)
+	if recommended > float64(maxReplicas) {
+		return maxReplicas
+	}
+	if recommended < 1 {
+		return 1
+	}
+	return int32(recommended)
+}
+
+// forecastLoad forecasts the load at target. It prefers a seasonal model, which takes the load at the same
+// time in previous seasons and corrects it by how much the current load differs from previous seasons.
+// Without enough history for that, it extrapolates a line fitted to the most recent points.
+func forecastLoad(history []historyPoint, current historyPoint, target time.Time, season time.Duration) (float64, string, bool) {
+	byBucket := map[int64]historyPoint{}
+	for _, p := range history {
+		byBucket[p.Time] = p
+	}
+	now := time.Unix(current.Time, 0)
+
+	var targetLoad, nowLoad float64
+	seasons := 0
+	for k := 1; k <= maxForecastSeasons; k++ {
+		offset := time.Duration(k) * season
+		past, ok := byBucket[target.Add(-offset).Truncate(historyBucket).Unix()]
+		if !ok {
+			continue
+		}
+		pastNow, ok := byBucket[now.Add(-offset).Truncate(historyBucket).Unix()]
+		if !ok {
+			continue
+		}
+		targetLoad += past.load()
+		nowLoad += pastNow.load()
+		seasons++
+	}
+	if seasons > 0 {
+		load := targetLoad/float64(seasons) + (current.load() - nowLoad/float64(seasons))
+		return math.Max(load, 0), seasonalForecastModel, true
+	}
+
+	// Only fit the line to the last hour or so, older points say little about the trend
+	var recent []historyPoint
+	since := now.Add(-linearForecastPoints * historyBucket).Unix()
+	for _, p := range history {
+		if p.Time >= since {
+			recent = append(recent, p)
+		}
+	}
+	if len(recent) < 3 {
+		return 0, "", false
+	}
+	// Least squares fit of the load over time
+	var sumX, sumY, sumXY, sumXX float64
+	for _, p := range recent {
+		x := float64(p.Time - current.Time)
+		y := p.load()
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+	n := float64(len(recent))
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0, "", false
+	}
+	slope := (n*sumXY - sumX*sumY) / denominator
+	intercept := (sumY - slope*sumX) / n
+	load := intercept + slope*float64(target.Unix()-current.Time)
+	return math.Max(load, 0), linearForecastModel, true
+}
+
+// historyConfigMapForWebserver returns an empty latency history ConfigMap object
+func (r *WebserverReconciler) historyConfigMapForWebserver(ws *webserverv1alpha1.Webserver) *corev1.ConfigMap {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      historyConfigMapName(ws),
+			Namespace: ws.Namespace,
+			Labels:    labelsForWebserver(ws.Name),
+		},
+		Data: map[string]string{},
+	}
+	// Set Webserver instance as the owner and controller
+	ctrl.SetControllerReference(ws, cm, r.Scheme)
+	return cm
+}
@@ -0,0 +1,201 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestForecastLoad(t *testing.T) {
+	now := time.Date(2020, time.June, 8, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	target := now.Add(30 * time.Minute)
+	// point records a load of latencyMs on 2 replicas at the given time
+	point := func(at time.Time, latencyMs int64) historyPoint {
+		return historyPoint{Time: at.Unix(), LatencyMs: latencyMs, Replicas: 2}
+	}
+
+	tests := []struct {
+		name      string
+		history   []historyPoint
+		current   historyPoint
+		want      float64
+		wantModel string
+		wantOk    bool
+	}{
+		{
+			name: "seasonal, one season",
+			history: []historyPoint{
+				point(now.Add(-day), 100),
+				point(target.Add(-day), 300),
+			},
+			current:   point(now, 250),
+			want:      900,
+			wantModel: seasonalForecastModel,
+			wantOk:    true,
+		},
+		{
+			name: "seasonal, averages the seasons",
+			history: []historyPoint{
+				point(now.Add(-day), 100),
+				point(target.Add(-day), 300),
+				point(now.Add(-2*day), 100),
+				point(target.Add(-2*day), 500),
+			},
+			current:   point(now, 250),
+			want:      1100,
+			wantModel: seasonalForecastModel,
+			wantOk:    true,
+		},
+		{
+			name: "seasonal, skips a season without the current bucket",
+			history: []historyPoint{
+				point(target.Add(-day), 300),
+				point(now.Add(-2*day), 100),
+				point(target.Add(-2*day), 500),
+			},
+			current:   point(now, 250),
+			want:      1300,
+			wantModel: seasonalForecastModel,
+			wantOk:    true,
+		},
+		{
+			name: "seasonal, truncates to the history bucket",
+			history: []historyPoint{
+				point(now.Add(-day).Truncate(historyBucket), 100),
+				point(target.Add(-day).Truncate(historyBucket), 300),
+			},
+			current:   point(now.Add(2*time.Minute), 250),
+			want:      900,
+			wantModel: seasonalForecastModel,
+			wantOk:    true,
+		},
+		{
+			name: "seasonal, never negative",
+			history: []historyPoint{
+				point(now.Add(-day), 500),
+				point(target.Add(-day), 50),
+			},
+			current:   point(now, 100),
+			want:      0,
+			wantModel: seasonalForecastModel,
+			wantOk:    true,
+		},
+		{
+			name: "linear, consecutive buckets",
+			history: []historyPoint{
+				point(now.Add(-10*time.Minute), 50),
+				point(now.Add(-5*time.Minute), 100),
+				point(now, 150),
+			},
+			current:   point(now, 150),
+			want:      900,
+			wantModel: linearForecastModel,
+			wantOk:    true,
+		},
+		{
+			name: "linear, gaps in the history",
+			history: []historyPoint{
+				point(now.Add(-20*time.Minute), 50),
+				point(now.Add(-10*time.Minute), 100),
+				point(now, 150),
+			},
+			current:   point(now, 150),
+			want:      600,
+			wantModel: linearForecastModel,
+			wantOk:    true,
+		},
+		{
+			name: "linear, a falling load is never negative",
+			history: []historyPoint{
+				point(now.Add(-10*time.Minute), 150),
+				point(now.Add(-5*time.Minute), 100),
+				point(now, 50),
+			},
+			current:   point(now, 50),
+			want:      0,
+			wantModel: linearForecastModel,
+			wantOk:    true,
+		},
+		{
+			name: "linear, ignores old points",
+			history: []historyPoint{
+				point(now.Add(-3*time.Hour), 50),
+				point(now.Add(-2*time.Hour), 100),
+				point(now.Add(-5*time.Minute), 100),
+				point(now, 150),
+			},
+			current: point(now, 150),
+			wantOk:  false,
+		},
+		{
+			name: "linear, too few points",
+			history: []historyPoint{
+				point(now.Add(-5*time.Minute), 100),
+				point(now, 150),
+			},
+			current: point(now, 150),
+			wantOk:  false,
+		},
+		{
+			name:    "no history",
+			current: point(now, 150),
+			wantOk:  false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, model, ok := forecastLoad(tt.history, tt.current, target, day)
+			if ok != tt.wantOk {
+				t.Fatalf("forecastLoad() ok = %v, want %v", ok, tt.wantOk)
+			}
+			if !ok {
+				return
+			}
+			if model != tt.wantModel {
+				t.Errorf("forecastLoad() model = %v, want %v", model, tt.wantModel)
+			}
+			if math.Abs(got-tt.want) > 1e-6 {
+				t.Errorf("forecastLoad() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForecastReplicas(t *testing.T) {
+	tests := []struct {
+		name        string
+		load        float64
+		current     int32
+		maxReplicas int32
+		want        int32
+	}{
+		{name: "brings the latency to the target", load: 1100, current: 1, want: 2},
+		{name: "at least one replica", load: 0, current: 3, want: 1},
+		{name: "at most twice the current replicas", load: 5500, current: 2, want: 4},
+		{name: "from zero replicas", load: 5500, current: 0, want: 1},
+		{name: "up to the maximum", load: 5500, current: 2, maxReplicas: 6, want: 6},
+		{name: "below the maximum", load: 5500, current: 2, maxReplicas: 20, want: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := forecastReplicas(tt.load, tt.current, tt.maxReplicas); got != tt.want {
+				t.Errorf("forecastReplicas() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Phases of a latency probe, used as the value of the "phase" label
+const (
+	probePhaseDNS              = "dns"
+	probePhaseConnect          = "connect"
+	probePhaseTLSHandshake     = "tls_handshake"
+	probePhaseServerProcessing = "server_processing"
+	probePhaseFirstByte        = "first_byte"
+	probePhaseTotal            = "total"
+)
+
+var probePhases = []string{
+	probePhaseDNS,
+	probePhaseConnect,
+	probePhaseTLSHandshake,
+	probePhaseServerProcessing,
+	probePhaseFirstByte,
+	probePhaseTotal,
+}
+
+var (
+	// probePhaseDuration is served on the metrics endpoint of the manager
+	probePhaseDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "webserver_probe_phase_duration_seconds",
+		Help:    "Duration of each phase of the latency probes of a Webserver",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 15),
+	}, []string{"namespace", "webserver", "phase"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(probePhaseDuration)
+}
+
+// observeProbeResult records the phases of a probe of the given Webserver.
+// Phases that did not happen during the probe are not recorded.
+func observeProbeResult(key types.NamespacedName, result probeResult) {
+	for _, phase := range probePhases {
+		if d := result.phase(phase); d > 0 {
+			probePhaseDuration.WithLabelValues(key.Namespace, key.Name, phase).Observe(d.Seconds())
+		}
+	}
+}
+
+// forgetProbeMetrics removes the metrics of a deleted Webserver
+func forgetProbeMetrics(key types.NamespacedName) {
+	for _, phase := range probePhases {
+		probePhaseDuration.DeleteLabelValues(key.Namespace, key.Name, phase)
+	}
+}
+
+// phase returns the duration of the named phase of the probe
+func (r probeResult) phase(name string) time.Duration {
+	switch name {
+	case probePhaseDNS:
+		return r.DNS
+	case probePhaseConnect:
+		return r.Connect
+	case probePhaseTLSHandshake:
+		return r.TLSHandshake
+	case probePhaseServerProcessing:
+		return r.ServerProcessing
+	case probePhaseFirstByte:
+		return r.FirstByte
+	case probePhaseTotal:
+		return r.Total
+	}
+	return 0
+}
@@ -0,0 +1,711 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	webserverv1alpha1 "github.com/example-inc/memcached-operator/api/v1alpha1"
+)
+
+const (
+	// defaultProbeInterval is used when a Webserver does not set spec.probe.intervalSeconds
+	defaultProbeInterval = 5 * time.Second
+	// defaultProbeWindowSize is used when a Webserver does not set spec.probe.windowSize
+	defaultProbeWindowSize = 12
+	// defaultOutlierPercent is used when a Webserver does not set spec.probe.podReplacement.outlierPercent
+	defaultOutlierPercent = 300
+	// defaultOutlierIntervals is used when a Webserver does not set spec.probe.podReplacement.consecutiveIntervals
+	defaultOutlierIntervals = 3
+	// defaultMinDeletionInterval is used when a Webserver does not set spec.probe.podReplacement.minDeletionIntervalSeconds
+	defaultMinDeletionInterval = 5 * time.Minute
+	// proberResyncInterval is how often the prober looks for added, changed or deleted Webservers
+	proberResyncInterval = 10 * time.Second
+	// proberEventBufferSize is the number of pending reconcile triggers before new ones are dropped
+	proberEventBufferSize = 100
+	// probeWarmup is how long a pod must have been ready before it is probed, so the slow first requests of a
+	// starting pod do not make it an outlier
+	probeWarmup = 30 * time.Second
+)
+
+// latencyState tells on which side of the scaling thresholds the aggregated latency is
+type latencyState int
+
+const (
+	latencyNormal latencyState = iota
+	latencyHigh
+	latencyLow
+)
+
+// latencySample is a single latency measurement
+type latencySample struct {
+	Time    time.Time
+	Latency time.Duration
+}
+
+// latencyWindow keeps the most recent samples of a target, oldest first
+type latencyWindow struct {
+	size    int
+	samples []latencySample
+}
+
+func (w *latencyWindow) add(s latencySample) {
+	w.samples = append(w.samples, s)
+	w.trim()
+}
+
+func (w *latencyWindow) resize(size int) {
+	w.size = size
+	w.trim()
+}
+
+func (w *latencyWindow) trim() {
+	if len(w.samples) > w.size {
+		w.samples = append([]latencySample(nil), w.samples[len(w.samples)-w.size:]...)
+	}
+}
+
+// mean returns the average latency of the window, and false if the window is empty
+func (w *latencyWindow) mean() (time.Duration, bool) {
+	if len(w.samples) == 0 {
+		return 0, false
+	}
+	var sum time.Duration
+	for _, s := range w.samples {
+		sum += s.Latency
+	}
+	return sum / time.Duration(len(w.samples)), true
+}
+
+// probeConfig is the probe configuration of a Webserver with defaults applied
+type probeConfig struct {
+	interval   time.Duration
+	windowSize int
+	mode       webserverv1alpha1.ProbeMode
+	// outlierPercent and outlierIntervals are zero when pod replacement is disabled
+	outlierPercent   int64
+	outlierIntervals int32
+	// spec selects the protocol of the probe
+	spec *webserverv1alpha1.ProbeSpec
+	// prometheus replaces the probe with a query when set
+	prometheus *webserverv1alpha1.PrometheusMetricSource
+	// scaleToZero is set when the webserver may have no pods, see webserver_scaletozero.go
+	scaleToZero bool
+	// activity tells when a webserver that may scale to zero has traffic
+	activity *webserverv1alpha1.ActivitySource
+}
+
+// probeTarget is the prober's bookkeeping for a single Webserver
+type probeTarget struct {
+	config probeConfig
+	stop   chan struct{}
+	window *latencyWindow
+	state  latencyState
+	// last is the most recent successful probe, or nil before the first one
+	last *probeResult
+	// pods is only used in Pods mode, and is keyed by pod name
+	pods map[string]*podTarget
+	// lastActivity is when the activity query last reported traffic
+	lastActivity time.Time
+}
+
+// podTarget is the prober's bookkeeping for a single pod of a Webserver
+type podTarget struct {
+	window           *latencyWindow
+	outlierIntervals int32
+	// failed is set when the latest probe of the pod failed or timed out
+	failed bool
+}
+
+// podLatency is a snapshot of the latency of a single pod
+type podLatency struct {
+	Name             string
+	Latency          time.Duration
+	OutlierIntervals int32
+}
+
+// LatencySummary is the aggregated latency of a Webserver, computed from the samples in the window of the prober
+type LatencySummary struct {
+	Name      string
+	Namespace string
+	Mean      time.Duration
+	P50       time.Duration
+	P90       time.Duration
+	P99       time.Duration
+	// Samples is the number of samples the summary is computed from
+	Samples int
+	// Time is when the most recent sample was taken
+	Time time.Time
+}
+
+// LatencyProber measures the latency of every Webserver in the background, each on its own
+// interval, and keeps a sliding window of samples per Webserver in memory.
+// It is registered with the manager through mgr.Add, and triggers a reconcile of a Webserver
+// through Events() whenever its aggregated latency crosses one of the scaling thresholds.
+type LatencyProber struct {
+	client.Client
+	Log logr.Logger
+
+	events chan event.GenericEvent
+
+	mu      sync.Mutex
+	targets map[types.NamespacedName]*probeTarget
+}
+
+// NewLatencyProber returns a LatencyProber that reads Webservers through the given client
+func NewLatencyProber(c client.Client, log logr.Logger) *LatencyProber {
+	return &LatencyProber{
+		Client:  c,
+		Log:     log,
+		events:  make(chan event.GenericEvent, proberEventBufferSize),
+		targets: map[types.NamespacedName]*probeTarget{},
+	}
+}
+
+// Events returns the channel the prober sends reconcile triggers on. Use it with a source.Channel.
+func (p *LatencyProber) Events() <-chan event.GenericEvent {
+	return p.events
+}
+
+// Latency returns the aggregated latency of the given Webserver, and false if there are no samples yet
+func (p *LatencyProber) Latency(key types.NamespacedName) (time.Duration, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t, ok := p.targets[key]
+	if !ok {
+		return 0, false
+	}
+	return t.window.mean()
+}
+
+// LatencySummaries returns the latency summary of every Webserver the prober has samples of
+func (p *LatencyProber) LatencySummaries() []LatencySummary {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var summaries []LatencySummary
+	for key, t := range p.targets {
+		mean, ok := t.window.mean()
+		if !ok {
+			continue
+		}
+		latencies := make([]time.Duration, len(t.window.samples))
+		for i, sample := range t.window.samples {
+			latencies[i] = sample.Latency
+		}
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		summaries = append(summaries, LatencySummary{
+			Name:      key.Name,
+			Namespace: key.Namespace,
+			Mean:      mean,
+			P50:       percentile(latencies, 50),
+			P90:       percentile(latencies, 90),
+			P99:       percentile(latencies, 99),
+			Samples:   len(latencies),
+			Time:      t.window.samples[len(t.window.samples)-1].Time,
+		})
+	}
+	return summaries
+}
+
+// LastProbe returns the phase breakdown of the most recent successful probe of the given Webserver,
+// or nil if it has not been probed yet
+func (p *LatencyProber) LastProbe(key types.NamespacedName) *probeResult {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t, ok := p.targets[key]
+	if !ok || t.last == nil {
+		return nil
+	}
+	last := *t.last
+	return &last
+}
+
+// PodLatencies returns the aggregated latency of every pod of the given Webserver, sorted by pod name.
+// It is empty unless the Webserver is probed in Pods mode.
+func (p *LatencyProber) PodLatencies(key types.NamespacedName) []podLatency {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t, ok := p.targets[key]
+	if !ok {
+		return nil
+	}
+	var latencies []podLatency
+	for name, pod := range t.pods {
+		mean, ok := pod.window.mean()
+		// A pod that never answered is only listed once it is an outlier
+		if !ok && pod.outlierIntervals == 0 {
+			continue
+		}
+		latencies = append(latencies, podLatency{Name: name, Latency: mean, OutlierIntervals: pod.outlierIntervals})
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i].Name < latencies[j].Name })
+	return latencies
+}
+
+// LastActivity returns when the activity query of the given Webserver last reported traffic, and false if it
+// never did
+func (p *LatencyProber) LastActivity(key types.NamespacedName) (time.Time, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t, ok := p.targets[key]
+	if !ok || t.lastActivity.IsZero() {
+		return time.Time{}, false
+	}
+	return t.lastActivity, true
+}
+
+// ForgetPod drops the samples of a pod, e.g. because it was deleted
+func (p *LatencyProber) ForgetPod(key types.NamespacedName, podName string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t, ok := p.targets[key]; ok {
+		delete(t.pods, podName)
+	}
+}
+
+// Start implements manager.Runnable. It blocks until stop is closed.
+func (p *LatencyProber) Start(stop <-chan struct{}) error {
+	ticker := time.NewTicker(proberResyncInterval)
+	defer ticker.Stop()
+	for {
+		p.syncTargets()
+		select {
+		case <-stop:
+			p.stopAll()
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// syncTargets starts probing new Webservers, restarts those whose interval changed and
+// stops probing deleted ones
+func (p *LatencyProber) syncTargets() {
+	webserverList := &webserverv1alpha1.WebserverList{}
+	if err := p.List(context.Background(), webserverList); err != nil {
+		p.Log.Error(err, "Failed to list Webservers")
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	seen := map[types.NamespacedName]bool{}
+	for i := range webserverList.Items {
+		ws := &webserverList.Items[i]
+		key := types.NamespacedName{Name: ws.Name, Namespace: ws.Namespace}
+		seen[key] = true
+
+		config := probeSettings(ws)
+		t, ok := p.targets[key]
+		if ok && t.config.interval == config.interval {
+			t.config = config
+			t.window.resize(config.windowSize)
+			for _, pod := range t.pods {
+				pod.window.resize(config.windowSize)
+			}
+			continue
+		}
+		if ok {
+			close(t.stop)
+		}
+		newTarget := &probeTarget{
+			config: config,
+			stop:   make(chan struct{}),
+			window: &latencyWindow{size: config.windowSize},
+			pods:   map[string]*podTarget{},
+		}
+		if ok {
+			// Keep the samples collected so far
+			newTarget.window.samples = append([]latencySample(nil), t.window.samples...)
+			newTarget.window.trim()
+			newTarget.state = t.state
+			newTarget.last = t.last
+			newTarget.lastActivity = t.lastActivity
+			for name, pod := range t.pods {
+				window := &latencyWindow{size: config.windowSize, samples: append([]latencySample(nil), pod.window.samples...)}
+				window.trim()
+				newTarget.pods[name] = &podTarget{window: window, outlierIntervals: pod.outlierIntervals, failed: pod.failed}
+			}
+		}
+		p.targets[key] = newTarget
+		p.Log.Info("Starting to probe Webserver", "webserver", key, "interval", config.interval)
+		go p.probeLoop(key, newTarget)
+	}
+
+	for key, t := range p.targets {
+		if !seen[key] {
+			p.Log.Info("Stopping to probe Webserver", "webserver", key)
+			close(t.stop)
+			delete(p.targets, key)
+			forgetProbeMetrics(key)
+		}
+	}
+}
+
+func (p *LatencyProber) stopAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, t := range p.targets {
+		close(t.stop)
+		delete(p.targets, key)
+	}
+}
+
+// probeLoop probes a single Webserver on its interval until the target is stopped
+func (p *LatencyProber) probeLoop(key types.NamespacedName, t *probeTarget) {
+	ticker := time.NewTicker(t.config.interval)
+	defer ticker.Stop()
+	for {
+		p.probe(key, t)
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *LatencyProber) probe(key types.NamespacedName, t *probeTarget) {
+	p.mu.Lock()
+	config := t.config
+	p.mu.Unlock()
+
+	if config.activity != nil {
+		p.queryActivity(key, t, config)
+	}
+	if config.prometheus != nil {
+		p.queryMetric(key, t, config)
+		return
+	}
+	if config.mode == webserverv1alpha1.PodsProbeMode {
+		p.probePods(key, t, config)
+		return
+	}
+
+	if config.scaleToZero {
+		// There is nothing to probe while the webserver is scaled to zero
+		dep := &appsv1.Deployment{}
+		if err := p.Get(context.Background(), key, dep); err == nil && dep.Spec.Replicas != nil && *dep.Spec.Replicas == 0 {
+			return
+		}
+	}
+	host, port := probeAddressForWebserver(key, config.scaleToZero)
+	result, err := runProbe(p.Client, key.Namespace, config.spec, host, port)
+	if err != nil {
+		p.Log.Error(err, "Failed to probe Webserver", "webserver", key)
+		return
+	}
+	observeProbeResult(key, result)
+
+	p.mu.Lock()
+	t.window.add(latencySample{Time: time.Now(), Latency: result.Total})
+	t.last = &result
+	crossed := p.updateState(t)
+	p.mu.Unlock()
+
+	if crossed {
+		p.trigger(key)
+	}
+}
+
+// queryMetric reads the latency of a Webserver from Prometheus, and feeds it into the same window
+// and thresholds as the probe
+func (p *LatencyProber) queryMetric(key types.NamespacedName, t *probeTarget, config probeConfig) {
+	latency, err := queryPrometheus(config.prometheus)
+	if err != nil {
+		p.Log.Error(err, "Failed to query Prometheus", "webserver", key)
+		return
+	}
+
+	p.mu.Lock()
+	t.window.add(latencySample{Time: time.Now(), Latency: latency})
+	// There is no phase breakdown for a query result
+	t.last = nil
+	crossed := p.updateState(t)
+	p.mu.Unlock()
+
+	if crossed {
+		p.trigger(key)
+	}
+}
+
+// queryActivity records when a Webserver that may scale to zero has traffic. A failed query counts as traffic,
+// so the webserver is not scaled to zero while its activity is unknown.
+func (p *LatencyProber) queryActivity(key types.NamespacedName, t *probeTarget, config probeConfig) {
+	rate, err := queryPrometheusValue(config.activity.Address, config.activity.Query, config.activity.TimeoutSeconds)
+	if err != nil {
+		p.Log.Error(err, "Failed to query the activity of the Webserver", "webserver", key)
+	}
+	if err != nil || rate > 0 {
+		p.mu.Lock()
+		t.lastActivity = time.Now()
+		p.mu.Unlock()
+	}
+}
+
+// probePods probes every running pod of a Webserver, and counts for how many intervals in a row
+// each pod has been a latency outlier compared to its peers, or failed to answer. The aggregated
+// latency gets one sample per interval, the median latency of the pods.
+func (p *LatencyProber) probePods(key types.NamespacedName, t *probeTarget, config probeConfig) {
+	podList := &corev1.PodList{}
+	listOpts := []client.ListOption{
+		client.InNamespace(key.Namespace),
+		client.MatchingLabels(labelsForWebserver(key.Name)),
+	}
+	if err := p.List(context.Background(), podList, listOpts...); err != nil {
+		p.Log.Error(err, "Failed to list pods", "webserver", key)
+		return
+	}
+
+	now := time.Now()
+	latencies := map[string]time.Duration{}
+	failed := map[string]bool{}
+	var last *probeResult
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if !probeablePod(pod, now) {
+			continue
+		}
+		host, port := probeAddressForPod(pod)
+		result, err := runProbe(p.Client, key.Namespace, config.spec, host, port)
+		if err != nil {
+			p.Log.Error(err, "Failed to probe pod", "webserver", key, "pod", pod.Name)
+			failed[pod.Name] = true
+			continue
+		}
+		observeProbeResult(key, result)
+		latencies[pod.Name] = result.Total
+		last = &result
+	}
+
+	p.mu.Lock()
+	if last != nil {
+		t.last = last
+	}
+	for name := range t.pods {
+		if _, ok := latencies[name]; !ok && !failed[name] {
+			delete(t.pods, name)
+		}
+	}
+	var all []time.Duration
+	for name := range failed {
+		if _, ok := t.pods[name]; !ok {
+			t.pods[name] = &podTarget{window: &latencyWindow{size: t.config.windowSize}}
+		}
+		t.pods[name].failed = true
+	}
+	for name, latency := range latencies {
+		pod, ok := t.pods[name]
+		if !ok {
+			pod = &podTarget{window: &latencyWindow{size: t.config.windowSize}}
+			t.pods[name] = pod
+		}
+		pod.failed = false
+		pod.window.add(latencySample{Time: now, Latency: latency})
+		all = append(all, latency)
+	}
+	// One sample per interval, so the window covers the same time whatever the number of pods
+	if len(all) > 0 {
+		t.window.add(latencySample{Time: now, Latency: median(all)})
+	}
+	replace := t.updateOutliers()
+	crossed := p.updateState(t)
+	p.mu.Unlock()
+
+	if crossed || replace {
+		p.trigger(key)
+	}
+}
+
+// probeablePod tells whether a pod is probed and counted in the latencies: it must be running, and have been
+// ready for the probe warm-up
+func probeablePod(pod *corev1.Pod, now time.Time) bool {
+	if pod.DeletionTimestamp != nil || pod.Status.Phase != corev1.PodRunning || pod.Status.PodIP == "" {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue && !now.Before(condition.LastTransitionTime.Add(probeWarmup))
+		}
+	}
+	return false
+}
+
+// updateOutliers compares the latency of every pod to the median latency of its peers. A pod whose
+// probe failed is an outlier too, whatever its earlier latency. It returns true if a pod has been an
+// outlier for long enough to be replaced. The caller must hold the lock of the prober.
+func (t *probeTarget) updateOutliers() bool {
+	if t.config.outlierPercent == 0 {
+		return false
+	}
+	means := map[string]time.Duration{}
+	for name, pod := range t.pods {
+		if mean, ok := pod.window.mean(); ok && !pod.failed {
+			means[name] = mean
+		}
+	}
+
+	replace := false
+	for name, pod := range t.pods {
+		if pod.failed {
+			// Only replace a failing pod while some of its peers answer, the whole webserver may be down otherwise
+			if len(means) > 0 {
+				pod.outlierIntervals++
+			} else {
+				pod.outlierIntervals = 0
+			}
+			if pod.outlierIntervals >= t.config.outlierIntervals {
+				replace = true
+			}
+			continue
+		}
+		mean, ok := means[name]
+		if !ok || len(means) < 2 {
+			pod.outlierIntervals = 0
+			continue
+		}
+		var peers []time.Duration
+		for peer, peerMean := range means {
+			if peer != name {
+				peers = append(peers, peerMean)
+			}
+		}
+		if int64(mean)*100 > int64(median(peers))*t.config.outlierPercent {
+			pod.outlierIntervals++
+		} else {
+			pod.outlierIntervals = 0
+		}
+		if pod.outlierIntervals >= t.config.outlierIntervals {
+			replace = true
+		}
+	}
+	return replace
+}
+
+// updateState compares the aggregated latency of a target to the scaling thresholds.
+// It returns true if the latency crossed into the scale up or scale down range.
+// The caller must hold the lock of the prober.
+func (p *LatencyProber) updateState(t *probeTarget) bool {
+	mean, ok := t.window.mean()
+	if !ok {
+		return false
+	}
+	state := latencyStateFor(mean.Milliseconds())
+	crossed := state != t.state
+	t.state = state
+	return crossed && state != latencyNormal
+}
+
+// trigger asks the Webserver controller to reconcile the given Webserver
+func (p *LatencyProber) trigger(key types.NamespacedName) {
+	evt := event.GenericEvent{Meta: &metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace}}
+	select {
+	case p.events <- evt:
+	default:
+		// The periodic resync of the controller will pick the Webserver up eventually
+		p.Log.Info("Reconcile trigger buffer is full, dropping event", "webserver", key)
+	}
+}
+
+// latencyStateFor compares a latency to the scaling thresholds
+func latencyStateFor(latencyMs int64) latencyState {
+	switch {
+	case latencyMs >= latencyScaleUpLimit:
+		return latencyHigh
+	case latencyMs <= latencyScaleDownLimit:
+		return latencyLow
+	default:
+		return latencyNormal
+	}
+}
+
+// probeSettings returns the probe configuration of a Webserver, with defaults applied
+func probeSettings(ws *webserverv1alpha1.Webserver) probeConfig {
+	config := probeConfig{
+		interval:    defaultProbeInterval,
+		windowSize:  defaultProbeWindowSize,
+		mode:        ws.Spec.Probe.Mode,
+		spec:        ws.Spec.Probe.DeepCopy(),
+		scaleToZero: scaleToZeroRequested(ws),
+	}
+	if config.scaleToZero {
+		config.activity = ws.Spec.Autoscaling.ScaleToZero.Activity.DeepCopy()
+	}
+	if ws.Spec.Probe.IntervalSeconds > 0 {
+		config.interval = time.Duration(ws.Spec.Probe.IntervalSeconds) * time.Second
+	}
+	if ws.Spec.Probe.WindowSize > 0 {
+		config.windowSize = int(ws.Spec.Probe.WindowSize)
+	}
+	if config.mode == "" {
+		config.mode = webserverv1alpha1.ServiceProbeMode
+	}
+	if ws.Spec.Metric.Type == webserverv1alpha1.PrometheusMetricSourceType && ws.Spec.Metric.Prometheus != nil {
+		config.prometheus = ws.Spec.Metric.Prometheus.DeepCopy()
+		// The query covers the whole webserver, there are no pods to tell apart
+		config.mode = webserverv1alpha1.ServiceProbeMode
+	}
+	if replacement := ws.Spec.Probe.PodReplacement; replacement != nil && config.mode == webserverv1alpha1.PodsProbeMode {
+		config.outlierPercent = defaultOutlierPercent
+		if replacement.OutlierPercent > 0 {
+			config.outlierPercent = int64(replacement.OutlierPercent)
+		}
+		config.outlierIntervals = defaultOutlierIntervals
+		if replacement.ConsecutiveIntervals > 0 {
+			config.outlierIntervals = replacement.ConsecutiveIntervals
+		}
+	}
+	return config
+}
+
+// minDeletionInterval returns the minimum time between two pod replacements of a Webserver
+func minDeletionInterval(replacement *webserverv1alpha1.PodReplacementSpec) time.Duration {
+	if replacement.MinDeletionIntervalSeconds == nil {
+		return defaultMinDeletionInterval
+	}
+	return time.Duration(*replacement.MinDeletionIntervalSeconds) * time.Second
+}
+
+// percentile returns the nearest-rank percentile of the given latencies, which must be sorted and not empty
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// median returns the median of the given latencies, which must not be empty
+func median(latencies []time.Duration) time.Duration {
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
@@ -0,0 +1,57 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestProbeablePod(t *testing.T) {
+	now := time.Date(2020, 6, 8, 12, 0, 0, 0, time.UTC)
+	readyPod := func(status corev1.ConditionStatus, since time.Duration) *corev1.Pod {
+		return &corev1.Pod{Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			PodIP: "10.0.0.1",
+			Conditions: []corev1.PodCondition{
+				{Type: corev1.PodReady, Status: status, LastTransitionTime: metav1.NewTime(now.Add(-since))},
+			},
+		}}
+	}
+	deleted := readyPod(corev1.ConditionTrue, time.Hour)
+	deleted.DeletionTimestamp = &metav1.Time{Time: now}
+
+	tests := []struct {
+		name string
+		pod  *corev1.Pod
+		want bool
+	}{
+		{name: "ready after the warm-up", pod: readyPod(corev1.ConditionTrue, probeWarmup), want: true},
+		{name: "ready during the warm-up", pod: readyPod(corev1.ConditionTrue, probeWarmup-time.Second), want: false},
+		{name: "not ready", pod: readyPod(corev1.ConditionFalse, time.Hour), want: false},
+		{name: "no ready condition", pod: &corev1.Pod{Status: corev1.PodStatus{Phase: corev1.PodRunning, PodIP: "10.0.0.1"}}, want: false},
+		{name: "terminating", pod: deleted, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := probeablePod(tt.pod, now); got != tt.want {
+				t.Errorf("probeablePod() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
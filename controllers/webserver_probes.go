@@ -0,0 +1,266 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	webserverv1alpha1 "github.com/example-inc/memcached-operator/api/v1alpha1"
+)
+
+// defaultProbeTimeout is used when a probe does not set timeoutSeconds
+const defaultProbeTimeout = 3 * time.Second
+
+// probeResult is the latency of a single probe, broken down into phases.
+// Phases that did not happen during the probe, e.g. DNS when probing an IP, are zero.
+type probeResult struct {
+	DNS          time.Duration
+	Connect      time.Duration
+	TLSHandshake time.Duration
+	// ServerProcessing is the time from the request being written until the first response byte
+	ServerProcessing time.Duration
+	// FirstByte is the time from the start of the request until the first response byte
+	FirstByte time.Duration
+	Total     time.Duration
+	// Time is when the probe finished
+	Time time.Time
+}
+
+// probeTransport opens a new connection for every probe, so the DNS, connect and TLS phases are measured every time
+var probeTransport = func() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DisableKeepAlives = true
+	return transport
+}()
+
+// runProbe measures the latency of the webserver listening on the given host and port, using the
+// protocol configured in the probe spec. Secrets referenced by the spec are read from namespace.
+func runProbe(c client.Client, namespace string, spec *webserverv1alpha1.ProbeSpec, host string, port int32) (probeResult, error) {
+	switch {
+	case spec.TCP != nil:
+		return tcpProbe(spec.TCP, probeAddress(host, port, spec.TCP.Port))
+	case spec.GRPC != nil:
+		return grpcProbe(spec.GRPC, probeAddress(host, port, spec.GRPC.Port))
+	case spec.HTTP != nil:
+		return httpProbe(c, namespace, spec.HTTP, probeAddress(host, port, spec.HTTP.Port))
+	default:
+		return httpProbe(c, namespace, &webserverv1alpha1.HTTPProbe{}, probeAddress(host, port, 0))
+	}
+}
+
+// probeAddress joins a host and a port, unless the probe overrides the port
+func probeAddress(host string, port, override int32) string {
+	if override > 0 {
+		port = override
+	}
+	return net.JoinHostPort(host, strconv.FormatInt(int64(port), 10))
+}
+
+func probeTimeout(timeoutSeconds int32) time.Duration {
+	if timeoutSeconds > 0 {
+		return time.Duration(timeoutSeconds) * time.Second
+	}
+	return defaultProbeTimeout
+}
+
+// httpProbe sends the configured HTTP(S) request and checks the status code of the response
+func httpProbe(c client.Client, namespace string, spec *webserverv1alpha1.HTTPProbe, address string) (probeResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout(spec.TimeoutSeconds))
+	defer cancel()
+
+	scheme := "http"
+	transport := probeTransport
+	if spec.Scheme == "HTTPS" {
+		scheme = "https"
+		tlsConfig, err := probeTLSConfig(ctx, c, namespace, spec.TLS)
+		if err != nil {
+			return probeResult{}, err
+		}
+		transport = probeTransport.Clone()
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	method := spec.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	if spec.Body != "" {
+		body = strings.NewReader(spec.Body)
+	}
+	req, err := http.NewRequest(method, scheme+"://"+address+spec.Path, body)
+	if err != nil {
+		return probeResult{}, err
+	}
+	for _, header := range spec.Headers {
+		if strings.EqualFold(header.Name, "Host") {
+			req.Host = header.Value
+			continue
+		}
+		req.Header.Add(header.Name, header.Value)
+	}
+
+	result, resp, err := timeRequest(transport, req.WithContext(ctx))
+	if err != nil {
+		return result, err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	if !expectedStatusCode(spec.ExpectedStatusCodes, resp.StatusCode) {
+		return result, fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, req.URL)
+	}
+	return result, nil
+}
+
+// timeRequest sends a request and measures its phases with httptrace
+func timeRequest(transport http.RoundTripper, req *http.Request) (probeResult, *http.Response, error) {
+	result := probeResult{}
+
+	var start, connect, dns, tlsHandshake, wroteRequest time.Time
+
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(dsi httptrace.DNSStartInfo) { dns = time.Now() },
+		DNSDone: func(ddi httptrace.DNSDoneInfo) {
+			result.DNS = time.Since(dns)
+		},
+
+		TLSHandshakeStart: func() { tlsHandshake = time.Now() },
+		TLSHandshakeDone: func(cs tls.ConnectionState, err error) {
+			result.TLSHandshake = time.Since(tlsHandshake)
+		},
+
+		ConnectStart: func(network, addr string) { connect = time.Now() },
+		ConnectDone: func(network, addr string, err error) {
+			result.Connect = time.Since(connect)
+		},
+
+		WroteRequest: func(wri httptrace.WroteRequestInfo) { wroteRequest = time.Now() },
+		GotFirstResponseByte: func() {
+			result.FirstByte = time.Since(start)
+			result.ServerProcessing = time.Since(wroteRequest)
+		},
+	}
+
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	start = time.Now()
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return result, nil, err
+	}
+	result.Time = time.Now()
+	result.Total = result.Time.Sub(start)
+
+	return result, resp, nil
+}
+
+// expectedStatusCode checks a status code against the expected ones, or against 2xx and 3xx if none are given
+func expectedStatusCode(expected []int32, code int) bool {
+	if len(expected) == 0 {
+		return code >= 200 && code < 400
+	}
+	for _, e := range expected {
+		if int(e) == code {
+			return true
+		}
+	}
+	return false
+}
+
+// probeTLSConfig builds the TLS configuration of an HTTPS probe
+func probeTLSConfig(ctx context.Context, c client.Client, namespace string, spec *webserverv1alpha1.ProbeTLS) (*tls.Config, error) {
+	config := &tls.Config{}
+	if spec == nil {
+		return config, nil
+	}
+	config.ServerName = spec.ServerName
+	config.InsecureSkipVerify = spec.InsecureSkipVerify
+
+	if ref := spec.CASecretRef; ref != nil {
+		secret := &corev1.Secret{}
+		if err := c.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: namespace}, secret); err != nil {
+			return nil, fmt.Errorf("failed to get CA Secret %s: %v", ref.Name, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(secret.Data[ref.Key]) {
+			return nil, fmt.Errorf("no PEM encoded certificates in key %s of Secret %s", ref.Key, ref.Name)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// tcpProbe measures the time it takes to open a TCP connection
+func tcpProbe(spec *webserverv1alpha1.TCPProbe, address string) (probeResult, error) {
+	dialer := &net.Dialer{Timeout: probeTimeout(spec.TimeoutSeconds)}
+	start := time.Now()
+	conn, err := dialer.Dial("tcp", address)
+	if err != nil {
+		return probeResult{}, err
+	}
+	result := probeResult{Time: time.Now()}
+	conn.Close()
+
+	result.Connect = result.Time.Sub(start)
+	result.Total = result.Connect
+	return result, nil
+}
+
+// grpcProbe connects to a gRPC server and calls the Check method of the standard health service
+func grpcProbe(spec *webserverv1alpha1.GRPCProbe, address string) (probeResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout(spec.TimeoutSeconds))
+	defer cancel()
+
+	result := probeResult{}
+	start := time.Now()
+	conn, err := grpc.DialContext(ctx, address, grpc.WithInsecure(), grpc.WithBlock())
+	if err != nil {
+		return result, err
+	}
+	defer conn.Close()
+	result.Connect = time.Since(start)
+
+	checkStart := time.Now()
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: spec.Service})
+	if err != nil {
+		return result, err
+	}
+	result.Time = time.Now()
+	result.ServerProcessing = result.Time.Sub(checkStart)
+	result.FirstByte = result.Time.Sub(start)
+	result.Total = result.FirstByte
+
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		return result, fmt.Errorf("gRPC health of %s is %s", address, resp.Status)
+	}
+	return result, nil
+}
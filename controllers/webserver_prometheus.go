@@ -0,0 +1,141 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	webserverv1alpha1 "github.com/example-inc/memcached-operator/api/v1alpha1"
+)
+
+// prometheusResponse is the envelope of every response of the Prometheus HTTP API
+type prometheusResponse struct {
+	Status    string         `json:"status"`
+	Data      prometheusData `json:"data"`
+	ErrorType string         `json:"errorType"`
+	Error     string         `json:"error"`
+}
+
+// prometheusData is the result of an instant query
+type prometheusData struct {
+	ResultType string          `json:"resultType"`
+	Result     json.RawMessage `json:"result"`
+}
+
+// prometheusSample is a single series of an instant vector
+type prometheusSample struct {
+	Metric map[string]string `json:"metric"`
+	// Value is a [<unix time>, "<value>"] pair
+	Value []interface{} `json:"value"`
+}
+
+// queryPrometheus runs the configured instant query, and returns its result as a latency.
+// Scalar results are used as is, for vectors the highest value of all series is used.
+func queryPrometheus(spec *webserverv1alpha1.PrometheusMetricSource) (time.Duration, error) {
+	latest, err := queryPrometheusValue(spec.Address, spec.Query, spec.TimeoutSeconds)
+	if err != nil {
+		return 0, err
+	}
+	if spec.Unit == "Milliseconds" {
+		return time.Duration(latest * float64(time.Millisecond)), nil
+	}
+	return time.Duration(latest * float64(time.Second)), nil
+}
+
+// queryPrometheusValue runs an instant query, and returns the highest value of its result
+func queryPrometheusValue(address, query string, timeoutSeconds int32) (float64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout(timeoutSeconds))
+	defer cancel()
+
+	endpoint := strings.TrimSuffix(address, "/") + "/api/v1/query?" + url.Values{"query": {query}}.Encode()
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// Prometheus answers with a JSON error body for bad queries, so decode it before looking at the status code
+	response := prometheusResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return 0, fmt.Errorf("failed to decode response of %s with status code %d: %v", address, resp.StatusCode, err)
+	}
+	if response.Status != "success" {
+		return 0, fmt.Errorf("query failed with %s: %s", response.ErrorType, response.Error)
+	}
+
+	var values []float64
+	switch response.Data.ResultType {
+	case "scalar":
+		var pair []interface{}
+		if err := json.Unmarshal(response.Data.Result, &pair); err != nil {
+			return 0, err
+		}
+		value, err := prometheusValue(pair)
+		if err != nil {
+			return 0, err
+		}
+		values = append(values, value)
+	case "vector":
+		var samples []prometheusSample
+		if err := json.Unmarshal(response.Data.Result, &samples); err != nil {
+			return 0, err
+		}
+		for _, sample := range samples {
+			value, err := prometheusValue(sample.Value)
+			if err != nil {
+				return 0, err
+			}
+			values = append(values, value)
+		}
+	default:
+		return 0, fmt.Errorf("query returned a %s, expected a scalar or an instant vector", response.Data.ResultType)
+	}
+
+	// NaN is what e.g. histogram_quantile returns without traffic, there is no latency to scale on then
+	latest := math.NaN()
+	for _, value := range values {
+		if !math.IsNaN(value) && (math.IsNaN(latest) || value > latest) {
+			latest = value
+		}
+	}
+	if math.IsNaN(latest) || math.IsInf(latest, 0) || latest < 0 {
+		return 0, fmt.Errorf("query returned no usable value")
+	}
+	return latest, nil
+}
+
+// prometheusValue parses a [<unix time>, "<value>"] pair
+func prometheusValue(pair []interface{}) (float64, error) {
+	if len(pair) != 2 {
+		return 0, fmt.Errorf("malformed sample %v", pair)
+	}
+	value, ok := pair[1].(string)
+	if !ok {
+		return 0, fmt.Errorf("malformed sample value %v", pair[1])
+	}
+	return strconv.ParseFloat(value, 64)
+}
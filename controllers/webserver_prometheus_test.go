@@ -0,0 +1,173 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	webserverv1alpha1 "github.com/example-inc/memcached-operator/api/v1alpha1"
+)
+
+// stubPrometheus serves a fixed body on the instant query endpoint of the Prometheus HTTP API,
+// and records the query it was sent
+func stubPrometheus(t *testing.T, statusCode int, body string, query *string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query" {
+			t.Errorf("unexpected request path %s", r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		*query = r.URL.Query().Get("query")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		w.Write([]byte(body))
+	}))
+}
+
+func TestQueryPrometheus(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		body       string
+		unit       string
+		want       time.Duration
+		wantErr    bool
+	}{
+		{
+			name:       "vector in seconds",
+			statusCode: http.StatusOK,
+			body:       `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1600000000.123,"0.25"]}]}}`,
+			want:       250 * time.Millisecond,
+		},
+		{
+			name:       "highest series of a vector",
+			statusCode: http.StatusOK,
+			body: `{"status":"success","data":{"resultType":"vector","result":[` +
+				`{"metric":{"pod":"a"},"value":[1600000000,"0.1"]},` +
+				`{"metric":{"pod":"b"},"value":[1600000000,"1.5"]},` +
+				`{"metric":{"pod":"c"},"value":[1600000000,"NaN"]}]}}`,
+			want: 1500 * time.Millisecond,
+		},
+		{
+			name:       "scalar in milliseconds",
+			statusCode: http.StatusOK,
+			body:       `{"status":"success","data":{"resultType":"scalar","result":[1600000000,"950"]}}`,
+			unit:       "Milliseconds",
+			want:       950 * time.Millisecond,
+		},
+		{
+			name:       "empty vector",
+			statusCode: http.StatusOK,
+			body:       `{"status":"success","data":{"resultType":"vector","result":[]}}`,
+			wantErr:    true,
+		},
+		{
+			name:       "only NaN",
+			statusCode: http.StatusOK,
+			body:       `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1600000000,"NaN"]}]}}`,
+			wantErr:    true,
+		},
+		{
+			name:       "range vector",
+			statusCode: http.StatusOK,
+			body:       `{"status":"success","data":{"resultType":"matrix","result":[]}}`,
+			wantErr:    true,
+		},
+		{
+			name:       "bad query",
+			statusCode: http.StatusBadRequest,
+			body:       `{"status":"error","errorType":"bad_data","error":"parse error"}`,
+			wantErr:    true,
+		},
+		{
+			name:       "not Prometheus",
+			statusCode: http.StatusBadGateway,
+			body:       `bad gateway`,
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var query string
+			server := stubPrometheus(t, tt.statusCode, tt.body, &query)
+			defer server.Close()
+
+			spec := &webserverv1alpha1.PrometheusMetricSource{
+				Address: server.URL + "/",
+				Query:   `histogram_quantile(0.95, sum(rate(latency_bucket[1m])) by (le))`,
+				Unit:    tt.unit,
+			}
+			got, err := queryPrometheus(spec)
+			if query != spec.Query {
+				t.Errorf("stub got query %q, want %q", query, spec.Query)
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("queryPrometheus() = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("queryPrometheus() failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("queryPrometheus() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryPrometheusFeedsThresholds(t *testing.T) {
+	var query string
+	server := stubPrometheus(t, http.StatusOK, `{"status":"success","data":{"resultType":"scalar","result":[1600000000,"1.2"]}}`, &query)
+	defer server.Close()
+
+	ws := &webserverv1alpha1.Webserver{}
+	ws.Name, ws.Namespace = "web", "default"
+	ws.Spec.Metric = webserverv1alpha1.MetricSourceSpec{
+		Type:       webserverv1alpha1.PrometheusMetricSourceType,
+		Prometheus: &webserverv1alpha1.PrometheusMetricSource{Address: server.URL, Query: "latency"},
+	}
+	config := probeSettings(ws)
+	if config.prometheus == nil {
+		t.Fatalf("probeSettings() did not select the Prometheus source")
+	}
+
+	prober := NewLatencyProber(nil, ctrl.Log.WithName("test"))
+	key := types.NamespacedName{Name: ws.Name, Namespace: ws.Namespace}
+	target := &probeTarget{config: config, window: &latencyWindow{size: config.windowSize}}
+	prober.targets[key] = target
+	prober.probe(key, target)
+
+	latency, ok := prober.Latency(key)
+	if !ok || latency != 1200*time.Millisecond {
+		t.Errorf("Latency() = %v, %v, want 1.2s, true", latency, ok)
+	}
+	select {
+	case evt := <-prober.Events():
+		if evt.Meta.GetName() != ws.Name {
+			t.Errorf("triggered reconcile of %s, want %s", evt.Meta.GetName(), ws.Name)
+		}
+	default:
+		t.Errorf("crossing the scale up threshold did not trigger a reconcile")
+	}
+}
@@ -0,0 +1,249 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	webserverv1alpha1 "github.com/example-inc/memcached-operator/api/v1alpha1"
+)
+
+const (
+	defaultIdleTimeout = 900 * time.Second
+	defaultWakeTimeout = 120 * time.Second
+)
+
+// scaleToZeroRequested returns true if a Webserver asks to be scaled to zero when idle, and tells when it has
+// traffic. It only is once the activator is ready to hold its traffic, see reconcileActivator.
+func scaleToZeroRequested(ws *webserverv1alpha1.Webserver) bool {
+	mode := ws.Spec.Autoscaling.Mode
+	return ws.Spec.Autoscaling.MinReplicas != nil && *ws.Spec.Autoscaling.MinReplicas == 0 &&
+		(mode == "" || mode == webserverv1alpha1.LatencyAutoscalingMode) && ws.Spec.Autoscaling.ScaleToZero.Activity != nil
+}
+
+// minReplicasFor returns the minimum size of a Webserver in Latency mode. It is only 0 when the webserver
+// can be scaled to zero.
+func minReplicasFor(ws *webserverv1alpha1.Webserver, scaleToZero bool) int32 {
+	minReplicas := ws.Spec.Size
+	if ws.Spec.Autoscaling.MinReplicas != nil {
+		minReplicas = *ws.Spec.Autoscaling.MinReplicas
+	}
+	if minReplicas < 1 && !scaleToZero {
+		minReplicas = 1
+	}
+	return minReplicas
+}
+
+// backendServiceName is the name of the Service selecting the pods of a Webserver whose own Service routes
+// to the activator
+func backendServiceName(name string) string {
+	return name + "-backend"
+}
+
+// reconcileActivator readies the activator to hold the traffic of a Webserver that asks for scale to zero: the
+// activator proxies to a backend Service selecting the webserver pods. It returns the port of the activator, or 0
+// when the webserver can't be scaled to zero. It also records when the webserver last had traffic.
+func (r *WebserverReconciler) reconcileActivator(ctx context.Context, log logr.Logger, ws *webserverv1alpha1.Webserver, now time.Time) (int32, error) {
+	key := types.NamespacedName{Name: ws.Name, Namespace: ws.Namespace}
+	if !scaleToZeroRequested(ws) {
+		if r.Activator != nil {
+			r.Activator.Deactivate(key)
+		}
+		ws.Status.ScaleToZero = nil
+		return 0, r.deleteBackendService(ctx, log, ws)
+	}
+
+	// The backend Service is what the prober measures, see probeAddressForWebserver
+	backend := r.serviceForWebserver(ws, false)
+	backend.Name = backendServiceName(ws.Name)
+	backend.Spec.Type = corev1.ServiceTypeClusterIP
+	if err := r.reconcileService(ctx, log, backend); err != nil {
+		return 0, err
+	}
+
+	if !r.Activator.Available() {
+		log.Info("Webserver asks for scale to zero, but the activator is not available")
+		ws.Status.ScaleToZero = &webserverv1alpha1.ScaleToZeroStatus{}
+		return 0, nil
+	}
+	wakeTimeout := defaultWakeTimeout
+	if seconds := ws.Spec.Autoscaling.ScaleToZero.WakeTimeoutSeconds; seconds > 0 {
+		wakeTimeout = time.Duration(seconds) * time.Second
+	}
+	backendAddress := net.JoinHostPort(backend.Name+"."+backend.Namespace+".svc", strconv.FormatInt(int64(webserverPort), 10))
+	port, err := r.Activator.Activate(key, backendAddress, wakeTimeout, activatorPortFor(ws))
+	if err != nil {
+		log.Error(err, "Failed to activate Webserver")
+		return 0, err
+	}
+
+	// The last activity is kept in the status, so the idle period survives a restart of the operator. A Webserver
+	// is only idle once it had the whole idle period to receive traffic
+	lastActivity := now
+	if previous := ws.Status.ScaleToZero; previous != nil && previous.LastActivityTime != nil {
+		lastActivity = previous.LastActivityTime.Time
+	}
+	if last, ok := r.Activator.LastActivity(key); ok && last.After(lastActivity) {
+		lastActivity = last
+	}
+	if last, ok := r.Prober.LastActivity(key); ok && last.After(lastActivity) {
+		lastActivity = last
+	}
+	lastActivityTime := metav1.NewTime(lastActivity)
+	ws.Status.ScaleToZero = &webserverv1alpha1.ScaleToZeroStatus{
+		Active:           true,
+		ActivatorAddress: net.JoinHostPort(r.Activator.IP, strconv.FormatInt(int64(port), 10)),
+		LastActivityTime: &lastActivityTime,
+	}
+	return port, nil
+}
+
+// activatorPortFor returns the port the activator listened on for a Webserver, or 0 if it never did
+func activatorPortFor(ws *webserverv1alpha1.Webserver) int32 {
+	if ws.Status.ScaleToZero == nil || ws.Status.ScaleToZero.ActivatorAddress == "" {
+		return 0
+	}
+	_, port, err := net.SplitHostPort(ws.Status.ScaleToZero.ActivatorAddress)
+	if err != nil {
+		return 0
+	}
+	value, err := strconv.ParseInt(port, 10, 32)
+	if err != nil {
+		return 0
+	}
+	return int32(value)
+}
+
+// reconcileRouting points the Service of a Webserver at the activator, or at the webserver pods
+func (r *WebserverReconciler) reconcileRouting(ctx context.Context, log logr.Logger, ws *webserverv1alpha1.Webserver, viaActivator bool, port int32) error {
+	if err := r.reconcileService(ctx, log, r.serviceForWebserver(ws, viaActivator)); err != nil {
+		return err
+	}
+	if viaActivator {
+		return r.reconcileActivatorEndpoints(ctx, log, ws, port)
+	}
+	return nil
+}
+
+// deleteBackendService deletes the backend Service of a Webserver that no longer asks for scale to zero
+func (r *WebserverReconciler) deleteBackendService(ctx context.Context, log logr.Logger, ws *webserverv1alpha1.Webserver) error {
+	found := &corev1.Service{}
+	err := r.Get(ctx, types.NamespacedName{Name: backendServiceName(ws.Name), Namespace: ws.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		log.Error(err, "Failed to get Service")
+		return err
+	}
+	// Only delete a service we created ourselves
+	if !metav1.IsControlledBy(found, ws) {
+		return nil
+	}
+	log.Info("Deleting Service", "Service.Namespace", found.Namespace, "Service.Name", found.Name)
+	if err := r.Delete(ctx, found); err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to delete Service", "Service.Namespace", found.Namespace, "Service.Name", found.Name)
+		return err
+	}
+	return nil
+}
+
+// reconcileActivatorEndpoints points the Service of a Webserver, which has no selector while its traffic goes
+// through the activator, at the port of the activator. The endpoints controller takes the Endpoints over again
+// once the Service selects the pods
+func (r *WebserverReconciler) reconcileActivatorEndpoints(ctx context.Context, log logr.Logger, ws *webserverv1alpha1.Webserver, port int32) error {
+	ep := &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ws.Name,
+			Namespace: ws.Namespace,
+			Labels:    labelsForWebserver(ws.Name),
+		},
+		Subsets: []corev1.EndpointSubset{{
+			Addresses: []corev1.EndpointAddress{{IP: r.Activator.IP}},
+			Ports: []corev1.EndpointPort{{
+				Name:     webserverPortName,
+				Port:     port,
+				Protocol: corev1.ProtocolTCP,
+			}},
+		}},
+	}
+	// Set Webserver instance as the owner and controller
+	ctrl.SetControllerReference(ws, ep, r.Scheme)
+
+	found := &corev1.Endpoints{}
+	err := r.Get(ctx, types.NamespacedName{Name: ep.Name, Namespace: ep.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		log.Info("Creating new Endpoints", "Endpoints.Namespace", ep.Namespace, "Endpoints.Name", ep.Name)
+		if err = r.Create(ctx, ep); err != nil {
+			log.Error(err, "Failed to create new Endpoints", "Endpoints.Namespace", ep.Namespace, "Endpoints.Name", ep.Name)
+			return err
+		}
+		return nil
+	} else if err != nil {
+		log.Error(err, "Failed to get Endpoints")
+		return err
+	}
+
+	// The endpoints controller left the Endpoints of the pods behind when the selector was removed
+	if !reflect.DeepEqual(found.Subsets, ep.Subsets) || !metav1.IsControlledBy(found, ws) {
+		found.Subsets = ep.Subsets
+		found.Labels = ep.Labels
+		found.OwnerReferences = ep.OwnerReferences
+		log.Info("Updating Endpoints", "Endpoints.Namespace", found.Namespace, "Endpoints.Name", found.Name)
+		if err = r.Update(ctx, found); err != nil {
+			log.Error(err, "Failed to update Endpoints", "Endpoints.Namespace", found.Namespace, "Endpoints.Name", found.Name)
+			return err
+		}
+	}
+	return nil
+}
+
+// idleScaling scales a Webserver that may scale to zero to zero once it has had no traffic for the idle period,
+// and back up while the activator holds connections for it. It returns false when neither applies, along with
+// how long until the webserver becomes idle.
+func (r *WebserverReconciler) idleScaling(ws *webserverv1alpha1.Webserver, current int32, now time.Time) (int32, string, bool, time.Duration) {
+	key := types.NamespacedName{Name: ws.Name, Namespace: ws.Namespace}
+	idleTimeout := defaultIdleTimeout
+	if seconds := ws.Spec.Autoscaling.ScaleToZero.IdleSeconds; seconds > 0 {
+		idleTimeout = time.Duration(seconds) * time.Second
+	}
+	// Set by reconcileActivator
+	idle := now.Sub(ws.Status.ScaleToZero.LastActivityTime.Time)
+
+	if current == 0 {
+		if r.Activator.Waiting(key) {
+			wake := ws.Spec.Size
+			if wake < 1 {
+				wake = 1
+			}
+			return wake, "A connection is waiting for the webserver, waking it up", true, 0
+		}
+		return 0, "No traffic for " + idle.Truncate(time.Second).String() + ", the webserver is scaled to zero", true, 0
+	}
+	if idle >= idleTimeout {
+		return 0, "No traffic for " + idle.Truncate(time.Second).String() + ", scaling to zero", true, 0
+	}
+	return current, "", false, idleTimeout - idle
+}
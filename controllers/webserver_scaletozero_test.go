@@ -0,0 +1,124 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	webserverv1alpha1 "github.com/example-inc/memcached-operator/api/v1alpha1"
+)
+
+func TestIdleScaling(t *testing.T) {
+	now := time.Date(2020, 6, 8, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		size          int32
+		idleSeconds   int32
+		idle          time.Duration
+		waiting       bool
+		current       int32
+		want          int32
+		wantApplies   bool
+		wantRemaining time.Duration
+	}{
+		{
+			name:          "traffic within the default idle timeout",
+			current:       3,
+			idle:          defaultIdleTimeout - time.Minute,
+			want:          3,
+			wantRemaining: time.Minute,
+		},
+		{
+			name:        "idle for the default idle timeout",
+			current:     3,
+			idle:        defaultIdleTimeout,
+			want:        0,
+			wantApplies: true,
+		},
+		{
+			name:          "traffic within a custom idle timeout",
+			idleSeconds:   60,
+			current:       2,
+			idle:          45 * time.Second,
+			want:          2,
+			wantRemaining: 15 * time.Second,
+		},
+		{
+			name:        "idle for a custom idle timeout",
+			idleSeconds: 60,
+			current:     2,
+			idle:        61 * time.Second,
+			want:        0,
+			wantApplies: true,
+		},
+		{
+			name:        "scaled to zero stays there",
+			current:     0,
+			idle:        time.Hour,
+			want:        0,
+			wantApplies: true,
+		},
+		{
+			name:        "a waiting connection wakes the webserver to its size",
+			size:        4,
+			current:     0,
+			idle:        time.Hour,
+			waiting:     true,
+			want:        4,
+			wantApplies: true,
+		},
+		{
+			name:        "a waiting connection wakes the webserver to at least one pod",
+			current:     0,
+			idle:        time.Hour,
+			waiting:     true,
+			want:        1,
+			wantApplies: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := types.NamespacedName{Name: "web", Namespace: "default"}
+			activator := NewActivator(nil, ctrl.Log.WithName("test"), "127.0.0.1")
+			if tt.waiting {
+				activator.targets[key] = &activatorTarget{waiting: 1}
+			}
+			r := &WebserverReconciler{Log: ctrl.Log.WithName("test"), Activator: activator}
+			ws := &webserverv1alpha1.Webserver{
+				ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace},
+				Spec: webserverv1alpha1.WebserverSpec{
+					Size: tt.size,
+					Autoscaling: webserverv1alpha1.WebserverAutoscalingSpec{
+						ScaleToZero: webserverv1alpha1.ScaleToZeroSpec{IdleSeconds: tt.idleSeconds},
+					},
+				},
+				Status: webserverv1alpha1.WebserverStatus{
+					ScaleToZero: &webserverv1alpha1.ScaleToZeroStatus{LastActivityTime: &metav1.Time{Time: now.Add(-tt.idle)}},
+				},
+			}
+
+			got, _, applies, remaining := r.idleScaling(ws, tt.current, now)
+			if got != tt.want || applies != tt.wantApplies || remaining != tt.wantRemaining {
+				t.Errorf("idleScaling() = %v, %v, %v, want %v, %v, %v", got, applies, remaining, tt.want, tt.wantApplies, tt.wantRemaining)
+			}
+		})
+	}
+}
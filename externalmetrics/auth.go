@@ -0,0 +1,106 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package externalmetrics
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	genericapifilters "k8s.io/apiserver/pkg/endpoints/filters"
+	"k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/server"
+	genericoptions "k8s.io/apiserver/pkg/server/options"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// +kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
+// +kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+// requestInfoResolver tells the resource, namespace and verb of a request, for authorization
+var requestInfoResolver = &request.RequestInfoFactory{
+	APIPrefixes:          sets.NewString("apis"),
+	GrouplessAPIPrefixes: sets.NewString("api"),
+}
+
+// delegatedServing configures the server the way the kube-apiserver expects from aggregated API servers. The
+// certificates of the clients, and the user the kube-apiserver passes on in request headers, are verified against
+// the extension-apiserver-authentication ConfigMap. Bearer tokens are checked with TokenReviews, and every
+// request is authorized with a SubjectAccessReview. The operator must run in the cluster.
+func (s *Server) delegatedServing() (*server.SecureServingInfo, authenticator.Request, authorizer.Authorizer, error) {
+	host, port, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid address %q: %v", s.Addr, err)
+	}
+	servingOptions := genericoptions.NewSecureServingOptions()
+	servingOptions.BindAddress = net.ParseIP(host)
+	if servingOptions.BindAddress == nil {
+		servingOptions.BindAddress = net.IPv4zero
+	}
+	if servingOptions.BindPort, err = strconv.Atoi(port); err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid address %q: %v", s.Addr, err)
+	}
+	servingOptions.MinTLSVersion = "VersionTLS12"
+	servingOptions.ServerCert.CertDirectory = ""
+	servingOptions.ServerCert.PairName = ""
+	if s.CertDir != "" {
+		servingOptions.ServerCert.CertKey.CertFile = filepath.Join(s.CertDir, "tls.crt")
+		servingOptions.ServerCert.CertKey.KeyFile = filepath.Join(s.CertDir, "tls.key")
+	} else if err := servingOptions.MaybeDefaultWithSelfSignedCerts("localhost", nil, nil); err != nil {
+		return nil, nil, nil, err
+	}
+	var serving *server.SecureServingInfo
+	if err := servingOptions.ApplyTo(&serving); err != nil {
+		return nil, nil, nil, err
+	}
+
+	authentication := &server.AuthenticationInfo{}
+	if err := genericoptions.NewDelegatingAuthenticationOptions().ApplyTo(authentication, serving, nil); err != nil {
+		return nil, nil, nil, err
+	}
+	authorization := &server.AuthorizationInfo{}
+	if err := genericoptions.NewDelegatingAuthorizationOptions().WithAlwaysAllowPaths("/healthz").ApplyTo(authorization); err != nil {
+		return nil, nil, nil, err
+	}
+	return serving, authentication.Authenticator, authorization.Authorizer, nil
+}
+
+// withAuth authenticates and authorizes the requests before passing them on to next
+func withAuth(next http.Handler, authn authenticator.Request, authz authorizer.Authorizer) http.Handler {
+	handler := genericapifilters.WithAuthorization(next, authz, scheme.Codecs)
+	handler = genericapifilters.WithAuthentication(handler, authn, genericapifilters.Unauthorized(scheme.Codecs, false), nil)
+	return genericapifilters.WithRequestInfo(handler, requestInfoResolver)
+}
+
+// canGetMetric asks the authorizer whether the user may read a metric in a namespace, with the verb of the request
+func (s *Server) canGetMetric(ctx context.Context, u user.Info, verb, namespace, metric string) (bool, error) {
+	decision, _, err := s.authorizer.Authorize(ctx, authorizer.AttributesRecord{
+		User:            u,
+		Verb:            verb,
+		Namespace:       namespace,
+		APIGroup:        groupName,
+		APIVersion:      "v1beta1",
+		Resource:        metric,
+		ResourceRequest: true,
+	})
+	return decision == authorizer.DecisionAllow, err
+}
@@ -0,0 +1,235 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package externalmetrics serves the latency measured by the operator through the external.metrics.k8s.io
+// API, so HorizontalPodAutoscalers in any namespace can scale on it. The server is registered with the
+// cluster as an aggregated API with an APIService, see config/externalmetrics.
+package externalmetrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/apiserver/pkg/endpoints/request"
+	externalmetricsv1beta1 "k8s.io/metrics/pkg/apis/external_metrics/v1beta1"
+
+	"github.com/example-inc/memcached-operator/controllers"
+)
+
+const (
+	groupName    = "external.metrics.k8s.io"
+	groupVersion = groupName + "/v1beta1"
+	apiPrefix    = "/apis/" + groupVersion
+
+	// Labels of every metric, to select the Webserver with a label selector in the HorizontalPodAutoscaler
+	webserverLabel          = "webserver"
+	webserverNamespaceLabel = "webserver_namespace"
+)
+
+// metrics are the names of the served metrics, and how to get their value in milliseconds from a summary
+var metrics = map[string]func(controllers.LatencySummary) time.Duration{
+	"webserver_latency_mean_ms": func(s controllers.LatencySummary) time.Duration { return s.Mean },
+	"webserver_latency_p50_ms":  func(s controllers.LatencySummary) time.Duration { return s.P50 },
+	"webserver_latency_p90_ms":  func(s controllers.LatencySummary) time.Duration { return s.P90 },
+	"webserver_latency_p99_ms":  func(s controllers.LatencySummary) time.Duration { return s.P99 },
+}
+
+// LatencySource provides the latency of the Webservers. It is implemented by controllers.LatencyProber.
+type LatencySource interface {
+	LatencySummaries() []controllers.LatencySummary
+}
+
+// Server is an external.metrics.k8s.io API server. It is registered with the manager through mgr.Add.
+// Authentication and authorization are delegated to the cluster, see auth.go.
+type Server struct {
+	// Addr is the address the server listens on, e.g. :6443
+	Addr string
+	// CertDir holds the serving certificate in tls.crt and tls.key. A self-signed certificate is
+	// generated when it is empty
+	CertDir string
+	Source  LatencySource
+	Log     logr.Logger
+
+	// authorizer authorizes the namespaces of the listed Webservers
+	authorizer authorizer.Authorizer
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. Only the leader has latency samples,
+// the prober runs there.
+func (s *Server) NeedLeaderElection() bool {
+	return true
+}
+
+// Start implements manager.Runnable. It blocks until stop is closed.
+func (s *Server) Start(stop <-chan struct{}) error {
+	serving, authn, authz, err := s.delegatedServing()
+	if err != nil {
+		return err
+	}
+	s.authorizer = authz
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) })
+	mux.HandleFunc("/apis", s.serveAPIs)
+	mux.HandleFunc("/apis/", s.serveAPIs)
+
+	s.Log.Info("Serving external metrics", "addr", s.Addr)
+	stopped, err := serving.Serve(withAuth(mux, authn, authz), 5*time.Second, stop)
+	if err != nil {
+		return err
+	}
+	<-stopped
+	return nil
+}
+
+// serveAPIs serves the discovery documents of the API group, and the metrics
+func (s *Server) serveAPIs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeStatus(w, http.StatusMethodNotAllowed, metav1.StatusReasonMethodNotAllowed, "only get is supported")
+		return
+	}
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	switch {
+	case path == "/apis":
+		writeJSON(w, &metav1.APIGroupList{
+			TypeMeta: metav1.TypeMeta{Kind: "APIGroupList", APIVersion: "v1"},
+			Groups:   []metav1.APIGroup{apiGroup()},
+		})
+	case path == "/apis/"+groupName:
+		group := apiGroup()
+		group.TypeMeta = metav1.TypeMeta{Kind: "APIGroup", APIVersion: "v1"}
+		writeJSON(w, &group)
+	case path == apiPrefix:
+		writeJSON(w, apiResourceList())
+	case strings.HasPrefix(path, apiPrefix+"/namespaces/"):
+		// /apis/external.metrics.k8s.io/v1beta1/namespaces/<namespace>/<metric>
+		parts := strings.Split(strings.TrimPrefix(path, apiPrefix+"/namespaces/"), "/")
+		if len(parts) != 2 {
+			writeStatus(w, http.StatusNotFound, metav1.StatusReasonNotFound, "not found")
+			return
+		}
+		s.serveMetric(w, r, parts[0], parts[1])
+	default:
+		writeStatus(w, http.StatusNotFound, metav1.StatusReasonNotFound, "not found")
+	}
+}
+
+// serveMetric lists the values of a metric for every Webserver matching the label selector of the request.
+// The Webservers are selected from all namespaces the user may read the metric in, the namespace of the request
+// was already authorized by withAuth.
+func (s *Server) serveMetric(w http.ResponseWriter, r *http.Request, namespace, name string) {
+	value, ok := metrics[name]
+	if !ok {
+		writeStatus(w, http.StatusNotFound, metav1.StatusReasonNotFound, "metric "+name+" not found")
+		return
+	}
+	selector, err := labels.Parse(r.URL.Query().Get("labelSelector"))
+	if err != nil {
+		writeStatus(w, http.StatusBadRequest, metav1.StatusReasonBadRequest, err.Error())
+		return
+	}
+
+	list := &externalmetricsv1beta1.ExternalMetricValueList{
+		TypeMeta: metav1.TypeMeta{Kind: "ExternalMetricValueList", APIVersion: groupVersion},
+		Items:    []externalmetricsv1beta1.ExternalMetricValue{},
+	}
+	u, _ := request.UserFrom(r.Context())
+	info, _ := request.RequestInfoFrom(r.Context())
+	allowed := map[string]bool{namespace: true}
+	for _, summary := range s.Source.LatencySummaries() {
+		metricLabels := map[string]string{
+			webserverLabel:          summary.Name,
+			webserverNamespaceLabel: summary.Namespace,
+		}
+		if !selector.Matches(labels.Set(metricLabels)) {
+			continue
+		}
+		canGet, ok := allowed[summary.Namespace]
+		if !ok {
+			canGet, err = s.canGetMetric(r.Context(), u, info.Verb, summary.Namespace, name)
+			if err != nil {
+				s.Log.Error(err, "Failed to authorize request", "user", u.GetName(), "namespace", summary.Namespace)
+				writeStatus(w, http.StatusInternalServerError, metav1.StatusReasonInternalError, "authorization failed")
+				return
+			}
+			allowed[summary.Namespace] = canGet
+		}
+		if !canGet {
+			continue
+		}
+		list.Items = append(list.Items, externalmetricsv1beta1.ExternalMetricValue{
+			MetricName:   name,
+			MetricLabels: metricLabels,
+			Timestamp:    metav1.NewTime(summary.Time),
+			Value:        *resource.NewQuantity(value(summary).Milliseconds(), resource.DecimalSI),
+		})
+	}
+	writeJSON(w, list)
+}
+
+func apiGroup() metav1.APIGroup {
+	version := metav1.GroupVersionForDiscovery{GroupVersion: groupVersion, Version: "v1beta1"}
+	return metav1.APIGroup{
+		Name:             groupName,
+		Versions:         []metav1.GroupVersionForDiscovery{version},
+		PreferredVersion: version,
+	}
+}
+
+func apiResourceList() *metav1.APIResourceList {
+	list := &metav1.APIResourceList{
+		TypeMeta:     metav1.TypeMeta{Kind: "APIResourceList", APIVersion: "v1"},
+		GroupVersion: groupVersion,
+	}
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		list.APIResources = append(list.APIResources, metav1.APIResource{
+			Name:       name,
+			Namespaced: true,
+			Kind:       "ExternalMetricValueList",
+			Verbs:      metav1.Verbs{"get"},
+		})
+	}
+	return list
+}
+
+func writeJSON(w http.ResponseWriter, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(obj)
+}
+
+// writeStatus writes an error the way the Kubernetes API does
+func writeStatus(w http.ResponseWriter, code int, reason metav1.StatusReason, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(&metav1.Status{
+		TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+		Status:   metav1.StatusFailure,
+		Code:     int32(code),
+		Reason:   reason,
+		Message:  message,
+	})
+}
@@ -0,0 +1,150 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package externalmetrics
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	externalmetricsv1beta1 "k8s.io/metrics/pkg/apis/external_metrics/v1beta1"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/example-inc/memcached-operator/controllers"
+)
+
+type fakeSource []controllers.LatencySummary
+
+func (f fakeSource) LatencySummaries() []controllers.LatencySummary {
+	return f
+}
+
+func TestServeMetric(t *testing.T) {
+	source := fakeSource{
+		{Name: "web", Namespace: "team-a", Mean: 100 * time.Millisecond, Time: time.Unix(1591617600, 0)},
+		{Name: "web", Namespace: "team-b", Mean: 200 * time.Millisecond, Time: time.Unix(1591617600, 0)},
+		{Name: "web", Namespace: "team-c", Mean: 300 * time.Millisecond, Time: time.Unix(1591617600, 0)},
+		{Name: "api", Namespace: "team-a", Mean: 400 * time.Millisecond, Time: time.Unix(1591617600, 0)},
+	}
+	// The token is the name of the user, without a token the request is not authenticated
+	authn := authenticator.RequestFunc(func(r *http.Request) (*authenticator.Response, bool, error) {
+		name := r.Header.Get("Authorization")
+		if name == "" {
+			return nil, false, nil
+		}
+		return &authenticator.Response{User: &user.DefaultInfo{Name: name}}, true, nil
+	})
+	// alice reads team-a and team-b, bob reads team-a only, and the authorizer fails on team-c for carol
+	authz := authorizer.AuthorizerFunc(func(a authorizer.Attributes) (authorizer.Decision, string, error) {
+		allowed := map[string][]string{"alice": {"team-a", "team-b"}, "bob": {"team-a"}, "carol": {"team-a"}}
+		if a.GetUser().GetName() == "carol" && a.GetNamespace() == "team-c" {
+			return authorizer.DecisionNoOpinion, "", errors.New("authorizer unavailable")
+		}
+		if a.GetVerb() != "list" || a.GetAPIGroup() != groupName || a.GetResource() != "webserver_latency_mean_ms" {
+			return authorizer.DecisionNoOpinion, "", nil
+		}
+		for _, namespace := range allowed[a.GetUser().GetName()] {
+			if namespace == a.GetNamespace() {
+				return authorizer.DecisionAllow, "", nil
+			}
+		}
+		return authorizer.DecisionNoOpinion, "", nil
+	})
+
+	tests := []struct {
+		name          string
+		user          string
+		path          string
+		wantStatus    int
+		wantWebserver []string
+	}{
+		{
+			name:       "not authenticated",
+			path:       "/apis/external.metrics.k8s.io/v1beta1/namespaces/team-a/webserver_latency_mean_ms",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "namespace of the request denied",
+			user:       "bob",
+			path:       "/apis/external.metrics.k8s.io/v1beta1/namespaces/team-b/webserver_latency_mean_ms",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:          "only the readable namespaces",
+			user:          "alice",
+			path:          "/apis/external.metrics.k8s.io/v1beta1/namespaces/team-a/webserver_latency_mean_ms",
+			wantStatus:    http.StatusOK,
+			wantWebserver: []string{"team-a/api", "team-a/web", "team-b/web"},
+		},
+		{
+			name:          "webservers in other namespaces are hidden",
+			user:          "bob",
+			path:          "/apis/external.metrics.k8s.io/v1beta1/namespaces/team-a/webserver_latency_mean_ms?labelSelector=webserver%3Dweb",
+			wantStatus:    http.StatusOK,
+			wantWebserver: []string{"team-a/web"},
+		},
+		{
+			name:       "authorization of another namespace fails",
+			user:       "carol",
+			path:       "/apis/external.metrics.k8s.io/v1beta1/namespaces/team-a/webserver_latency_mean_ms",
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "unknown metric",
+			user:       "alice",
+			path:       "/apis/external.metrics.k8s.io/v1beta1/namespaces/team-a/unknown",
+			wantStatus: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Source: source, Log: ctrl.Log.WithName("test"), authorizer: authz}
+			handler := withAuth(http.HandlerFunc(s.serveAPIs), authn, authz)
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.user != "" {
+				r.Header.Set("Authorization", tt.user)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %v, want %v: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			list := &externalmetricsv1beta1.ExternalMetricValueList{}
+			if err := json.Unmarshal(w.Body.Bytes(), list); err != nil {
+				t.Fatalf("failed to decode the response: %v", err)
+			}
+			var got []string
+			for _, item := range list.Items {
+				got = append(got, item.MetricLabels[webserverNamespaceLabel]+"/"+item.MetricLabels[webserverLabel])
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.wantWebserver) {
+				t.Errorf("webservers = %v, want %v", got, tt.wantWebserver)
+			}
+		})
+	}
+}
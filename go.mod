@@ -6,8 +6,13 @@ require (
 	github.com/go-logr/logr v0.1.0
 	github.com/onsi/ginkgo v1.11.0
 	github.com/onsi/gomega v1.8.1
+	github.com/prometheus/client_golang v1.0.0
+	github.com/robfig/cron/v3 v3.0.1
+	google.golang.org/grpc v1.26.0
 	k8s.io/api v0.18.2
 	k8s.io/apimachinery v0.18.2
+	k8s.io/apiserver v0.18.2
 	k8s.io/client-go v0.18.2
+	k8s.io/metrics v0.18.2
 	sigs.k8s.io/controller-runtime v0.6.0
-)
\ No newline at end of file
+)
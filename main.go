@@ -22,6 +22,7 @@ import (
 	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
@@ -30,6 +31,7 @@ import (
 
 	cachev1alpha1 "github.com/example-inc/memcached-operator/api/v1alpha1"
 	"github.com/example-inc/memcached-operator/controllers"
+	"github.com/example-inc/memcached-operator/externalmetrics"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -48,7 +50,17 @@ func init() {
 func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
+	var externalMetricsAddr, externalMetricsCertDir string
+	var activatorIP string
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8088", "The address the metric endpoint binds to.")
+	flag.StringVar(&externalMetricsAddr, "external-metrics-addr", "",
+		"The address the external metrics API binds to, e.g. :6443. The API is disabled when empty.")
+	flag.StringVar(&externalMetricsCertDir, "external-metrics-cert-dir", "",
+		"The directory with the tls.crt and tls.key of the external metrics API. "+
+			"A self-signed certificate is generated when empty.")
+	flag.StringVar(&activatorIP, "activator-ip", os.Getenv("POD_IP"),
+		"The IP the Services of Webservers that scale to zero route to, the IP of the operator pod. "+
+			"Scale to zero is disabled when empty.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
@@ -81,24 +93,76 @@ func main() {
 		Client: mgr.GetClient(),
 		Log:    ctrl.Log.WithName("controllers").WithName("Memcached"),
 		Scheme: mgr.GetScheme(),
+		Clock:  clock.RealClock{},
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Memcached")
 		os.Exit(1)
 	}
 
+	/**
+	* The prober measures the latency of every Webserver in the background, decoupled from Reconcile
+	 */
+	prober := controllers.NewLatencyProber(mgr.GetClient(), ctrl.Log.WithName("prober").WithName("Webserver"))
+	if err = mgr.Add(prober); err != nil {
+		setupLog.Error(err, "unable to add prober", "prober", "Webserver")
+		os.Exit(1)
+	}
+
+	/**
+	* The activator holds the connections to Webservers scaled to zero while they scale up again
+	 */
+	activator := controllers.NewActivator(mgr.GetClient(), ctrl.Log.WithName("activator").WithName("Webserver"), activatorIP)
+	if err = mgr.Add(activator); err != nil {
+		setupLog.Error(err, "unable to add activator", "activator", "Webserver")
+		os.Exit(1)
+	}
+
 	/**
 	* The watcher for Webserver CR is added to the Operator
 	 */
 	if err = (&controllers.WebserverReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("Webserver"),
+		Scheme:   mgr.GetScheme(),
+		Prober:   prober,
+		Clock:    clock.RealClock{},
+		Recorder: mgr.GetEventRecorderFor("webserver-controller"),
+		// Scaling decisions are logged as JSON, whatever the mode of the main logger, so they can be collected
+		AuditLog:  zap.New(zap.UseDevMode(false)).WithName("audit").WithName("Webserver"),
+		Activator: activator,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Webserver")
+		os.Exit(1)
+	}
+
+	/**
+	* The watcher for McRouter CR is added to the Operator
+	 */
+	if err = (&controllers.McRouterReconciler{
 		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("Webserver"),
+		Log:    ctrl.Log.WithName("controllers").WithName("McRouter"),
 		Scheme: mgr.GetScheme(),
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "Webserver")
+		setupLog.Error(err, "unable to create controller", "controller", "McRouter")
 		os.Exit(1)
 	}
 	// +kubebuilder:scaffold:builder
 
+	/**
+	* The latency measured by the prober is served to HorizontalPodAutoscalers through the external metrics API
+	 */
+	if externalMetricsAddr != "" {
+		if err = mgr.Add(&externalmetrics.Server{
+			Addr:    externalMetricsAddr,
+			CertDir: externalMetricsCertDir,
+			Source:  prober,
+			Log:     ctrl.Log.WithName("externalmetrics"),
+		}); err != nil {
+			setupLog.Error(err, "unable to add external metrics server")
+			os.Exit(1)
+		}
+	}
+
 	/**
 	* Starts the Operator to actually watch for changes to the CRs
 	 */